@@ -17,6 +17,7 @@ limitations under the License.
 package v1
 
 import (
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -25,23 +26,129 @@ import (
 
 // EmailSpec defines the desired state of Email
 type EmailSpec struct {
-	// INSERT ADDITIONAL SPEC FIELDS - desired state of cluster
-	// Important: Run "make" to regenerate code after modifying this file
+	// To are the addresses the email is for, as in "Jane Doe <jane@example.com>"
+	// or "jane@example.com".
+	//+kubebuilder:validation:MinItems=1
+	To []string `json:"to"`
 
-	// Foo is an example field of Email. Edit email_types.go to remove/update
-	Foo string `json:"foo,omitempty"`
+	// Cc are addresses the email is copied to, in the open.
+	Cc []string `json:"cc,omitempty"`
+
+	// Bcc are addresses the email is copied to without the other recipients
+	// knowing.
+	Bcc []string `json:"bcc,omitempty"`
+
+	// From is the address the email is sent from.
+	From string `json:"from"`
+
+	Subject string `json:"subject,omitempty"`
+
+	// Text is the plain-text body of the email.
+	Text string `json:"text,omitempty"`
+
+	// HTML is the HTML body of the email. Emails with both bodies let the mail
+	// client pick.
+	HTML string `json:"html,omitempty"`
+
+	// SMTPRef names the SMTPServer, in the namespace of the Email, to send the
+	// email through.
+	SMTPRef corev1.LocalObjectReference `json:"smtpRef"`
+
+	// Template renders the subject and bodies of the email from an
+	// EmailTemplate. Subject, Text and HTML set on the Email take precedence
+	// over those of the template.
+	Template *EmailTemplateReference `json:"template,omitempty"`
+
+	// MaxAttempts is how many times sending the email is attempted. Only
+	// delivery the SMTP server turned down for the time being, or that could
+	// not reach it, is attempted again.
+	//+kubebuilder:default=5
+	//+kubebuilder:validation:Minimum=1
+	//+optional
+	MaxAttempts int32 `json:"maxAttempts,omitempty"`
+}
+
+// EmailTemplateReference is the EmailTemplate an Email is rendered from, and
+// the values of its variables.
+type EmailTemplateReference struct {
+	// Name is the name of the EmailTemplate, in the namespace of the Email.
+	Name string `json:"name"`
+
+	// Variables set the parameters of the template.
+	Variables []EmailVariable `json:"variables,omitempty"`
+}
+
+// EmailVariable is the value of a parameter of an EmailTemplate.
+type EmailVariable struct {
+	Name string `json:"name"`
+
+	Value string `json:"value,omitempty"`
+
+	// ValueFrom reads the value from a ConfigMap or a Secret in the namespace
+	// of the Email, instead.
+	ValueFrom *EmailVariableSource `json:"valueFrom,omitempty"`
+}
+
+// EmailVariableSource is where the value of a variable is read from. Only one
+// of its fields may be set.
+type EmailVariableSource struct {
+	ConfigMapKeyRef *corev1.ConfigMapKeySelector `json:"configMapKeyRef,omitempty"`
+	SecretKeyRef    *corev1.SecretKeySelector    `json:"secretKeyRef,omitempty"`
 }
 
+// EmailPhase is where an Email is in its delivery.
+//+kubebuilder:validation:Enum=Pending;Sending;Sent;Failed
+type EmailPhase string
+
+const (
+	// EmailPending is an Email that was not sent yet.
+	EmailPending = EmailPhase("Pending")
+	// EmailSending is an Email being handed over to the SMTP server.
+	EmailSending = EmailPhase("Sending")
+	// EmailSent is an Email the SMTP server accepted.
+	EmailSent = EmailPhase("Sent")
+	// EmailFailed is an Email that will not be sent.
+	EmailFailed = EmailPhase("Failed")
+)
+
+const (
+	// ConditionRendered is true when the template of the Email rendered. It is
+	// only set on Emails with a template.
+	ConditionRendered = "Rendered"
+)
+
 // EmailStatus defines the observed state of Email
 type EmailStatus struct {
-	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
-	// Important: Run "make" to regenerate code after modifying this file
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+
+	Phase EmailPhase `json:"phase,omitempty"`
+
+	// MessageID is the Message-ID header of the email.
+	MessageID string `json:"messageID,omitempty"`
+
+	// Attempts is the number of times sending the email was attempted.
+	Attempts int32 `json:"attempts,omitempty"`
+
+	// LastError is what went wrong last, if anything did.
+	LastError string `json:"lastError,omitempty"`
+
+	// NextAttemptTime is when sending the email is attempted again, after a
+	// delivery that failed for the time being.
+	NextAttemptTime *metav1.Time `json:"nextAttemptTime,omitempty"`
+
+	// SentTime is when the SMTP server accepted the email.
+	SentTime *metav1.Time `json:"sentTime,omitempty"`
 }
 
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Subject",type=string,JSONPath=`.spec.subject`
+//+kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+//+kubebuilder:printcolumn:name="Sent",type=date,JSONPath=`.status.sentTime`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 
-// Email is the Schema for the emails API
+// Email is the Schema for the emails API. An Email is sent once, when it is
+// created; changing it afterwards does not send it again.
 type Email struct {
 	metav1.TypeMeta   `json:",inline"`
 	metav1.ObjectMeta `json:"metadata,omitempty"`
@@ -0,0 +1,76 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// TemplateParameter is a variable an EmailTemplate uses.
+type TemplateParameter struct {
+	// Name is how the templates refer to the variable: {{ .name }}.
+	Name string `json:"name"`
+
+	Description string `json:"description,omitempty"`
+
+	// Default is the value of the variable when an Email does not set it.
+	// Emails have to set the parameters without one.
+	Default *string `json:"default,omitempty"`
+}
+
+// EmailTemplateSpec defines the desired state of EmailTemplate
+type EmailTemplateSpec struct {
+	// Subject is a Go text/template for the subject of the email.
+	Subject string `json:"subject,omitempty"`
+
+	// Text is a Go text/template for the plain-text body of the email.
+	Text string `json:"text,omitempty"`
+
+	// HTML is a Go html/template for the HTML body of the email. Variables
+	// are escaped as the context they are used in needs.
+	HTML string `json:"html,omitempty"`
+
+	// Parameters are the variables the templates can use. Emails cannot set
+	// others.
+	Parameters []TemplateParameter `json:"parameters,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:printcolumn:name="Subject",type=string,JSONPath=`.spec.subject`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// EmailTemplate is the Schema for the emailtemplates API. It is a layout the
+// Emails of its namespace can be rendered from.
+type EmailTemplate struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec EmailTemplateSpec `json:"spec,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// EmailTemplateList contains a list of EmailTemplate
+type EmailTemplateList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []EmailTemplate `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&EmailTemplate{}, &EmailTemplateList{})
+}
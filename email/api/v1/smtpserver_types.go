@@ -0,0 +1,114 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// TLSMode is how the connection to an SMTP server is secured.
+//+kubebuilder:validation:Enum=None;STARTTLS;TLS
+type TLSMode string
+
+const (
+	// TLSNone sends everything in the clear.
+	TLSNone = TLSMode("None")
+	// TLSStartTLS connects in the clear, and upgrades the connection with the
+	// STARTTLS command before anything else. Servers without it are not used.
+	TLSStartTLS = TLSMode("STARTTLS")
+	// TLSImplicit speaks TLS from the start, as on port 465.
+	TLSImplicit = TLSMode("TLS")
+)
+
+// SMTPAuthMethod is the SASL mechanism used to log in to an SMTP server.
+//+kubebuilder:validation:Enum=PLAIN;LOGIN;CRAM-MD5
+type SMTPAuthMethod string
+
+const (
+	SMTPAuthPlain   = SMTPAuthMethod("PLAIN")
+	SMTPAuthLogin   = SMTPAuthMethod("LOGIN")
+	SMTPAuthCRAMMD5 = SMTPAuthMethod("CRAM-MD5")
+)
+
+// SMTPAuth is how to log in to an SMTP server.
+type SMTPAuth struct {
+	//+kubebuilder:default=PLAIN
+	Method SMTPAuthMethod `json:"method,omitempty"`
+
+	// SecretRef names the Secret, in the namespace of the SMTPServer, with the
+	// username and password keys to log in with. PLAIN and LOGIN credentials
+	// are only sent over TLS, or to the local host.
+	SecretRef corev1.LocalObjectReference `json:"secretRef"`
+}
+
+// SMTPServerSpec defines the desired state of SMTPServer
+type SMTPServerSpec struct {
+	Host string `json:"host"`
+
+	// Port defaults to 465 for implicit TLS, and to 587 otherwise.
+	//+kubebuilder:validation:Minimum=1
+	//+kubebuilder:validation:Maximum=65535
+	Port int32 `json:"port,omitempty"`
+
+	// TLS is None, STARTTLS or TLS. Defaults to STARTTLS.
+	//+kubebuilder:default=STARTTLS
+	TLS TLSMode `json:"tls,omitempty"`
+
+	// InsecureSkipVerify accepts any certificate from the server. It is only
+	// meant for testing.
+	InsecureSkipVerify bool `json:"insecureSkipVerify,omitempty"`
+
+	// Auth is how to log in. Emails are sent without logging in when it is not set.
+	Auth *SMTPAuth `json:"auth,omitempty"`
+
+	// ConnectTimeout bounds connecting to the server, TLS handshake included.
+	// Defaults to 10s.
+	ConnectTimeout *metav1.Duration `json:"connectTimeout,omitempty"`
+
+	// Timeout bounds the whole conversation with the server once connected.
+	// Defaults to 1m.
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:printcolumn:name="Host",type=string,JSONPath=`.spec.host`
+//+kubebuilder:printcolumn:name="Port",type=integer,JSONPath=`.spec.port`
+//+kubebuilder:printcolumn:name="TLS",type=string,JSONPath=`.spec.tls`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// SMTPServer is the Schema for the smtpservers API. It is an SMTP server the
+// Emails of its namespace can be sent through.
+type SMTPServer struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec SMTPServerSpec `json:"spec,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// SMTPServerList contains a list of SMTPServer
+type SMTPServerList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []SMTPServer `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&SMTPServer{}, &SMTPServerList{})
+}
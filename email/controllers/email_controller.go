@@ -18,45 +18,257 @@ package controllers
 
 import (
 	"context"
+	"errors"
+	"time"
 
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	nullemailv1 "github.com/null-channel/stupid-kube-operators/email/api/v1"
 )
 
+// errInterrupted is why an Email whose delivery was cut short failed.
+var errInterrupted = errors.New("delivery was interrupted, the email may or may not have been sent")
+
+const (
+	defaultMaxAttempts   = 5
+	defaultRetryDelay    = 30 * time.Second
+	defaultMaxRetryDelay = time.Hour
+)
+
 // EmailReconciler reconciles a Email object
 type EmailReconciler struct {
 	client.Client
 	Scheme *runtime.Scheme
+
+	// RetryDelay is how long to wait before sending an Email again after its
+	// first attempt failed for the time being. It doubles with every attempt,
+	// up to MaxRetryDelay. Zero means the defaults.
+	RetryDelay    time.Duration
+	MaxRetryDelay time.Duration
 }
 
 //+kubebuilder:rbac:groups=nullemail.thenullchannel.dev,resources=emails,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=nullemail.thenullchannel.dev,resources=emails/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=nullemail.thenullchannel.dev,resources=emails/finalizers,verbs=update
+//+kubebuilder:rbac:groups=nullemail.thenullchannel.dev,resources=smtpservers,verbs=get;list;watch
+//+kubebuilder:rbac:groups=nullemail.thenullchannel.dev,resources=emailtemplates,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;list;watch
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
-// TODO(user): Modify the Reconcile function to compare the state specified by
-// the Email object against the actual cluster state, and then
-// perform operations to make the cluster state reflect the state specified by
-// the user.
+//
+// A new Email is sent through its SMTPServer, once.
+// The attempt is recorded in the status before it is made, with an update
+// that fails if the Email changed since it was read, so reconciles working
+// from a stale copy never send it a second time. An attempt found in the
+// status without an outcome was cut short: the Email fails, as sending it
+// again might deliver it twice. So does an Email the SMTP server may have
+// taken without saying so. Emails the server turned down for the time being
+// (4xx), or that could not reach it, stay Pending and are sent again, waiting
+// exponentially longer between attempts, up to the MaxAttempts of the Email;
+// those it refused outright (5xx) fail. Emails that cannot be composed fail
+// too; those whose template does not render, or whose SMTPServer or
+// credentials cannot be read, stay Pending, and are retried. How rendering
+// went is in the Rendered condition.
 //
 // For more details, check Reconcile and its Result here:
 // - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.8.3/pkg/reconcile
 func (r *EmailReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
-	_ = log.FromContext(ctx)
+	logger := log.FromContext(ctx)
+
+	email := &nullemailv1.Email{}
+	if err := r.Get(ctx, req.NamespacedName, email); err != nil {
+		// Gone already, or an error reading the object - requeue the request.
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	switch email.Status.Phase {
+	case nullemailv1.EmailSent, nullemailv1.EmailFailed:
+		return ctrl.Result{}, nil
+	case nullemailv1.EmailSending:
+		logger.Info("email delivery was interrupted")
+		return r.finish(ctx, email, errInterrupted, false)
+	}
+	if next := email.Status.NextAttemptTime; next != nil {
+		if wait := time.Until(next.Time); wait > 0 {
+			return ctrl.Result{RequeueAfter: wait}, nil
+		}
+	}
+
+	c, err := r.content(ctx, email)
+	if email.Spec.Template != nil {
+		setRendered(email, err)
+	}
+	if err != nil {
+		// The template, or what its variables are read from, may show up, or
+		// be fixed, later.
+		return ctrl.Result{}, r.pending(ctx, email, err)
+	}
+
+	msg, err := newMessage(email, c, metav1.Now().Time)
+	if err != nil {
+		// Retrying will not fix the spec.
+		return r.finish(ctx, email, err, false)
+	}
+
+	config, err := r.smtpConfig(ctx, email)
+	if err != nil {
+		// The SMTPServer or its Secret may show up, or be fixed, later.
+		return ctrl.Result{}, r.pending(ctx, email, err)
+	}
+
+	email.Status.Phase = nullemailv1.EmailSending
+	email.Status.Attempts++
+	email.Status.MessageID = msg.id
+	email.Status.LastError = ""
+	email.Status.NextAttemptTime = nil
+	if err := r.Status().Update(ctx, email); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	logger.Info("sending email", "messageID", msg.id, "attempt", email.Status.Attempts)
+	return r.finish(ctx, email, config.send(msg), true)
+}
 
-	// your logic here
+// pending records that email cannot be sent yet because of err, and returns
+// err for the request to be retried.
+func (r *EmailReconciler) pending(ctx context.Context, email *nullemailv1.Email, err error) error {
+	email.Status.Phase = nullemailv1.EmailPending
+	email.Status.LastError = err.Error()
+	if updateErr := r.Status().Update(ctx, email); updateErr != nil {
+		return updateErr
+	}
+	return err
+}
 
-	return ctrl.Result{}, nil
+// setRendered sets the Rendered condition of email, given what rendering its
+// template returned.
+func setRendered(email *nullemailv1.Email, err error) {
+	condition := metav1.Condition{
+		Type:               nullemailv1.ConditionRendered,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: email.Generation,
+		Reason:             reasonRendered,
+		Message:            "The template rendered",
+	}
+	if err != nil {
+		condition.Status, condition.Reason, condition.Message = metav1.ConditionFalse, reasonRenderFailed, err.Error()
+		var renderErr *renderError
+		if errors.As(err, &renderErr) {
+			condition.Reason = renderErr.reason
+		}
+	}
+	meta.SetStatusCondition(&email.Status.Conditions, condition)
+}
+
+// finish records the outcome of the delivery of email: Sent when sendErr is
+// nil, Pending until the next attempt when sendErr is temporary and attempts
+// are left, Failed otherwise.
+//
+// The outcome of an attempt that was made, as attempted says, has to land,
+// since losing it would fail an Email that was sent. It is written with a
+// merge patch that does not mind the Email changing since it was read: the
+// attempt in its status is ours, so only its metadata and spec can have.
+// Other outcomes are decided from email as it was read, which may be stale,
+// and are only written if it did not change since.
+func (r *EmailReconciler) finish(ctx context.Context, email *nullemailv1.Email, sendErr error, attempted bool) (ctrl.Result, error) {
+	original := email.DeepCopy()
+
+	result := ctrl.Result{}
+	email.Status.NextAttemptTime = nil
+	switch {
+	case sendErr == nil:
+		now := metav1.Now()
+		email.Status.Phase = nullemailv1.EmailSent
+		email.Status.SentTime = &now
+		email.Status.LastError = ""
+	case temporary(sendErr) && email.Status.Attempts < maxAttempts(email):
+		result.RequeueAfter = r.retryDelay(email.Status.Attempts)
+		next := metav1.NewTime(time.Now().Add(result.RequeueAfter))
+		email.Status.Phase = nullemailv1.EmailPending
+		email.Status.LastError = sendErr.Error()
+		email.Status.NextAttemptTime = &next
+	default:
+		email.Status.Phase = nullemailv1.EmailFailed
+		email.Status.LastError = sendErr.Error()
+	}
+
+	patch := client.MergeFromWithOptions(original, client.MergeFromWithOptimisticLock{})
+	if attempted {
+		patch = client.MergeFrom(original)
+	}
+	if err := r.Status().Patch(ctx, email, patch); err != nil {
+		return ctrl.Result{}, err
+	}
+	return result, nil
+}
+
+// retryDelay returns how long to wait before sending an Email again after its
+// attempts so far failed.
+func (r *EmailReconciler) retryDelay(attempts int32) time.Duration {
+	delay, maxDelay := r.RetryDelay, r.MaxRetryDelay
+	if delay <= 0 {
+		delay = defaultRetryDelay
+	}
+	if maxDelay <= 0 {
+		maxDelay = defaultMaxRetryDelay
+	}
+	for i := int32(1); i < attempts && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxDelay {
+		delay = maxDelay
+	}
+	return delay
+}
+
+func maxAttempts(email *nullemailv1.Email) int32 {
+	if email.Spec.MaxAttempts <= 0 {
+		return defaultMaxAttempts
+	}
+	return email.Spec.MaxAttempts
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *EmailReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
-		For(&nullemailv1.Email{}).
+		// Status updates are the reconciler's own doing.
+		For(&nullemailv1.Email{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Watches(
+			&source.Kind{Type: &nullemailv1.EmailTemplate{}},
+			handler.EnqueueRequestsFromMapFunc(r.emailsForTemplate),
+		).
 		Complete(r)
 }
+
+// emailsForTemplate maps an EmailTemplate to the Emails waiting to be rendered
+// from it.
+func (r *EmailReconciler) emailsForTemplate(obj client.Object) []ctrl.Request {
+	emails := &nullemailv1.EmailList{}
+	if err := r.List(context.Background(), emails, client.InNamespace(obj.GetNamespace())); err != nil {
+		log.Log.WithName("email").Error(err, "listing emails", "namespace", obj.GetNamespace())
+		return nil
+	}
+
+	requests := []ctrl.Request{}
+	for _, email := range emails.Items {
+		if email.Spec.Template == nil || email.Spec.Template.Name != obj.GetName() {
+			continue
+		}
+		if phase := email.Status.Phase; phase != "" && phase != nullemailv1.EmailPending {
+			continue
+		}
+		requests = append(requests, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(&email)})
+	}
+	return requests
+}
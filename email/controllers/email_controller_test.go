@@ -0,0 +1,391 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	nullemailv1 "github.com/null-channel/stupid-kube-operators/email/api/v1"
+)
+
+var _ = Describe("EmailReconciler", func() {
+	ctx := context.Background()
+	const namespace = "default"
+
+	var server *fakeSMTPServer
+	var created []client.Object
+	var retryDelay time.Duration
+
+	BeforeEach(func() {
+		retryDelay = 50 * time.Millisecond
+	})
+
+	create := func(obj client.Object) {
+		Expect(k8sClient.Create(ctx, obj)).To(Succeed())
+		created = append(created, obj)
+	}
+
+	AfterEach(func() {
+		if server != nil {
+			server.Close()
+			server = nil
+		}
+		for _, obj := range created {
+			Expect(client.IgnoreNotFound(k8sClient.Delete(ctx, obj))).To(Succeed())
+		}
+		created = nil
+	})
+
+	// smtpServer starts a fake SMTP server taking the credentials user and
+	// secret, and returns an SMTPServer for it logging in with password.
+	smtpServer := func(mode nullemailv1.TLSMode, method nullemailv1.SMTPAuthMethod, password string) *nullemailv1.SMTPServer {
+		var err error
+		server, err = newFakeSMTPServer(mode, "user", "secret")
+		Expect(err).NotTo(HaveOccurred())
+
+		credentials := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{GenerateName: "smtp-", Namespace: namespace},
+			StringData: map[string]string{smtpUsernameKey: "user", smtpPasswordKey: password},
+		}
+		create(credentials)
+
+		smtp := &nullemailv1.SMTPServer{
+			ObjectMeta: metav1.ObjectMeta{GenerateName: "smtp-", Namespace: namespace},
+			Spec: nullemailv1.SMTPServerSpec{
+				Host:               "127.0.0.1",
+				Port:               server.port(),
+				TLS:                mode,
+				InsecureSkipVerify: true,
+				Auth: &nullemailv1.SMTPAuth{
+					Method:    method,
+					SecretRef: corev1.LocalObjectReference{Name: credentials.Name},
+				},
+			},
+		}
+		create(smtp)
+		return smtp
+	}
+
+	newEmail := func(smtpServerName string) *nullemailv1.Email {
+		email := &nullemailv1.Email{
+			ObjectMeta: metav1.ObjectMeta{GenerateName: "email-", Namespace: namespace},
+			Spec: nullemailv1.EmailSpec{
+				To:      []string{"Jane Doe <jane@example.com>"},
+				Bcc:     []string{"audit@example.com"},
+				From:    "operator@example.com",
+				Subject: "Hello",
+				Text:    "Hi Jane",
+				HTML:    "<p>Hi Jane</p>",
+				SMTPRef: corev1.LocalObjectReference{Name: smtpServerName},
+			},
+		}
+		create(email)
+		return email
+	}
+
+	// reconcile runs the reconciler on email, and returns email as it is then.
+	reconcile := func(email *nullemailv1.Email) (*nullemailv1.Email, error) {
+		r := &EmailReconciler{Client: k8sClient, Scheme: scheme.Scheme, RetryDelay: retryDelay}
+		_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(email)})
+
+		got := &nullemailv1.Email{}
+		Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(email), got)).To(Succeed())
+		return got, err
+	}
+
+	table.DescribeTable("sends Emails through their SMTPServer",
+		func(mode nullemailv1.TLSMode, method nullemailv1.SMTPAuthMethod) {
+			email, err := reconcile(newEmail(smtpServer(mode, method, "secret").Name))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(email.Status.Phase).To(Equal(nullemailv1.EmailSent))
+			Expect(email.Status.Attempts).To(BeEquivalentTo(1))
+			Expect(email.Status.SentTime).NotTo(BeNil())
+
+			messages := server.received()
+			Expect(messages).To(HaveLen(1))
+			Expect(messages[0].from).To(Equal("operator@example.com"))
+			Expect(messages[0].to).To(ConsistOf("jane@example.com", "audit@example.com"))
+			Expect(messages[0].data).To(ContainSubstring("Message-ID: " + email.Status.MessageID))
+			Expect(messages[0].data).NotTo(ContainSubstring("audit@example.com"))
+		},
+		table.Entry("in the clear with PLAIN", nullemailv1.TLSNone, nullemailv1.SMTPAuthPlain),
+		table.Entry("in the clear with LOGIN", nullemailv1.TLSNone, nullemailv1.SMTPAuthLogin),
+		table.Entry("in the clear with CRAM-MD5", nullemailv1.TLSNone, nullemailv1.SMTPAuthCRAMMD5),
+		table.Entry("over STARTTLS with PLAIN", nullemailv1.TLSStartTLS, nullemailv1.SMTPAuthPlain),
+		table.Entry("over STARTTLS with LOGIN", nullemailv1.TLSStartTLS, nullemailv1.SMTPAuthLogin),
+		table.Entry("over STARTTLS with CRAM-MD5", nullemailv1.TLSStartTLS, nullemailv1.SMTPAuthCRAMMD5),
+		table.Entry("over implicit TLS with PLAIN", nullemailv1.TLSImplicit, nullemailv1.SMTPAuthPlain),
+		table.Entry("over implicit TLS with LOGIN", nullemailv1.TLSImplicit, nullemailv1.SMTPAuthLogin),
+		table.Entry("over implicit TLS with CRAM-MD5", nullemailv1.TLSImplicit, nullemailv1.SMTPAuthCRAMMD5),
+	)
+
+	It("sends an Email once however often it is reconciled", func() {
+		email := newEmail(smtpServer(nullemailv1.TLSStartTLS, nullemailv1.SMTPAuthPlain, "secret").Name)
+		for i := 0; i < 3; i++ {
+			_, err := reconcile(email)
+			Expect(err).NotTo(HaveOccurred())
+		}
+		Expect(server.received()).To(HaveLen(1))
+	})
+
+	It("fails Emails the SMTP server refuses the credentials for", func() {
+		email, err := reconcile(newEmail(smtpServer(nullemailv1.TLSStartTLS, nullemailv1.SMTPAuthPlain, "wrong").Name))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(email.Status.Phase).To(Equal(nullemailv1.EmailFailed))
+		Expect(email.Status.LastError).To(ContainSubstring("535"))
+		Expect(server.received()).To(BeEmpty())
+	})
+
+	It("refuses to go on in the clear when STARTTLS is not offered", func() {
+		smtp := smtpServer(nullemailv1.TLSNone, nullemailv1.SMTPAuthPlain, "secret")
+		smtp.Spec.TLS = nullemailv1.TLSStartTLS
+		Expect(k8sClient.Update(ctx, smtp)).To(Succeed())
+
+		email, err := reconcile(newEmail(smtp.Name))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(email.Status.Phase).To(Equal(nullemailv1.EmailFailed))
+		Expect(email.Status.LastError).To(ContainSubstring("STARTTLS"))
+		Expect(server.received()).To(BeEmpty())
+	})
+
+	Context("with a template", func() {
+		var smtp *nullemailv1.SMTPServer
+		var template *nullemailv1.EmailTemplate
+
+		BeforeEach(func() {
+			smtp = smtpServer(nullemailv1.TLSStartTLS, nullemailv1.SMTPAuthPlain, "secret")
+
+			greeting := "Hi"
+			template = &nullemailv1.EmailTemplate{
+				ObjectMeta: metav1.ObjectMeta{GenerateName: "welcome-", Namespace: namespace},
+				Spec: nullemailv1.EmailTemplateSpec{
+					Subject: "Welcome to {{ .product }}",
+					Text:    "{{ .greeting }} {{ .name }}, your code is {{ .code }}.",
+					HTML:    "<p>{{ .greeting }} {{ .name }}</p>",
+					Parameters: []nullemailv1.TemplateParameter{
+						{Name: "greeting", Default: &greeting},
+						{Name: "name"},
+						{Name: "product"},
+						{Name: "code"},
+					},
+				},
+			}
+			create(template)
+		})
+
+		templated := func(variables ...nullemailv1.EmailVariable) *nullemailv1.Email {
+			email := newEmail(smtp.Name)
+			email.Spec.Subject, email.Spec.Text, email.Spec.HTML = "", "", ""
+			email.Spec.Template = &nullemailv1.EmailTemplateReference{Name: template.Name, Variables: variables}
+			Expect(k8sClient.Update(ctx, email)).To(Succeed())
+			return email
+		}
+
+		It("renders the Email with variables from values, ConfigMaps and Secrets", func() {
+			product := &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{GenerateName: "product-", Namespace: namespace},
+				Data:       map[string]string{"name": "Null Mail"},
+			}
+			create(product)
+			code := &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{GenerateName: "code-", Namespace: namespace},
+				StringData: map[string]string{"code": "1234"},
+			}
+			create(code)
+
+			email, err := reconcile(templated(
+				nullemailv1.EmailVariable{Name: "name", Value: "<Jane>"},
+				nullemailv1.EmailVariable{Name: "product", ValueFrom: &nullemailv1.EmailVariableSource{
+					ConfigMapKeyRef: &corev1.ConfigMapKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: product.Name}, Key: "name"},
+				}},
+				nullemailv1.EmailVariable{Name: "code", ValueFrom: &nullemailv1.EmailVariableSource{
+					SecretKeyRef: &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: code.Name}, Key: "code"},
+				}},
+			))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(email.Status.Phase).To(Equal(nullemailv1.EmailSent))
+			Expect(meta.IsStatusConditionTrue(email.Status.Conditions, nullemailv1.ConditionRendered)).To(BeTrue())
+
+			messages := server.received()
+			Expect(messages).To(HaveLen(1))
+			Expect(messages[0].data).To(ContainSubstring("Subject: Welcome to Null Mail"))
+			Expect(messages[0].data).To(ContainSubstring("Hi <Jane>, your code is 1234."))
+			Expect(messages[0].data).To(ContainSubstring("<p>Hi &lt;Jane&gt;</p>"))
+		})
+
+		It("keeps Emails missing variables Pending, with the reason in the Rendered condition", func() {
+			email, err := reconcile(templated(nullemailv1.EmailVariable{Name: "name", Value: "Jane"}))
+			Expect(err).To(HaveOccurred())
+			Expect(email.Status.Phase).To(Equal(nullemailv1.EmailPending))
+
+			rendered := meta.FindStatusCondition(email.Status.Conditions, nullemailv1.ConditionRendered)
+			Expect(rendered).NotTo(BeNil())
+			Expect(rendered.Status).To(Equal(metav1.ConditionFalse))
+			Expect(rendered.Reason).To(Equal(reasonInvalidVariables))
+			Expect(rendered.Message).To(ContainSubstring("code, product"))
+			Expect(server.received()).To(BeEmpty())
+		})
+
+		It("keeps Emails Pending until the ConfigMaps their variables come from exist", func() {
+			email, err := reconcile(templated(
+				nullemailv1.EmailVariable{Name: "name", Value: "Jane"},
+				nullemailv1.EmailVariable{Name: "code", Value: "1234"},
+				nullemailv1.EmailVariable{Name: "product", ValueFrom: &nullemailv1.EmailVariableSource{
+					ConfigMapKeyRef: &corev1.ConfigMapKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "missing"}, Key: "name"},
+				}},
+			))
+			Expect(err).To(HaveOccurred())
+			Expect(email.Status.Phase).To(Equal(nullemailv1.EmailPending))
+			Expect(meta.FindStatusCondition(email.Status.Conditions, nullemailv1.ConditionRendered).Reason).To(Equal(reasonVariableNotFound))
+		})
+	})
+
+	Context("when delivery fails", func() {
+		var email *nullemailv1.Email
+
+		BeforeEach(func() {
+			email = newEmail(smtpServer(nullemailv1.TLSStartTLS, nullemailv1.SMTPAuthPlain, "secret").Name)
+		})
+
+		// retry waits out the backoff of email, and reconciles it again.
+		retry := func(email *nullemailv1.Email) (*nullemailv1.Email, error) {
+			Expect(email.Status.NextAttemptTime).NotTo(BeNil())
+			time.Sleep(time.Until(email.Status.NextAttemptTime.Time))
+			return reconcile(email)
+		}
+
+		It("sends Emails the SMTP server turned down for the time being again, once", func() {
+			server.reject("DATA", "451 4.3.0 try again later")
+
+			got, err := reconcile(email)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(got.Status.Phase).To(Equal(nullemailv1.EmailPending))
+			Expect(got.Status.Attempts).To(BeEquivalentTo(1))
+			Expect(got.Status.LastError).To(ContainSubstring("451"))
+			Expect(server.received()).To(BeEmpty())
+
+			got, err = retry(got)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(got.Status.Phase).To(Equal(nullemailv1.EmailSent))
+			Expect(got.Status.Attempts).To(BeEquivalentTo(2))
+			Expect(got.Status.NextAttemptTime).To(BeNil())
+
+			messages := server.received()
+			Expect(messages).To(HaveLen(1))
+			Expect(messages[0].data).To(ContainSubstring("Message-ID: " + got.Status.MessageID))
+		})
+
+		It("waits longer after every attempt before sending again", func() {
+			retryDelay = time.Hour
+			server.reject("MAIL", "421 4.3.2 service not available")
+
+			got, err := reconcile(email)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(got.Status.NextAttemptTime).NotTo(BeNil())
+			Expect(time.Until(got.Status.NextAttemptTime.Time)).To(BeNumerically("~", time.Hour, time.Minute))
+
+			got, err = reconcile(email)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(got.Status.Attempts).To(BeEquivalentTo(1))
+			Expect(server.received()).To(BeEmpty())
+
+			r := &EmailReconciler{RetryDelay: time.Minute, MaxRetryDelay: 10 * time.Minute}
+			Expect(r.retryDelay(1)).To(Equal(time.Minute))
+			Expect(r.retryDelay(2)).To(Equal(2 * time.Minute))
+			Expect(r.retryDelay(3)).To(Equal(4 * time.Minute))
+			Expect(r.retryDelay(10)).To(Equal(10 * time.Minute))
+		})
+
+		It("gives up on Emails after their MaxAttempts", func() {
+			email.Spec.MaxAttempts = 2
+			Expect(k8sClient.Update(ctx, email)).To(Succeed())
+			for i := 0; i < 3; i++ {
+				server.reject("RCPT", "450 4.2.1 mailbox busy")
+			}
+
+			got, err := reconcile(email)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(got.Status.Phase).To(Equal(nullemailv1.EmailPending))
+
+			got, err = retry(got)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(got.Status.Phase).To(Equal(nullemailv1.EmailFailed))
+			Expect(got.Status.Attempts).To(BeEquivalentTo(2))
+			Expect(got.Status.LastError).To(ContainSubstring("450"))
+			Expect(got.Status.NextAttemptTime).To(BeNil())
+		})
+
+		It("does not send Emails the SMTP server refused again", func() {
+			server.reject("RCPT", "550 5.1.1 no such user")
+
+			got, err := reconcile(email)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(got.Status.Phase).To(Equal(nullemailv1.EmailFailed))
+			Expect(got.Status.Attempts).To(BeEquivalentTo(1))
+			Expect(got.Status.LastError).To(ContainSubstring("550"))
+			Expect(got.Status.NextAttemptTime).To(BeNil())
+
+			got, err = reconcile(email)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(got.Status.Attempts).To(BeEquivalentTo(1))
+			Expect(server.received()).To(BeEmpty())
+		})
+
+		It("does not send Emails the SMTP server may have taken again", func() {
+			server.reject("DATA", "")
+
+			got, err := reconcile(email)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(got.Status.Phase).To(Equal(nullemailv1.EmailFailed))
+			Expect(got.Status.LastError).To(ContainSubstring("may or may not have been sent"))
+
+			got, err = reconcile(email)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(got.Status.Attempts).To(BeEquivalentTo(1))
+		})
+
+		It("does not send Emails whose attempt was interrupted again", func() {
+			email.Status.Phase = nullemailv1.EmailSending
+			email.Status.Attempts = 1
+			Expect(k8sClient.Status().Update(ctx, email)).To(Succeed())
+
+			got, err := reconcile(email)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(got.Status.Phase).To(Equal(nullemailv1.EmailFailed))
+			Expect(got.Status.Attempts).To(BeEquivalentTo(1))
+			Expect(server.received()).To(BeEmpty())
+		})
+	})
+
+	It("keeps Emails Pending until their SMTPServer exists", func() {
+		email, err := reconcile(newEmail("missing"))
+		Expect(err).To(HaveOccurred())
+		Expect(email.Status.Phase).To(Equal(nullemailv1.EmailPending))
+		Expect(email.Status.Attempts).To(BeZero())
+	})
+})
@@ -0,0 +1,341 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+	"time"
+
+	nullemailv1 "github.com/null-channel/stupid-kube-operators/email/api/v1"
+)
+
+// receivedMessage is an email the fake SMTP server accepted.
+type receivedMessage struct {
+	from string
+	to   []string
+	data string
+}
+
+// fakeSMTPServer is an SMTP server good enough for net/smtp, listening on the
+// loopback interface. It speaks TLS as told, requires logging in when it has
+// credentials, and keeps the emails it is given.
+type fakeSMTPServer struct {
+	listener  net.Listener
+	tlsConfig *tls.Config
+	tls       nullemailv1.TLSMode
+	username  string
+	password  string
+
+	mu         sync.Mutex
+	messages   []receivedMessage
+	rejections map[string][]string
+}
+
+// newFakeSMTPServer starts a fake SMTP server securing connections as mode
+// says, and accepting the given credentials, if any.
+func newFakeSMTPServer(mode nullemailv1.TLSMode, username, password string) (*fakeSMTPServer, error) {
+	cert, err := selfSignedCertificate()
+	if err != nil {
+		return nil, err
+	}
+
+	s := &fakeSMTPServer{
+		tlsConfig: &tls.Config{Certificates: []tls.Certificate{cert}},
+		tls:       mode,
+		username:  username,
+		password:  password,
+	}
+	if mode == nullemailv1.TLSImplicit {
+		s.listener, err = tls.Listen("tcp", "127.0.0.1:0", s.tlsConfig)
+	} else {
+		s.listener, err = net.Listen("tcp", "127.0.0.1:0")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	go s.serve()
+	return s, nil
+}
+
+func (s *fakeSMTPServer) port() int32 {
+	return int32(s.listener.Addr().(*net.TCPAddr).Port)
+}
+
+// received returns the emails the server accepted so far.
+func (s *fakeSMTPServer) received() []receivedMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]receivedMessage(nil), s.messages...)
+}
+
+// reject has the server answer the next command verb with reply, instead of
+// carrying it out. A rejected DATA is answered once the whole email was
+// received, and the email dropped. An empty reply hangs up without answering.
+func (s *fakeSMTPServer) reject(verb, reply string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.rejections == nil {
+		s.rejections = map[string][]string{}
+	}
+	s.rejections[verb] = append(s.rejections[verb], reply)
+}
+
+// rejection returns the reply the server was told to answer verb with next.
+func (s *fakeSMTPServer) rejection(verb string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	replies := s.rejections[verb]
+	if len(replies) == 0 {
+		return "", false
+	}
+	s.rejections[verb] = replies[1:]
+	return replies[0], true
+}
+
+func (s *fakeSMTPServer) Close() {
+	s.listener.Close()
+}
+
+func (s *fakeSMTPServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+// handle talks SMTP over conn until the client quits.
+func (s *fakeSMTPServer) handle(conn net.Conn) {
+	defer conn.Close()
+	_, secure := conn.(*tls.Conn)
+	tp := textproto.NewConn(conn)
+	authenticated := s.username == ""
+	var msg *receivedMessage
+
+	tp.PrintfLine("220 localhost fake ESMTP")
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg := line, ""
+		if space := strings.IndexByte(line, ' '); space >= 0 {
+			verb, arg = line[:space], line[space+1:]
+		}
+
+		verb = strings.ToUpper(verb)
+		if verb != "DATA" {
+			if reply, ok := s.rejection(verb); ok {
+				if reply == "" {
+					return
+				}
+				tp.PrintfLine("%s", reply)
+				continue
+			}
+		}
+
+		switch verb {
+		case "EHLO", "HELO":
+			extensions := []string{"localhost", "8BITMIME"}
+			if s.tls == nullemailv1.TLSStartTLS && !secure {
+				extensions = append(extensions, "STARTTLS")
+			}
+			if s.username != "" {
+				extensions = append(extensions, "AUTH PLAIN LOGIN CRAM-MD5")
+			}
+			for i, extension := range extensions {
+				separator := "-"
+				if i == len(extensions)-1 {
+					separator = " "
+				}
+				tp.PrintfLine("250%s%s", separator, extension)
+			}
+		case "STARTTLS":
+			tp.PrintfLine("220 2.0.0 ready to start TLS")
+			tlsConn := tls.Server(conn, s.tlsConfig)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn, secure = tlsConn, true
+			tp = textproto.NewConn(conn)
+		case "AUTH":
+			if s.authenticate(tp, arg) {
+				authenticated = true
+				tp.PrintfLine("235 2.7.0 authentication successful")
+			} else {
+				tp.PrintfLine("535 5.7.8 authentication credentials invalid")
+			}
+		case "MAIL":
+			if !authenticated {
+				tp.PrintfLine("530 5.7.0 authentication required")
+				continue
+			}
+			msg = &receivedMessage{from: angleAddress(arg)}
+			tp.PrintfLine("250 2.1.0 ok")
+		case "RCPT":
+			if msg == nil {
+				tp.PrintfLine("503 5.5.1 MAIL first")
+				continue
+			}
+			msg.to = append(msg.to, angleAddress(arg))
+			tp.PrintfLine("250 2.1.5 ok")
+		case "DATA":
+			if msg == nil || len(msg.to) == 0 {
+				tp.PrintfLine("503 5.5.1 RCPT first")
+				continue
+			}
+			tp.PrintfLine("354 go ahead")
+			data, err := tp.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			if reply, ok := s.rejection(verb); ok {
+				if reply == "" {
+					return
+				}
+				msg = nil
+				tp.PrintfLine("%s", reply)
+				continue
+			}
+			msg.data = string(data)
+			s.mu.Lock()
+			s.messages = append(s.messages, *msg)
+			s.mu.Unlock()
+			msg = nil
+			tp.PrintfLine("250 2.0.0 queued")
+		case "RSET":
+			msg = nil
+			tp.PrintfLine("250 2.0.0 ok")
+		case "NOOP":
+			tp.PrintfLine("250 2.0.0 ok")
+		case "QUIT":
+			tp.PrintfLine("221 2.0.0 bye")
+			return
+		default:
+			tp.PrintfLine("502 5.5.2 command not recognized")
+		}
+	}
+}
+
+// authenticate runs the AUTH exchange arg starts, and reports whether the
+// client logged in with the credentials of the server.
+func (s *fakeSMTPServer) authenticate(tp *textproto.Conn, arg string) bool {
+	fields := strings.Fields(arg)
+	if len(fields) == 0 {
+		return false
+	}
+
+	// challenge sends a challenge, and returns the decoded answer.
+	challenge := func(c string) (string, bool) {
+		tp.PrintfLine("334 %s", base64.StdEncoding.EncodeToString([]byte(c)))
+		line, err := tp.ReadLine()
+		if err != nil {
+			return "", false
+		}
+		answer, err := base64.StdEncoding.DecodeString(line)
+		return string(answer), err == nil
+	}
+
+	switch strings.ToUpper(fields[0]) {
+	case "PLAIN":
+		var response string
+		if len(fields) > 1 {
+			decoded, err := base64.StdEncoding.DecodeString(fields[1])
+			if err != nil {
+				return false
+			}
+			response = string(decoded)
+		} else {
+			var ok bool
+			if response, ok = challenge(""); !ok {
+				return false
+			}
+		}
+		parts := strings.Split(response, "\x00")
+		return len(parts) == 3 && parts[1] == s.username && parts[2] == s.password
+	case "LOGIN":
+		username, ok := challenge("Username:")
+		if !ok {
+			return false
+		}
+		password, ok := challenge("Password:")
+		return ok && username == s.username && password == s.password
+	case "CRAM-MD5":
+		nonce := fmt.Sprintf("<%d@localhost>", time.Now().UnixNano())
+		response, ok := challenge(nonce)
+		if !ok {
+			return false
+		}
+		mac := hmac.New(md5.New, []byte(s.password))
+		mac.Write([]byte(nonce))
+		return response == s.username+" "+hex.EncodeToString(mac.Sum(nil))
+	default:
+		return false
+	}
+}
+
+// angleAddress returns the address between the angle brackets of the
+// argument of MAIL or RCPT.
+func angleAddress(arg string) string {
+	start, end := strings.IndexByte(arg, '<'), strings.IndexByte(arg, '>')
+	if start < 0 || end < start {
+		return ""
+	}
+	return arg[start+1 : end]
+}
+
+// selfSignedCertificate returns a certificate for the loopback interface.
+func selfSignedCertificate() (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
@@ -0,0 +1,109 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	nullemailv1 "github.com/null-channel/stupid-kube-operators/email/api/v1"
+)
+
+func TestFinishAfterConcurrentChange(t *testing.T) {
+	tests := []struct {
+		name      string
+		stored    nullemailv1.EmailPhase
+		sendErr   error
+		attempted bool
+		wantErr   bool
+		wantPhase nullemailv1.EmailPhase
+	}{
+		{
+			name:      "sent while the Email was edited",
+			stored:    nullemailv1.EmailSending,
+			attempted: true,
+			wantPhase: nullemailv1.EmailSent,
+		},
+		{
+			name:      "refused while the Email was edited",
+			stored:    nullemailv1.EmailSending,
+			sendErr:   errors.New("550 no such user"),
+			attempted: true,
+			wantPhase: nullemailv1.EmailFailed,
+		},
+		{
+			name:      "interrupted, as a stale copy has it",
+			stored:    nullemailv1.EmailSent,
+			sendErr:   errInterrupted,
+			wantErr:   true,
+			wantPhase: nullemailv1.EmailSent,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			s := runtime.NewScheme()
+			if err := nullemailv1.AddToScheme(s); err != nil {
+				t.Fatal(err)
+			}
+			c := fake.NewClientBuilder().WithScheme(s).WithObjects(&nullemailv1.Email{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "welcome"},
+				Status:     nullemailv1.EmailStatus{Phase: nullemailv1.EmailSending, Attempts: 1},
+			}).Build()
+
+			email := &nullemailv1.Email{}
+			if err := c.Get(ctx, client.ObjectKey{Namespace: "default", Name: "welcome"}, email); err != nil {
+				t.Fatal(err)
+			}
+
+			// The Email changes after it was read, which makes email stale.
+			changed := email.DeepCopy()
+			changed.Labels = map[string]string{"campaign": "spring"}
+			changed.Status.Phase = tt.stored
+			if err := c.Update(ctx, changed); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err := (&EmailReconciler{Client: c, Scheme: s}).finish(ctx, email, tt.sendErr, tt.attempted)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("finish() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr && !apierrors.IsConflict(err) {
+				t.Errorf("finish() error = %v, want a conflict", err)
+			}
+
+			got := &nullemailv1.Email{}
+			if err := c.Get(ctx, client.ObjectKeyFromObject(email), got); err != nil {
+				t.Fatal(err)
+			}
+			if got.Status.Phase != tt.wantPhase {
+				t.Errorf("phase = %s, want %s", got.Status.Phase, tt.wantPhase)
+			}
+			if got.Labels["campaign"] != "spring" {
+				t.Errorf("labels = %v, want the concurrent change kept", got.Labels)
+			}
+		})
+	}
+}
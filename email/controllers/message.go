@@ -0,0 +1,171 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+
+	nullemailv1 "github.com/null-channel/stupid-kube-operators/email/api/v1"
+)
+
+// message is an email ready to be handed to an SMTP server.
+type message struct {
+	// id is the Message-ID of the email, angle brackets included.
+	id string
+	// from is the envelope sender.
+	from string
+	// recipients are the envelope recipients: To, Cc and Bcc.
+	recipients []string
+	// data is the email itself, headers and body.
+	data []byte
+}
+
+// newMessage composes email, with the subject and bodies of c, as it is sent
+// at date. Addresses that do not parse are an error.
+func newMessage(email *nullemailv1.Email, c *content, date time.Time) (*message, error) {
+	from, err := mail.ParseAddress(email.Spec.From)
+	if err != nil {
+		return nil, fmt.Errorf("from address %q: %w", email.Spec.From, err)
+	}
+
+	msg := &message{id: messageID(email, from), from: from.Address}
+
+	header := textproto.MIMEHeader{}
+	header.Set("From", from.String())
+	for _, field := range []struct {
+		name string
+		list []string
+	}{{"To", email.Spec.To}, {"Cc", email.Spec.Cc}, {"Bcc", email.Spec.Bcc}} {
+		addresses, err := parseAddresses(field.name, field.list)
+		if err != nil {
+			return nil, err
+		}
+		for _, address := range addresses {
+			msg.recipients = append(msg.recipients, address.Address)
+		}
+		// Bcc recipients are only in the envelope.
+		if field.name != "Bcc" && len(addresses) > 0 {
+			header.Set(field.name, joinAddresses(addresses))
+		}
+	}
+	if len(msg.recipients) == 0 {
+		return nil, fmt.Errorf("no recipients")
+	}
+
+	header.Set("Subject", mime.QEncoding.Encode("utf-8", c.subject))
+	header.Set("Date", date.Format(time.RFC1123Z))
+	header.Set("Message-ID", msg.id)
+	header.Set("MIME-Version", "1.0")
+
+	body := &bytes.Buffer{}
+	switch {
+	case c.text != "" && c.html != "":
+		w := multipart.NewWriter(body)
+		header.Set("Content-Type", "multipart/alternative; boundary="+w.Boundary())
+		// The last part is the one mail clients prefer.
+		for _, part := range []struct{ contentType, content string }{
+			{"text/plain; charset=utf-8", c.text},
+			{"text/html; charset=utf-8", c.html},
+		} {
+			pw, err := w.CreatePart(textproto.MIMEHeader{
+				"Content-Type":              {part.contentType},
+				"Content-Transfer-Encoding": {"quoted-printable"},
+			})
+			if err != nil {
+				return nil, err
+			}
+			if err := writeQuotedPrintable(pw, part.content); err != nil {
+				return nil, err
+			}
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+	case c.html != "":
+		header.Set("Content-Type", "text/html; charset=utf-8")
+		header.Set("Content-Transfer-Encoding", "quoted-printable")
+		if err := writeQuotedPrintable(body, c.html); err != nil {
+			return nil, err
+		}
+	default:
+		header.Set("Content-Type", "text/plain; charset=utf-8")
+		header.Set("Content-Transfer-Encoding", "quoted-printable")
+		if err := writeQuotedPrintable(body, c.text); err != nil {
+			return nil, err
+		}
+	}
+
+	data := &bytes.Buffer{}
+	for _, field := range []string{"From", "To", "Cc", "Subject", "Date", "Message-ID", "MIME-Version", "Content-Type", "Content-Transfer-Encoding"} {
+		if value := header.Get(field); value != "" {
+			fmt.Fprintf(data, "%s: %s\r\n", field, value)
+		}
+	}
+	data.WriteString("\r\n")
+	data.Write(body.Bytes())
+	msg.data = data.Bytes()
+	return msg, nil
+}
+
+// messageID returns the Message-ID of email. It only depends on the UID of the
+// Email, so every attempt at sending it carries the same one.
+func messageID(email *nullemailv1.Email, from *mail.Address) string {
+	domain := "localhost"
+	if at := strings.LastIndex(from.Address, "@"); at >= 0 {
+		domain = from.Address[at+1:]
+	}
+	return fmt.Sprintf("<%s@%s>", email.UID, domain)
+}
+
+// parseAddresses parses the addresses of the given header field.
+func parseAddresses(field string, list []string) ([]*mail.Address, error) {
+	addresses := make([]*mail.Address, 0, len(list))
+	for _, s := range list {
+		address, err := mail.ParseAddress(s)
+		if err != nil {
+			return nil, fmt.Errorf("%s address %q: %w", strings.ToLower(field), s, err)
+		}
+		addresses = append(addresses, address)
+	}
+	return addresses, nil
+}
+
+func joinAddresses(addresses []*mail.Address) string {
+	s := make([]string, 0, len(addresses))
+	for _, address := range addresses {
+		s = append(s, address.String())
+	}
+	return strings.Join(s, ", ")
+}
+
+// writeQuotedPrintable writes content to w, quoted-printable encoded.
+func writeQuotedPrintable(w io.Writer, content string) error {
+	qp := quotedprintable.NewWriter(w)
+	if _, err := qp.Write([]byte(content)); err != nil {
+		return err
+	}
+	return qp.Close()
+}
@@ -0,0 +1,222 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	htmltemplate "html/template"
+	"sort"
+	"strings"
+	texttemplate "text/template"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	nullemailv1 "github.com/null-channel/stupid-kube-operators/email/api/v1"
+)
+
+// Reasons of the Rendered condition of an Email.
+const (
+	reasonRendered         = "Rendered"
+	reasonTemplateNotFound = "TemplateNotFound"
+	reasonVariableNotFound = "VariableNotFound"
+	reasonInvalidVariables = "InvalidVariables"
+	reasonRenderFailed     = "RenderFailed"
+)
+
+// content is the subject and bodies of an email.
+type content struct {
+	subject string
+	text    string
+	html    string
+}
+
+// renderError is why the template of an Email did not render, with the reason
+// for its Rendered condition.
+type renderError struct {
+	reason string
+	err    error
+}
+
+func (e *renderError) Error() string {
+	return e.err.Error()
+}
+
+func (e *renderError) Unwrap() error {
+	return e.err
+}
+
+// content returns the subject and bodies of email, rendering its template if
+// it has one. Whatever stops the template from rendering is a *renderError.
+func (r *EmailReconciler) content(ctx context.Context, email *nullemailv1.Email) (*content, error) {
+	c := &content{subject: email.Spec.Subject, text: email.Spec.Text, html: email.Spec.HTML}
+	ref := email.Spec.Template
+	if ref == nil {
+		return c, nil
+	}
+
+	tmpl := &nullemailv1.EmailTemplate{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: email.Namespace, Name: ref.Name}, tmpl); err != nil {
+		return nil, &renderError{reasonTemplateNotFound, fmt.Errorf("reading template %s: %w", ref.Name, err)}
+	}
+
+	variables, err := r.variables(ctx, email.Namespace, ref.Variables, tmpl.Spec.Parameters)
+	if err != nil {
+		return nil, err
+	}
+
+	// The Email has the last word.
+	if c.subject == "" {
+		if c.subject, err = renderText("subject", tmpl.Spec.Subject, variables); err != nil {
+			return nil, err
+		}
+	}
+	if c.text == "" {
+		if c.text, err = renderText("text", tmpl.Spec.Text, variables); err != nil {
+			return nil, err
+		}
+	}
+	if c.html == "" {
+		if c.html, err = renderHTML(tmpl.Spec.HTML, variables); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// variables returns the values of the parameters of a template, given the
+// variables of an Email in namespace.
+func (r *EmailReconciler) variables(ctx context.Context, namespace string, variables []nullemailv1.EmailVariable, parameters []nullemailv1.TemplateParameter) (map[string]string, error) {
+	declared := map[string]bool{}
+	values := map[string]string{}
+	for _, parameter := range parameters {
+		declared[parameter.Name] = true
+		if parameter.Default != nil {
+			values[parameter.Name] = *parameter.Default
+		}
+	}
+
+	var unknown []string
+	for _, variable := range variables {
+		if !declared[variable.Name] {
+			unknown = append(unknown, variable.Name)
+			continue
+		}
+
+		value, ok, err := r.variableValue(ctx, namespace, variable)
+		if err != nil {
+			return nil, &renderError{reasonVariableNotFound, fmt.Errorf("variable %s: %w", variable.Name, err)}
+		}
+		if ok {
+			values[variable.Name] = value
+		}
+	}
+	if len(unknown) > 0 {
+		return nil, &renderError{reasonInvalidVariables, fmt.Errorf("the template has no parameters %s", strings.Join(unknown, ", "))}
+	}
+
+	var missing []string
+	for name := range declared {
+		if _, ok := values[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return nil, &renderError{reasonInvalidVariables, fmt.Errorf("parameters %s are not set", strings.Join(missing, ", "))}
+	}
+	return values, nil
+}
+
+// variableValue returns the value of variable, read from a ConfigMap or a
+// Secret in namespace if it says so. ok is false when the value comes from an
+// optional key that is not there.
+func (r *EmailReconciler) variableValue(ctx context.Context, namespace string, variable nullemailv1.EmailVariable) (value string, ok bool, err error) {
+	from := variable.ValueFrom
+	switch {
+	case from == nil:
+		return variable.Value, true, nil
+	case from.ConfigMapKeyRef != nil && from.SecretKeyRef != nil:
+		return "", false, fmt.Errorf("only one of configMapKeyRef and secretKeyRef may be set")
+	case from.ConfigMapKeyRef != nil:
+		ref := from.ConfigMapKeyRef
+		configMap := &corev1.ConfigMap{}
+		err := r.Get(ctx, client.ObjectKey{Namespace: namespace, Name: ref.Name}, configMap)
+		return keyValue(err, ref.Optional, "ConfigMap", ref.Name, ref.Key, func() (string, bool) {
+			value, ok := configMap.Data[ref.Key]
+			return value, ok
+		})
+	case from.SecretKeyRef != nil:
+		ref := from.SecretKeyRef
+		secret := &corev1.Secret{}
+		err := r.Get(ctx, client.ObjectKey{Namespace: namespace, Name: ref.Name}, secret)
+		return keyValue(err, ref.Optional, "Secret", ref.Name, ref.Key, func() (string, bool) {
+			value, ok := secret.Data[ref.Key]
+			return string(value), ok
+		})
+	default:
+		return variable.Value, true, nil
+	}
+}
+
+// keyValue returns the value of key in the ConfigMap or Secret called name,
+// which lookup finds once it was read with readErr. Missing objects and keys
+// are errors, unless optional says otherwise.
+func keyValue(readErr error, optional *bool, kind, name, key string, lookup func() (string, bool)) (string, bool, error) {
+	isOptional := optional != nil && *optional
+	if readErr != nil {
+		if apierrors.IsNotFound(readErr) && isOptional {
+			return "", false, nil
+		}
+		return "", false, fmt.Errorf("reading %s %s: %w", kind, name, readErr)
+	}
+
+	value, ok := lookup()
+	if !ok && !isOptional {
+		return "", false, fmt.Errorf("%s %s has no key %s", kind, name, key)
+	}
+	return value, ok, nil
+}
+
+// renderText renders the text/template text, the named part of an email,
+// with variables. Variables that are not there are an error.
+func renderText(name, text string, variables map[string]string) (string, error) {
+	t, err := texttemplate.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", &renderError{reasonRenderFailed, err}
+	}
+	var b strings.Builder
+	if err := t.Execute(&b, variables); err != nil {
+		return "", &renderError{reasonRenderFailed, err}
+	}
+	return b.String(), nil
+}
+
+// renderHTML renders the html/template html with variables, escaping them.
+func renderHTML(html string, variables map[string]string) (string, error) {
+	t, err := htmltemplate.New("html").Option("missingkey=error").Parse(html)
+	if err != nil {
+		return "", &renderError{reasonRenderFailed, err}
+	}
+	var b strings.Builder
+	if err := t.Execute(&b, variables); err != nil {
+		return "", &renderError{reasonRenderFailed, err}
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,256 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	nullemailv1 "github.com/null-channel/stupid-kube-operators/email/api/v1"
+)
+
+// Keys of the credentials Secret of an SMTPServer.
+const (
+	smtpUsernameKey = "username"
+	smtpPasswordKey = "password"
+)
+
+const (
+	defaultSMTPPort       = 587
+	defaultSMTPTLSPort    = 465
+	defaultConnectTimeout = 10 * time.Second
+	defaultSMTPTimeout    = time.Minute
+	// smtpClientName is what the operator calls itself in EHLO.
+	smtpClientName = "localhost"
+)
+
+// smtpConfig is the SMTP server to send an Email through, and how.
+type smtpConfig struct {
+	host           string
+	port           int32
+	tls            nullemailv1.TLSMode
+	tlsConfig      *tls.Config
+	auth           smtp.Auth
+	connectTimeout time.Duration
+	timeout        time.Duration
+}
+
+// smtpConfig reads the SMTPServer of email, and its credentials.
+func (r *EmailReconciler) smtpConfig(ctx context.Context, email *nullemailv1.Email) (*smtpConfig, error) {
+	server := &nullemailv1.SMTPServer{}
+	key := client.ObjectKey{Namespace: email.Namespace, Name: email.Spec.SMTPRef.Name}
+	if err := r.Get(ctx, key, server); err != nil {
+		return nil, fmt.Errorf("reading SMTP server: %w", err)
+	}
+	spec := server.Spec
+
+	config := &smtpConfig{
+		host:           spec.Host,
+		port:           spec.Port,
+		tls:            spec.TLS,
+		tlsConfig:      &tls.Config{ServerName: spec.Host, InsecureSkipVerify: spec.InsecureSkipVerify},
+		connectTimeout: defaultConnectTimeout,
+		timeout:        defaultSMTPTimeout,
+	}
+	if config.tls == "" {
+		config.tls = nullemailv1.TLSStartTLS
+	}
+	if config.port == 0 {
+		config.port = defaultSMTPPort
+		if config.tls == nullemailv1.TLSImplicit {
+			config.port = defaultSMTPTLSPort
+		}
+	}
+	if spec.ConnectTimeout != nil {
+		config.connectTimeout = spec.ConnectTimeout.Duration
+	}
+	if spec.Timeout != nil {
+		config.timeout = spec.Timeout.Duration
+	}
+
+	if spec.Auth != nil {
+		secret := &corev1.Secret{}
+		secretKey := client.ObjectKey{Namespace: server.Namespace, Name: spec.Auth.SecretRef.Name}
+		if err := r.Get(ctx, secretKey, secret); err != nil {
+			return nil, fmt.Errorf("reading SMTP credentials: %w", err)
+		}
+		username, password := string(secret.Data[smtpUsernameKey]), string(secret.Data[smtpPasswordKey])
+
+		switch spec.Auth.Method {
+		case nullemailv1.SMTPAuthPlain, "":
+			config.auth = smtp.PlainAuth("", username, password, spec.Host)
+		case nullemailv1.SMTPAuthLogin:
+			config.auth = &loginAuth{username: username, password: password, host: spec.Host}
+		case nullemailv1.SMTPAuthCRAMMD5:
+			config.auth = smtp.CRAMMD5Auth(username, password)
+		default:
+			return nil, fmt.Errorf("unknown SMTP auth method %s", spec.Auth.Method)
+		}
+	}
+	return config, nil
+}
+
+// send hands msg over to the SMTP server of config.
+func (config *smtpConfig) send(msg *message) error {
+	addr := net.JoinHostPort(config.host, strconv.Itoa(int(config.port)))
+	dialer := &net.Dialer{Timeout: config.connectTimeout}
+
+	var conn net.Conn
+	var err error
+	if config.tls == nullemailv1.TLSImplicit {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, config.tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	if err := conn.SetDeadline(time.Now().Add(config.timeout)); err != nil {
+		conn.Close()
+		return err
+	}
+
+	c, err := smtp.NewClient(conn, config.host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if err := c.Hello(smtpClientName); err != nil {
+		return err
+	}
+	if config.tls == nullemailv1.TLSStartTLS {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return errors.New("SMTP server does not support STARTTLS")
+		}
+		if err := c.StartTLS(config.tlsConfig); err != nil {
+			return err
+		}
+	}
+	if config.auth != nil {
+		if err := c.Auth(config.auth); err != nil {
+			return err
+		}
+	}
+
+	if err := c.Mail(msg.from); err != nil {
+		return err
+	}
+	for _, recipient := range msg.recipients {
+		if err := c.Rcpt(recipient); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg.data); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		// The server replies once it has the whole email; without a reply,
+		// there is no telling whether it took it.
+		var reply *textproto.Error
+		if !errors.As(err, &reply) {
+			return &maybeSentError{err}
+		}
+		return err
+	}
+	// The server took the email; failing to say goodbye does not change that.
+	_ = c.Quit()
+	return nil
+}
+
+// maybeSentError is an error cutting delivery short after the whole email was
+// handed over to the SMTP server, but before it said whether it took it.
+type maybeSentError struct {
+	err error
+}
+
+func (e *maybeSentError) Error() string {
+	return "the email may or may not have been sent: " + e.err.Error()
+}
+
+func (e *maybeSentError) Unwrap() error {
+	return e.err
+}
+
+// temporary reports whether sending an email again may succeed where err
+// failed, without it being delivered twice: the SMTP server turned the email
+// down for the time being, with a 4xx reply, or could not be talked to before
+// it had the email. 5xx replies are the server's final word.
+func temporary(err error) bool {
+	var maybeSent *maybeSentError
+	if errors.As(err, &maybeSent) {
+		return false
+	}
+	var reply *textproto.Error
+	if errors.As(err, &reply) {
+		return reply.Code >= 400 && reply.Code < 500
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// loginAuth is the LOGIN mechanism, which net/smtp does not have. Like PLAIN,
+// it sends the password as is, so it refuses to without TLS, but to the local
+// host.
+type loginAuth struct {
+	username string
+	password string
+	host     string
+}
+
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if !server.TLS && !isLocalhost(server.Name) {
+		return "", nil, errors.New("unencrypted connection")
+	}
+	if server.Name != a.host {
+		return "", nil, errors.New("wrong host name")
+	}
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	switch string(fromServer) {
+	case "Username:":
+		return []byte(a.username), nil
+	case "Password:":
+		return []byte(a.password), nil
+	default:
+		return nil, fmt.Errorf("unexpected LOGIN challenge %q", fromServer)
+	}
+}
+
+func isLocalhost(name string) bool {
+	return name == "localhost" || name == "127.0.0.1" || name == "::1"
+}
@@ -0,0 +1,56 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:resource:scope=Cluster
+//+kubebuilder:printcolumn:name="Matched",type=integer,JSONPath=`.status.matched`
+//+kubebuilder:printcolumn:name="Labeled",type=integer,JSONPath=`.status.labeled`
+//+kubebuilder:printcolumn:name="Failed",type=integer,JSONPath=`.status.failed`
+//+kubebuilder:printcolumn:name="Violations",type=integer,JSONPath=`.status.violations`
+//+kubebuilder:printcolumn:name="Mode",type=string,JSONPath=`.spec.mode`,priority=1
+//+kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// ClusterLabeler is the Schema for the clusterlabelers API. It is a Labeler
+// for objects in every namespace matching its namespace selector, and for
+// cluster-scoped objects.
+type ClusterLabeler struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   LabelerSpec   `json:"spec,omitempty"`
+	Status LabelerStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// ClusterLabelerList contains a list of ClusterLabeler
+type ClusterLabelerList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ClusterLabeler `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ClusterLabeler{}, &ClusterLabelerList{})
+}
@@ -23,25 +23,217 @@ import (
 // EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
 // NOTE: json tags are required.  Any new fields you add must have json tags for the fields to be serialized.
 
+// TargetKind is a kind of object a Labeler can label, as Kind.group, or as Kind
+// for the core group: Pod, Deployment.apps, Widget.example.com. Deployment,
+// StatefulSet and DaemonSet are short for their apps kinds. The kind has to be
+// in the allow-list of the operator.
+//+kubebuilder:validation:Pattern=`^[A-Z][A-Za-z0-9]*(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*$`
+type TargetKind string
+
+const (
+	TargetPod                   = TargetKind("Pod")
+	TargetService               = TargetKind("Service")
+	TargetPersistentVolumeClaim = TargetKind("PersistentVolumeClaim")
+	TargetNamespace             = TargetKind("Namespace")
+	TargetDeployment            = TargetKind("Deployment.apps")
+	TargetStatefulSet           = TargetKind("StatefulSet.apps")
+	TargetDaemonSet             = TargetKind("DaemonSet.apps")
+)
+
+// LabelerMode is what a Labeler does about the objects it selects.
+//+kubebuilder:validation:Enum=Audit;Mutate;Enforce
+type LabelerMode string
+
+const (
+	// ModeAudit leaves objects alone, and reports those missing required labels.
+	ModeAudit = LabelerMode("Audit")
+	// ModeMutate sets labels and annotations, and fills in the defaults of
+	// missing required labels.
+	ModeMutate = LabelerMode("Mutate")
+	// ModeEnforce reports objects missing required labels like ModeAudit, and
+	// has the webhook reject pods created without them.
+	ModeEnforce = LabelerMode("Enforce")
+)
+
+// RequiredLabel is a label every object a Labeler selects must have.
+type RequiredLabel struct {
+	Key string `json:"key"`
+
+	// Default is set on selected objects that do not have the label, in Mutate
+	// mode. It is a template, like the values of labels. Without a default,
+	// objects missing the label are only reported.
+	Default string `json:"default,omitempty"`
+}
+
 // LabelerSpec defines the desired state of Labeler
 type LabelerSpec struct {
-	// INSERT ADDITIONAL SPEC FIELDS - desired state of cluster
-	// Important: Run "make" to regenerate code after modifying this file
+	// Labels are set on every matching object. Values are Go templates,
+	// evaluated against each object. They can use .Kind, .Name, .Labels,
+	// .Annotations, .Namespace.Name, .Namespace.Labels, .Owner.Kind and
+	// .Owner.Name of the controller of the object, .NodeName for pods, .Spec
+	// with the field names of the Go types, .Annotation "key", and the tag
+	// function, which returns the tag of a container image:
+	//   team: '{{ .Namespace.Labels.team }}'
+	//   image-tag: '{{ (index .Spec.Containers 0).Image | tag }}'
+	// Objects a value does not render to a valid label value for are listed
+	// in the status, and do not get that label.
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// Annotations are set on every matching object. Values are templates, like
+	// the values of labels.
+	Annotations map[string]string `json:"annotations,omitempty"`
+
+	// Selector picks the objects to label by their own labels. An empty selector matches every object.
+	Selector *metav1.LabelSelector `json:"selector,omitempty"`
 
-	// Foo is an example field of Labeler. Edit labeler_types.go to remove/update
-	Label string `json:"label,omitempty"`
+	// NamespaceSelector limits a ClusterLabeler to objects in matching namespaces. An empty selector matches every
+	// namespace. It does not apply to cluster-scoped objects, namespaces included. A Labeler only ever selects objects
+	// in its own namespace, and only if the namespace matches.
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+
+	// Targets are the kinds of objects to label. Defaults to pods only. Only ClusterLabelers can target
+	// cluster-scoped kinds.
+	Targets []TargetKind `json:"targets,omitempty"`
+
+	// Priority settles conflicts with other Labelers. When several Labelers select an
+	// object and want different values for the same label or annotation key, the one
+	// with the highest priority gets the key and the others leave it alone. Ties go to
+	// the first Labeler by namespace and name. Labelers that agree on a value share it.
+	Priority int32 `json:"priority,omitempty"`
+
+	// Mode is Audit, Mutate or Enforce. Only Mutate Labelers change objects:
+	// Audit and Enforce Labelers neither set their labels and annotations nor
+	// take them back. Defaults to Mutate.
+	//+kubebuilder:default=Mutate
+	Mode LabelerMode `json:"mode,omitempty"`
+
+	// Required are the labels every selected object must have. Objects missing
+	// some are reported in the status and through Events on the Labeler.
+	Required []RequiredLabel `json:"required,omitempty"`
+
+	// Remove are label keys to take off every selected object, whoever set
+	// them, in Mutate mode.
+	Remove []string `json:"remove,omitempty"`
+
+	// Rename moves labels of every selected object from one key to another,
+	// value and all, in Mutate mode:
+	//   app: app.kubernetes.io/name
+	// Objects that have the new key already keep its value, and lose the old
+	// key. Selectors should not rely on keys that are removed or renamed, or
+	// objects fall out of the Labeler as they are migrated.
+	Rename map[string]string `json:"rename,omitempty"`
 }
 
+const (
+	// ConditionReady is true when the last sync labeled every object the Labeler selects.
+	ConditionReady = "Ready"
+	// ConditionDegraded is true when the Labeler could not label some of the objects it selects.
+	ConditionDegraded = "Degraded"
+)
+
 // LabelerStatus defines the observed state of Labeler
 type LabelerStatus struct {
-	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
-	// Important: Run "make" to regenerate code after modifying this file
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+
+	// ObservedGeneration is the generation of the spec the status is about.
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Matched is the number of objects the Labeler selects.
+	Matched int32 `json:"matched"`
+
+	// Labeled is the number of selected objects that carry the labels and annotations of the Labeler.
+	Labeled int32 `json:"labeled"`
+
+	// Failed is the number of objects the last sync could not label or unlabel.
+	Failed int32 `json:"failed"`
+
+	// LastSyncTime is when the Labeler last went through all of its objects without an error.
+	LastSyncTime *metav1.Time `json:"lastSyncTime,omitempty"`
+
+	// Failures are the objects the last reconcile could not label or unlabel.
+	// Only the first few are kept.
+	Failures []LabelFailure `json:"failures,omitempty"`
+
+	// Conflicts are the keys this Labeler and others want different values for, on objects they both select.
+	Conflicts []LabelConflict `json:"conflicts,omitempty"`
+
+	// Violations is the number of selected objects missing required labels.
+	Violations int32 `json:"violations"`
+
+	// Violating are the objects missing required labels. Only the first few are kept.
+	Violating []LabelViolation `json:"violating,omitempty"`
+
+	// Migrated is the number of objects the removals and renames of the Labeler
+	// changed since its spec last changed.
+	Migrated int32 `json:"migrated"`
+
+	// PendingMigration is the number of selected objects that still carry labels
+	// to remove or rename, as of the last sync.
+	PendingMigration int32 `json:"pendingMigration"`
+
+	// Cleanup is the progress of taking the labels back off objects while the Labeler is being deleted.
+	Cleanup *CleanupStatus `json:"cleanup,omitempty"`
+}
+
+// LabelViolation is an object missing required labels.
+type LabelViolation struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+	// Missing are the keys of the required labels the object does not have.
+	Missing []string `json:"missing"`
+}
+
+// ConflictField is the part of the metadata a conflict is about.
+//+kubebuilder:validation:Enum=Label;Annotation
+type ConflictField string
+
+const (
+	ConflictLabel      = ConflictField("Label")
+	ConflictAnnotation = ConflictField("Annotation")
+)
+
+// LabelConflict is a key this Labeler and another one disagree on.
+type LabelConflict struct {
+	Field ConflictField `json:"field"`
+	Key   string        `json:"key"`
+	// Labeler is the other Labeler, as namespace/name, or the name of a ClusterLabeler.
+	Labeler string `json:"labeler"`
+	// Won is true when the key went to this Labeler.
+	Won bool `json:"won"`
+	// Objects is the number of objects the two Labelers disagree on.
+	Objects int32 `json:"objects"`
+}
+
+// CleanupStatus is the progress of the cleanup of a deleted Labeler.
+type CleanupStatus struct {
+	// Cleaned is the number of objects the labels and annotations of the Labeler were removed from.
+	Cleaned int32 `json:"cleaned"`
+	// Remaining is the number of objects that still carry some, as of the last attempt.
+	Remaining int32 `json:"remaining"`
+}
+
+// LabelFailure is an object a Labeler failed to apply its labels to, or to remove them from.
+type LabelFailure struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+	Message   string `json:"message"`
 }
 
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Matched",type=integer,JSONPath=`.status.matched`
+//+kubebuilder:printcolumn:name="Labeled",type=integer,JSONPath=`.status.labeled`
+//+kubebuilder:printcolumn:name="Failed",type=integer,JSONPath=`.status.failed`
+//+kubebuilder:printcolumn:name="Violations",type=integer,JSONPath=`.status.violations`
+//+kubebuilder:printcolumn:name="Mode",type=string,JSONPath=`.spec.mode`,priority=1
+//+kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 
-// Labeler is the Schema for the labelers API
+// Labeler is the Schema for the labelers API. A Labeler only labels objects
+// in its own namespace, so that tenants can be allowed to manage Labelers in
+// their namespaces. ClusterLabelers label across namespaces.
 type Labeler struct {
 	metav1.TypeMeta   `json:",inline"`
 	metav1.ObjectMeta `json:"metadata,omitempty"`
@@ -0,0 +1,201 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	nulllabelerv1 "github.com/null-channel/stupid-kube-operators/labeler/api/v1"
+)
+
+// maxFieldManagerLength is the longest field manager name the API server accepts.
+const maxFieldManagerLength = 128
+
+// fieldManagerPrefix starts the field manager of every Labeler.
+const fieldManagerPrefix = "nulllabeler/"
+
+// fieldManager is the server-side apply field manager of labeler. Every
+// Labeler gets its own, so the API server tracks which labels came from which
+// Labeler, and a Labeler can take back its labels without touching anybody
+// else's.
+func fieldManager(labeler *nulllabelerv1.Labeler) string {
+	name := fieldManagerPrefix + labelerName(labeler)
+	if len(name) > maxFieldManagerLength {
+		return fieldManagerPrefix + string(labeler.UID)
+	}
+	return name
+}
+
+// ownedKeys returns the label and annotation keys manager applied to obj.
+func ownedKeys(obj client.Object, manager string) (labels, annotations map[string]bool) {
+	labels = map[string]bool{}
+	annotations = map[string]bool{}
+
+	for _, entry := range obj.GetManagedFields() {
+		if entry.Manager == manager && entry.Operation == metav1.ManagedFieldsOperationApply {
+			addOwnedKeys(entry, labels, annotations)
+		}
+	}
+
+	return labels, annotations
+}
+
+// labelManagers returns the field managers of the Labelers that own labels or
+// annotations of obj.
+func labelManagers(obj client.Object) []string {
+	var managers []string
+	for _, entry := range obj.GetManagedFields() {
+		if !strings.HasPrefix(entry.Manager, fieldManagerPrefix) || entry.Operation != metav1.ManagedFieldsOperationApply {
+			continue
+		}
+
+		labels, annotations := map[string]bool{}, map[string]bool{}
+		addOwnedKeys(entry, labels, annotations)
+		if len(labels) > 0 || len(annotations) > 0 {
+			managers = append(managers, entry.Manager)
+		}
+	}
+	return managers
+}
+
+// labelOwners returns the field managers of the Labelers, but for except, that
+// applied each label of obj.
+func labelOwners(obj client.Object, except string) map[string][]string {
+	owners := map[string][]string{}
+	for _, entry := range obj.GetManagedFields() {
+		if entry.Manager == except || !strings.HasPrefix(entry.Manager, fieldManagerPrefix) || entry.Operation != metav1.ManagedFieldsOperationApply {
+			continue
+		}
+
+		labels := map[string]bool{}
+		addOwnedKeys(entry, labels, map[string]bool{})
+		for key := range labels {
+			owners[key] = append(owners[key], entry.Manager)
+		}
+	}
+	return owners
+}
+
+// labelerOf returns the name of the Labeler with field manager manager, as
+// labelerName does. Labelers with names too long for a field manager go by
+// their UID.
+func labelerOf(manager string) string {
+	return strings.TrimPrefix(manager, fieldManagerPrefix)
+}
+
+// addOwnedKeys adds the label and annotation keys owned through entry to
+// labels and annotations.
+func addOwnedKeys(entry metav1.ManagedFieldsEntry, labels, annotations map[string]bool) {
+	if entry.FieldsV1 == nil {
+		return
+	}
+
+	var fields struct {
+		Metadata struct {
+			Labels      map[string]json.RawMessage `json:"f:labels"`
+			Annotations map[string]json.RawMessage `json:"f:annotations"`
+		} `json:"f:metadata"`
+	}
+	if err := json.Unmarshal(entry.FieldsV1.Raw, &fields); err != nil {
+		return
+	}
+
+	for key := range fields.Metadata.Labels {
+		if strings.HasPrefix(key, "f:") {
+			labels[strings.TrimPrefix(key, "f:")] = true
+		}
+	}
+	for key := range fields.Metadata.Annotations {
+		if strings.HasPrefix(key, "f:") {
+			annotations[strings.TrimPrefix(key, "f:")] = true
+		}
+	}
+}
+
+// appliedFieldsEntry returns the managed fields entry recording manager as
+// having applied labels and annotations, at the given time, to an object of
+// apiVersion.
+func appliedFieldsEntry(manager, apiVersion string, labels, annotations map[string]string, at metav1.Time) (metav1.ManagedFieldsEntry, error) {
+	metadata := map[string]interface{}{}
+	for field, values := range map[string]map[string]string{"f:labels": labels, "f:annotations": annotations} {
+		if len(values) == 0 {
+			continue
+		}
+		keys := map[string]interface{}{}
+		for key := range values {
+			keys["f:"+key] = struct{}{}
+		}
+		metadata[field] = keys
+	}
+
+	raw, err := json.Marshal(map[string]interface{}{"f:metadata": metadata})
+	if err != nil {
+		return metav1.ManagedFieldsEntry{}, err
+	}
+
+	return metav1.ManagedFieldsEntry{
+		Manager:    manager,
+		Operation:  metav1.ManagedFieldsOperationApply,
+		APIVersion: apiVersion,
+		Time:       &at,
+		FieldsType: "FieldsV1",
+		FieldsV1:   &metav1.FieldsV1{Raw: raw},
+	}, nil
+}
+
+// upToDate reports whether obj carries exactly the labels and annotations
+// want*, as applied by manager.
+func upToDate(obj client.Object, manager string, wantLabels, wantAnnotations map[string]string) bool {
+	ownedLabels, ownedAnnotations := ownedKeys(obj, manager)
+	return sameKeys(ownedLabels, wantLabels) && containsAll(obj.GetLabels(), wantLabels) &&
+		sameKeys(ownedAnnotations, wantAnnotations) && containsAll(obj.GetAnnotations(), wantAnnotations)
+}
+
+func sameKeys(have map[string]bool, want map[string]string) bool {
+	if len(have) != len(want) {
+		return false
+	}
+	for k := range want {
+		if !have[k] {
+			return false
+		}
+	}
+	return true
+}
+
+// applyMetadata server-side applies labels and annotations to the object of
+// kind gvk at key, as manager. Whatever manager applied before and is not in
+// labels or annotations any more is removed, so applying nothing takes back
+// everything manager ever applied.
+func applyMetadata(ctx context.Context, c client.Client, gvk schema.GroupVersionKind, key client.ObjectKey,
+	manager string, labels, annotations map[string]string) error {
+	u := &unstructured.Unstructured{}
+	u.SetGroupVersionKind(gvk)
+	u.SetNamespace(key.Namespace)
+	u.SetName(key.Name)
+	u.SetLabels(labels)
+	u.SetAnnotations(annotations)
+
+	return c.Patch(ctx, u, client.Apply, client.FieldOwner(manager), client.ForceOwnership)
+}
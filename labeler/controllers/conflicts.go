@@ -0,0 +1,106 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"sort"
+
+	nulllabelerv1 "github.com/null-channel/stupid-kube-operators/labeler/api/v1"
+)
+
+// contender is a Labeler claiming labels and annotations on an object.
+type contender struct {
+	// name is the Labeler, as namespace/name.
+	name        string
+	priority    int32
+	labels      map[string]string
+	annotations map[string]string
+}
+
+// conflict is a key two Labelers want different values for on the same object.
+type conflict struct {
+	field nulllabelerv1.ConflictField
+	key   string
+	// with is the other Labeler.
+	with string
+	// won is true when the key went to us.
+	won bool
+}
+
+// resolution is what one contender gets to apply to an object.
+type resolution struct {
+	labels      map[string]string
+	annotations map[string]string
+	conflicts   []conflict
+}
+
+// resolve splits the keys claimed on one object between contenders. For
+// every key the contender with the highest priority wins, ties going to the
+// first by name. Contenders that want the same value for a key do not
+// conflict: they all apply it and share its ownership. The result is keyed
+// by contender name.
+func resolve(contenders []contender) map[string]*resolution {
+	ordered := make([]contender, len(contenders))
+	copy(ordered, contenders)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		if ordered[i].priority != ordered[j].priority {
+			return ordered[i].priority > ordered[j].priority
+		}
+		return ordered[i].name < ordered[j].name
+	})
+
+	result := map[string]*resolution{}
+	for _, c := range ordered {
+		result[c.name] = &resolution{labels: map[string]string{}, annotations: map[string]string{}}
+	}
+
+	resolveField(ordered, result, nulllabelerv1.ConflictLabel,
+		func(c contender) map[string]string { return c.labels },
+		func(r *resolution) map[string]string { return r.labels })
+	resolveField(ordered, result, nulllabelerv1.ConflictAnnotation,
+		func(c contender) map[string]string { return c.annotations },
+		func(r *resolution) map[string]string { return r.annotations })
+
+	return result
+}
+
+// resolveField resolves the keys of one field, labels or annotations, between
+// ordered contenders, best first.
+func resolveField(ordered []contender, result map[string]*resolution, field nulllabelerv1.ConflictField,
+	claims func(contender) map[string]string, granted func(*resolution) map[string]string) {
+	// winner is the first contender to claim each key.
+	winner := map[string]contender{}
+
+	for _, c := range ordered {
+		for key, value := range claims(c) {
+			w, taken := winner[key]
+			if !taken {
+				winner[key] = c
+				granted(result[c.name])[key] = value
+				continue
+			}
+
+			if claims(w)[key] == value {
+				granted(result[c.name])[key] = value
+				continue
+			}
+
+			result[c.name].conflicts = append(result[c.name].conflicts, conflict{field: field, key: key, with: w.name})
+			result[w.name].conflicts = append(result[w.name].conflicts, conflict{field: field, key: key, with: c.name, won: true})
+		}
+	}
+}
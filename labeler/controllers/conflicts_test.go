@@ -0,0 +1,143 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"reflect"
+	"testing"
+
+	nulllabelerv1 "github.com/null-channel/stupid-kube-operators/labeler/api/v1"
+)
+
+func TestResolve(t *testing.T) {
+	tests := []struct {
+		name       string
+		contenders []contender
+		want       map[string]*resolution
+	}{
+		{
+			name: "higher priority wins",
+			contenders: []contender{
+				{name: "a/low", priority: 1, labels: map[string]string{"team": "web", "tier": "front"}},
+				{name: "b/high", priority: 10, labels: map[string]string{"team": "ops"}},
+			},
+			want: map[string]*resolution{
+				"a/low": {
+					labels:      map[string]string{"tier": "front"},
+					annotations: map[string]string{},
+					conflicts:   []conflict{{field: nulllabelerv1.ConflictLabel, key: "team", with: "b/high"}},
+				},
+				"b/high": {
+					labels:      map[string]string{"team": "ops"},
+					annotations: map[string]string{},
+					conflicts:   []conflict{{field: nulllabelerv1.ConflictLabel, key: "team", with: "a/low", won: true}},
+				},
+			},
+		},
+		{
+			name: "ties go to the first by name",
+			contenders: []contender{
+				{name: "ns/zeta", labels: map[string]string{"team": "web"}},
+				{name: "ns/alpha", labels: map[string]string{"team": "ops"}},
+			},
+			want: map[string]*resolution{
+				"ns/alpha": {
+					labels:      map[string]string{"team": "ops"},
+					annotations: map[string]string{},
+					conflicts:   []conflict{{field: nulllabelerv1.ConflictLabel, key: "team", with: "ns/zeta", won: true}},
+				},
+				"ns/zeta": {
+					labels:      map[string]string{},
+					annotations: map[string]string{},
+					conflicts:   []conflict{{field: nulllabelerv1.ConflictLabel, key: "team", with: "ns/alpha"}},
+				},
+			},
+		},
+		{
+			name: "equal values are shared",
+			contenders: []contender{
+				{name: "ns/a", priority: 5, labels: map[string]string{"team": "web"}},
+				{name: "ns/b", labels: map[string]string{"team": "web"}},
+			},
+			want: map[string]*resolution{
+				"ns/a": {labels: map[string]string{"team": "web"}, annotations: map[string]string{}},
+				"ns/b": {labels: map[string]string{"team": "web"}, annotations: map[string]string{}},
+			},
+		},
+		{
+			name: "labels and annotations are resolved apart",
+			contenders: []contender{
+				{name: "ns/a", priority: 1, labels: map[string]string{"owner": "a"}, annotations: map[string]string{"owner": "a"}},
+				{name: "ns/b", priority: 2, annotations: map[string]string{"owner": "b"}},
+			},
+			want: map[string]*resolution{
+				"ns/a": {
+					labels:      map[string]string{"owner": "a"},
+					annotations: map[string]string{},
+					conflicts:   []conflict{{field: nulllabelerv1.ConflictAnnotation, key: "owner", with: "ns/b"}},
+				},
+				"ns/b": {
+					labels:      map[string]string{},
+					annotations: map[string]string{"owner": "b"},
+					conflicts:   []conflict{{field: nulllabelerv1.ConflictAnnotation, key: "owner", with: "ns/a", won: true}},
+				},
+			},
+		},
+		{
+			name: "the winner conflicts with every loser",
+			contenders: []contender{
+				{name: "ns/a", priority: 3, labels: map[string]string{"team": "a"}},
+				{name: "ns/b", priority: 2, labels: map[string]string{"team": "b"}},
+				{name: "ns/c", priority: 1, labels: map[string]string{"team": "c"}},
+			},
+			want: map[string]*resolution{
+				"ns/a": {
+					labels:      map[string]string{"team": "a"},
+					annotations: map[string]string{},
+					conflicts: []conflict{
+						{field: nulllabelerv1.ConflictLabel, key: "team", with: "ns/b", won: true},
+						{field: nulllabelerv1.ConflictLabel, key: "team", with: "ns/c", won: true},
+					},
+				},
+				"ns/b": {
+					labels:      map[string]string{},
+					annotations: map[string]string{},
+					conflicts:   []conflict{{field: nulllabelerv1.ConflictLabel, key: "team", with: "ns/a"}},
+				},
+				"ns/c": {
+					labels:      map[string]string{},
+					annotations: map[string]string{},
+					conflicts:   []conflict{{field: nulllabelerv1.ConflictLabel, key: "team", with: "ns/a"}},
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := resolve(tt.contenders)
+			if len(got) != len(tt.want) {
+				t.Fatalf("resolve() returned %d resolutions, want %d", len(got), len(tt.want))
+			}
+			for name, want := range tt.want {
+				if !reflect.DeepEqual(got[name], want) {
+					t.Errorf("resolve()[%q] = %+v, want %+v", name, got[name], want)
+				}
+			}
+		})
+	}
+}
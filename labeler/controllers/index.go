@@ -0,0 +1,283 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+
+	nulllabelerv1 "github.com/null-channel/stupid-kube-operators/labeler/api/v1"
+)
+
+const (
+	// labelerTargetsField indexes Labelers and ClusterLabelers by the kinds they target.
+	labelerTargetsField = "spec.targets"
+	// labelManagersField indexes target objects by the Labelers that labeled
+	// them, as field managers.
+	labelManagersField = "metadata.managedFields.manager"
+)
+
+var mapLog = ctrl.Log.WithName("labeler").WithName("map")
+
+// indexFields adds the indexes the reconciler and its map functions look
+// objects up by, for Labelers and for objects of the target kinds.
+func indexFields(ctx context.Context, indexer client.FieldIndexer, targets map[nulllabelerv1.TargetKind]targetType) error {
+	err := indexer.IndexField(ctx, &nulllabelerv1.Labeler{}, labelerTargetsField, func(o client.Object) []string {
+		return targetKeys(o.(*nulllabelerv1.Labeler))
+	})
+	if err != nil {
+		return err
+	}
+	err = indexer.IndexField(ctx, &nulllabelerv1.ClusterLabeler{}, labelerTargetsField, func(o client.Object) []string {
+		return targetKeys(asLabeler(o.(*nulllabelerv1.ClusterLabeler)))
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, t := range targets {
+		if err := indexer.IndexField(ctx, t.newObject(), labelManagersField, labelManagers); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// targetKeys returns the index keys of labeler for labelerTargetsField.
+func targetKeys(labeler *nulllabelerv1.Labeler) []string {
+	var kinds []string
+	for _, kind := range targetsOf(labeler) {
+		kinds = append(kinds, string(kind))
+	}
+	return kinds
+}
+
+// labelersFor returns a map function from an object of kind to the Labelers
+// that select it, and to those that labeled it before and may have to take
+// their labels back. Updates map both the old and the new object, so a
+// Labeler that stops selecting an object hears about it too. The object is
+// noted as changed for each of them, so that they only look at it again.
+func (r *LabelerReconciler) labelersFor(kind nulllabelerv1.TargetKind) handler.MapFunc {
+	return func(obj client.Object) []ctrl.Request {
+		ctx := context.Background()
+
+		namespaceLabels := map[string]labels.Set{}
+		if obj.GetNamespace() != "" {
+			namespace := &core.Namespace{}
+			if err := r.Client.Get(ctx, client.ObjectKey{Name: obj.GetNamespace()}, namespace); client.IgnoreNotFound(err) != nil {
+				mapLog.Error(err, "looking up namespace", "namespace", obj.GetNamespace())
+				return nil
+			}
+			namespaceLabels[obj.GetNamespace()] = labels.Set(namespace.Labels)
+		}
+
+		labelers, err := listLabelers(ctx, r.Client, client.MatchingFields{labelerTargetsField: string(kind)})
+		if err != nil {
+			mapLog.Error(err, "listing labelers", "kind", kind)
+			return nil
+		}
+
+		managers := map[string]bool{}
+		for _, manager := range labelManagers(obj) {
+			managers[manager] = true
+		}
+
+		ref := objectRef{kind: kind, key: client.ObjectKeyFromObject(obj)}
+		requests := []ctrl.Request{}
+		for _, labeler := range labelers {
+			if !managers[fieldManager(labeler)] {
+				m, err := newMatcher(labeler, namespaceLabels)
+				if err != nil || !m.selects(kind, obj) {
+					continue
+				}
+			}
+			key := client.ObjectKeyFromObject(labeler)
+			r.changed(key, ref)
+			requests = append(requests, ctrl.Request{NamespacedName: key})
+		}
+		return requests
+	}
+}
+
+// labelersForNamespace maps a namespace to the ClusterLabelers whose namespace
+// selector matches it, and to the Labelers in it whose selector does. They go
+// through all of their objects again, as the namespace may select others.
+func (r *LabelerReconciler) labelersForNamespace(obj client.Object) []ctrl.Request {
+	labelers, err := listLabelers(context.Background(), r.Client)
+	if err != nil {
+		mapLog.Error(err, "listing labelers")
+		return nil
+	}
+
+	requests := []ctrl.Request{}
+	for _, labeler := range labelers {
+		if labeler.Namespace != "" && labeler.Namespace != obj.GetName() {
+			continue
+		}
+		selector, err := selectorFor(labeler.Spec.NamespaceSelector)
+		if err != nil || !selector.Matches(labels.Set(obj.GetLabels())) {
+			continue
+		}
+		key := client.ObjectKeyFromObject(labeler)
+		r.resync(key)
+		requests = append(requests, ctrl.Request{NamespacedName: key})
+	}
+	return requests
+}
+
+// objectRef is an object of a target kind.
+type objectRef struct {
+	kind nulllabelerv1.TargetKind
+	key  client.ObjectKey
+}
+
+// syncedObjects are the results of syncing the objects of a Labeler, by object.
+// Objects the Labeler does not select and failed nothing on are left out.
+type syncedObjects map[objectRef]syncResult
+
+// set records result for the object at ref, without what is particular to the
+// pass that had it.
+func (s syncedObjects) set(ref objectRef, result syncResult) {
+	if result.matched == 0 && result.failed == 0 {
+		delete(s, ref)
+		return
+	}
+	result.applied, result.migrated, result.errs = 0, 0, nil
+	s[ref] = result
+}
+
+// total returns the results of all objects together, with their failures and
+// violations in the order sync comes across the objects.
+func (s syncedObjects) total() syncResult {
+	total := syncResult{}
+	var reported []objectRef
+	for ref, result := range s {
+		if len(result.failures) > 0 || len(result.violating) > 0 {
+			reported = append(reported, ref)
+		}
+		result.failures, result.violating = nil, nil
+		total.add(result)
+	}
+
+	sort.Slice(reported, func(i, j int) bool {
+		a, b := reported[i], reported[j]
+		if a.kind != b.kind {
+			return a.kind < b.kind
+		}
+		if a.key.Namespace != b.key.Namespace {
+			return a.key.Namespace < b.key.Namespace
+		}
+		return a.key.Name < b.key.Name
+	})
+	for _, ref := range reported {
+		total.add(syncResult{failures: s[ref].failures, violating: s[ref].violating})
+	}
+	return total
+}
+
+// syncState is what the reconciler keeps of the last sync of each Labeler.
+type syncState struct {
+	// generation is that of the spec the objects were synced for.
+	generation int64
+	// objects are the results of the sync, or nil until the Labeler has to go
+	// through all of its objects again.
+	objects syncedObjects
+	// changed are the objects that changed since.
+	changed map[objectRef]bool
+	// stale is set when the Labeler has to resync while it is being synced.
+	stale bool
+}
+
+// syncStates are the syncStates of the Labelers, by their keys, which map
+// functions and reconciles share.
+type syncStates struct {
+	mu     sync.Mutex
+	states map[client.ObjectKey]*syncState
+}
+
+// state returns the syncState of the Labeler at key. s.mu has to be held.
+func (s *syncStates) state(key client.ObjectKey) *syncState {
+	if s.states == nil {
+		s.states = map[client.ObjectKey]*syncState{}
+	}
+	if s.states[key] == nil {
+		s.states[key] = &syncState{changed: map[objectRef]bool{}}
+	}
+	return s.states[key]
+}
+
+// changed notes that the object at ref changed, for the Labeler at key.
+func (r *LabelerReconciler) changed(key client.ObjectKey, ref objectRef) {
+	r.syncStates.mu.Lock()
+	defer r.syncStates.mu.Unlock()
+	r.syncStates.state(key).changed[ref] = true
+}
+
+// resync has the Labeler at key go through all of its objects next time.
+func (r *LabelerReconciler) resync(key client.ObjectKey) {
+	r.syncStates.mu.Lock()
+	defer r.syncStates.mu.Unlock()
+	state := r.syncStates.state(key)
+	state.objects, state.stale = nil, true
+}
+
+// forget drops what is kept of the Labeler at key.
+func (r *LabelerReconciler) forget(key client.ObjectKey) {
+	r.syncStates.mu.Lock()
+	defer r.syncStates.mu.Unlock()
+	delete(r.syncStates.states, key)
+}
+
+// changedSince returns the results of the last sync of the Labeler at key, if
+// it was for generation and nothing had the Labeler resync since, and the
+// objects that changed since, which count as seen from then on.
+func (r *LabelerReconciler) changedSince(key client.ObjectKey, generation int64) (syncedObjects, []objectRef) {
+	r.syncStates.mu.Lock()
+	defer r.syncStates.mu.Unlock()
+	state := r.syncStates.state(key)
+
+	changed := make([]objectRef, 0, len(state.changed))
+	for ref := range state.changed {
+		changed = append(changed, ref)
+	}
+	state.changed = map[objectRef]bool{}
+
+	objects := state.objects
+	state.objects, state.stale = nil, false
+	if state.generation != generation {
+		return nil, changed
+	}
+	return objects, changed
+}
+
+// synced records objects, the results of syncing the Labeler at key at
+// generation, unless something had it resync in the meantime.
+func (r *LabelerReconciler) synced(key client.ObjectKey, generation int64, objects syncedObjects) {
+	r.syncStates.mu.Lock()
+	defer r.syncStates.mu.Unlock()
+	state := r.syncStates.state(key)
+	if !state.stale {
+		state.generation, state.objects = generation, objects
+	}
+}
@@ -0,0 +1,172 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	nulllabelerv1 "github.com/null-channel/stupid-kube-operators/labeler/api/v1"
+)
+
+func TestReconcileChangedObjects(t *testing.T) {
+	labeler := &nulllabelerv1.Labeler{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "team"},
+		Spec: nulllabelerv1.LabelerSpec{
+			Labels:   map[string]string{"team": "web"},
+			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+		},
+	}
+	r, c := newTestReconciler(t,
+		&core.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}},
+		labeler,
+		testPod("default", "web-1", map[string]string{"app": "web"}),
+		testPod("default", "db-1", map[string]string{"app": "db"}),
+		testPod("default", "db-2", map[string]string{"app": "db"}),
+	)
+	key := client.ObjectKeyFromObject(labeler)
+	ctx := context.Background()
+
+	if _, err := reconcileLabeler(t, r, key); err != nil {
+		t.Fatal(err)
+	}
+
+	// Both db pods join, but only the event of the first is in.
+	var changed *core.Pod
+	for _, name := range []string{"db-1", "db-2"} {
+		changed = &core.Pod{}
+		if err := c.Get(ctx, client.ObjectKey{Namespace: "default", Name: name}, changed); err != nil {
+			t.Fatal(err)
+		}
+		changed.Labels["app"] = "web"
+		if err := c.Update(ctx, changed); err != nil {
+			t.Fatal(err)
+		}
+		if name == "db-1" {
+			if requests := r.labelersFor(nulllabelerv1.TargetPod)(changed); len(requests) != 1 || requests[0].NamespacedName != key {
+				t.Fatalf("pod mapped to %v, want %s", requests, key)
+			}
+		}
+	}
+
+	got, err := reconcileLabeler(t, r, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if labels := podLabels(t, c, "default", "db-1"); labels["team"] != "web" {
+		t.Errorf("changed pod labels = %v, want it labeled", labels)
+	}
+	if labels := podLabels(t, c, "default", "db-2"); labels["team"] != "" {
+		t.Errorf("pod without an event labels = %v, want it left alone", labels)
+	}
+	// The pods synced before still count.
+	if got.Status.Matched != 2 || got.Status.Labeled != 2 {
+		t.Errorf("matched %d, labeled %d, want 2, 2", got.Status.Matched, got.Status.Labeled)
+	}
+
+	// Another Labeler changing has this one go through everything again.
+	r.GetAll(labeler)
+	got, err = reconcileLabeler(t, r, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if labels := podLabels(t, c, "default", "db-2"); labels["team"] != "web" {
+		t.Errorf("pod labels after resync = %v, want it labeled", labels)
+	}
+	if got.Status.Matched != 3 || got.Status.Labeled != 3 {
+		t.Errorf("matched %d, labeled %d after resync, want 3, 3", got.Status.Matched, got.Status.Labeled)
+	}
+
+	// Deleted pods drop out of the counts.
+	if err := c.Delete(ctx, changed); err != nil {
+		t.Fatal(err)
+	}
+	r.labelersFor(nulllabelerv1.TargetPod)(changed)
+	got, err = reconcileLabeler(t, r, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Status.Matched != 2 || got.Status.Labeled != 2 {
+		t.Errorf("matched %d, labeled %d after delete, want 2, 2", got.Status.Matched, got.Status.Labeled)
+	}
+}
+
+// BenchmarkPodEvent measures the reconciles a single pod event causes, with
+// every Labeler going through every object, as it was, and with the Labelers
+// selecting the pod looking at it alone.
+func BenchmarkPodEvent(b *testing.B) {
+	const (
+		namespaces = 10
+		labelers   = 20
+		pods       = 1000
+	)
+
+	objs := []client.Object{}
+	for i := 0; i < namespaces; i++ {
+		objs = append(objs, &core.Namespace{ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("ns-%d", i)}})
+	}
+	for i := 0; i < labelers; i++ {
+		// The pods are spread over namespaces, which takes ClusterLabelers.
+		objs = append(objs, &nulllabelerv1.ClusterLabeler{
+			ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("labeler-%d", i)},
+			Spec: nulllabelerv1.LabelerSpec{
+				Labels:   map[string]string{"team": fmt.Sprintf("team-%d", i)},
+				Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": fmt.Sprintf("app-%d", i)}},
+			},
+		})
+	}
+	var pod *core.Pod
+	for i := 0; i < pods; i++ {
+		pod = testPod(fmt.Sprintf("ns-%d", i%namespaces), fmt.Sprintf("pod-%d", i), map[string]string{"app": fmt.Sprintf("app-%d", i%labelers)})
+		objs = append(objs, pod)
+	}
+
+	r, _ := newTestReconciler(b, objs...)
+	ctx := context.Background()
+
+	reconcile := func(b *testing.B, requests []ctrl.Request) {
+		for _, req := range requests {
+			if _, err := r.Reconcile(ctx, req); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+	// The first sync labels every pod, which events do not have to repeat.
+	reconcile(b, r.GetAll(pod))
+
+	b.Run("every labeler", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			reconcile(b, r.GetAll(pod))
+		}
+	})
+
+	b.Run("changed pod", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			requests := r.labelersFor(nulllabelerv1.TargetPod)(pod)
+			if len(requests) != 1 {
+				b.Fatalf("pod mapped to %d labelers, want 1", len(requests))
+			}
+			reconcile(b, requests)
+		}
+	})
+}
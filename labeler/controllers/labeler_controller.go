@@ -18,87 +18,780 @@ package controllers
 
 import (
 	"context"
+	"fmt"
+	"sort"
 
 	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
 	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	nulllabelerv1 "github.com/null-channel/stupid-kube-operators/labeler/api/v1"
 )
 
+const (
+	reasonSynced      = "Synced"
+	reasonSyncFailed  = "SyncFailed"
+	reasonInvalidSpec = "InvalidSpec"
+)
+
+// maxReportedFailures caps the failures kept in the status of a Labeler.
+const maxReportedFailures = 10
+
+// cleanupFinalizer holds a Labeler back until its labels are gone from every object.
+const cleanupFinalizer = "nulllabeler.thenullchannel.dev/cleanup"
+
 // LabelerReconciler reconciles a Labeler object
 type LabelerReconciler struct {
 	client.Client
-	Scheme *runtime.Scheme
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+
+	// TargetKinds is the allow-list of the kinds Labelers can target. It
+	// defaults to DefaultTargetKinds. The operator needs to be allowed to get,
+	// list, watch and patch objects of every kind in it, and does not start
+	// otherwise.
+	TargetKinds []nulllabelerv1.TargetKind
+
+	// targets are the kinds of the allow-list, as found in the API.
+	targets map[nulllabelerv1.TargetKind]targetType
+
+	// syncStates let Labelers sync the objects that changed only.
+	syncStates syncStates
 }
 
 //+kubebuilder:rbac:groups=nulllabeler.thenullchannel.dev,resources=labelers,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=nulllabeler.thenullchannel.dev,resources=labelers/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=nulllabeler.thenullchannel.dev,resources=labelers/finalizers,verbs=update
+//+kubebuilder:rbac:groups=nulllabeler.thenullchannel.dev,resources=clusterlabelers,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=nulllabeler.thenullchannel.dev,resources=clusterlabelers/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=nulllabeler.thenullchannel.dev,resources=clusterlabelers/finalizers,verbs=update
+//+kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
+//+kubebuilder:rbac:groups=authorization.k8s.io,resources=selfsubjectaccessreviews,verbs=create
+// The rules below cover DefaultTargetKinds. Kinds added to the allow-list need
+// rules of their own, which the operator checks for as it starts.
 //+kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch;create;patch
+//+kubebuilder:rbac:groups=core,resources=services,verbs=get;list;watch;patch
+//+kubebuilder:rbac:groups=core,resources=persistentvolumeclaims,verbs=get;list;watch;patch
+//+kubebuilder:rbac:groups=core,resources=namespaces,verbs=get;list;watch;patch
+//+kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;patch
+//+kubebuilder:rbac:groups=apps,resources=statefulsets,verbs=get;list;watch;patch
+//+kubebuilder:rbac:groups=apps,resources=daemonsets,verbs=get;list;watch;patch
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
-// TODO(user): Modify the Reconcile function to compare the state specified by
-// the Labeler object against the actual cluster state, and then
-// perform operations to make the cluster state reflect the state specified by
-// the user.
+//
+// Every object of the target kinds of the Labeler that matches its selector,
+// in a namespace matching its namespace selector, gets the labels and
+// annotations of the Labeler through server-side apply. Objects that stop
+// matching lose them again, in Mutate mode, which is the default. Mutate
+// Labelers also remove and rename the labels their spec says to, before they
+// apply theirs. Selected objects missing required labels are reported in any
+// mode. The status of the Labeler counts the objects it selects and those it
+// labeled and migrated, lists the objects that could not be labeled, and sums
+// it up in the Ready and Degraded conditions. Deleted Labelers remove
+// everything they applied before they go, but leave migrations done.
+//
+// When several Labelers select the same object and want different values for
+// a key, the one with the highest priority gets it, and the conflict is listed
+// in the status of both. Labels a Labeler applied are never removed or renamed
+// by another one, whatever their priorities: that is listed as a conflict the
+// Labeler applying them won.
+//
+// ClusterLabelers are reconciled the same way, through requests without a
+// namespace. Labelers are confined to their own namespace; ClusterLabelers
+// select across namespaces, and cluster-scoped objects.
+//
+// Events on objects have the Labelers concerned look at those objects only.
+// Changes to the spec of a Labeler, to other Labelers or to namespaces have it
+// go through all of its objects.
 //
 // For more details, check Reconcile and its Result here:
 // - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.8.3/pkg/reconcile
 func (r *LabelerReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
-	_ = log.FromContext(ctx)
+	logger := log.FromContext(ctx)
 
-	labeler := &nulllabelerv1.Labeler{}
+	// Requests without a namespace are for ClusterLabelers.
+	labeler, err := getLabeler(ctx, r.Client, req.NamespacedName)
+	if apierrors.IsNotFound(err) {
+		r.forget(req.NamespacedName)
+	}
+	if err != nil {
+		// Gone already, or an error reading the object - requeue the request.
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	if !labeler.DeletionTimestamp.IsZero() {
+		return r.cleanup(ctx, labeler)
+	}
 
-	if err := r.Client.Get(ctx, req.NamespacedName, labeler); err != nil {
-		// Error reading the object - requeue the request.
+	if !controllerutil.ContainsFinalizer(labeler, cleanupFinalizer) {
+		controllerutil.AddFinalizer(labeler, cleanupFinalizer)
+		if err := writeLabeler(ctx, r.Update, labeler); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	namespaceLabels, err := r.namespaceLabels(ctx)
+	if err != nil {
 		return ctrl.Result{}, err
 	}
 
-	podList := &core.PodList{}
+	self, err := newMatcher(labeler, namespaceLabels)
+	if err == nil {
+		err = self.compile()
+	}
+	if err == nil {
+		err = r.checkTargets(labeler)
+	}
+	if err != nil {
+		// Retrying will not fix the spec.
+		logger.Error(err, "ignoring labeler with invalid spec")
+
+		status := labeler.Status.DeepCopy()
+		status.ObservedGeneration = labeler.Generation
+		message := err.Error()
+		meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+			Type:               nulllabelerv1.ConditionReady,
+			Status:             metav1.ConditionFalse,
+			ObservedGeneration: labeler.Generation,
+			Reason:             reasonInvalidSpec,
+			Message:            message,
+		})
+		meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+			Type:               nulllabelerv1.ConditionDegraded,
+			Status:             metav1.ConditionTrue,
+			ObservedGeneration: labeler.Generation,
+			Reason:             reasonInvalidSpec,
+			Message:            message,
+		})
+		return ctrl.Result{}, r.updateStatus(ctx, labeler, status)
+	}
+
+	rivals, err := liveMatchers(ctx, r.Client, namespaceLabels, labeler)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	want := func(kind nulllabelerv1.TargetKind, obj client.Object) (wanted, error) {
+		if !self.selects(kind, obj) {
+			return wanted{}, nil
+		}
+
+		typed := typedObject(r.Scheme, obj)
+		own, err := self.contender(kind, typed)
+		contenders := []contender{own}
+		for _, rival := range rivals {
+			if rival.selects(kind, obj) {
+				// Rivals report their own broken values.
+				c, _ := rival.contender(kind, typed)
+				contenders = append(contenders, c)
+			}
+		}
+		mine := &resolution{labels: own.labels, annotations: own.annotations}
+		if len(contenders) > 1 {
+			mine = resolve(contenders)[labelerName(labeler)]
+		}
+		// Mutate Labelers leave the labels others apply alone, rather than
+		// remove or rename them.
+		conflicts := mine.conflicts
+		for _, rival := range rivals {
+			if modeOf(rival.labeler) != nulllabelerv1.ModeMutate || !rival.selects(kind, obj) {
+				continue
+			}
+			for key := range mine.labels {
+				if rival.migrates(key) {
+					conflicts = append(conflicts, conflict{field: nulllabelerv1.ConflictLabel, key: key, with: labelerName(rival.labeler), won: true})
+				}
+			}
+		}
+
+		return wanted{
+			labels:      mine.labels,
+			annotations: mine.annotations,
+			conflicts:   conflicts,
+			missing:     self.missing(obj, mine.labels),
+		}, err
+	}
+
+	// Unless the spec of the Labeler, the other Labelers or the namespaces
+	// changed, only the objects that did have to be looked at again.
+	var result syncResult
+	objects, changed := r.changedSince(req.NamespacedName, labeler.Generation)
+	if objects != nil {
+		result = r.syncChanged(ctx, labeler, self, want, objects, changed)
+	} else {
+		result, objects = r.sync(ctx, labeler, self, want)
+	}
+	if len(result.errs) == 0 {
+		r.synced(req.NamespacedName, labeler.Generation, objects)
+	} else {
+		r.resync(req.NamespacedName)
+	}
+
+	status := labeler.Status.DeepCopy()
+	status.ObservedGeneration = labeler.Generation
+	status.Matched = result.matched
+	status.Labeled = result.labeled
+	status.Failed = result.failed
+	status.Failures = result.failures
+	status.Conflicts = result.conflicts.list()
+	status.Violations = result.violations
+	status.Violating = result.violating
+	if labeler.Status.ObservedGeneration != labeler.Generation {
+		status.Migrated = 0
+	}
+	status.Migrated += result.migrated
+	status.PendingMigration = result.pendingMigration
+	ready, degraded := syncConditions(labeler.Generation, result)
+	meta.SetStatusCondition(&status.Conditions, ready)
+	meta.SetStatusCondition(&status.Conditions, degraded)
+	if len(result.errs) == 0 {
+		now := metav1.Now()
+		status.LastSyncTime = &now
+	}
+	if status.Violations != labeler.Status.Violations {
+		if status.Violations > 0 {
+			r.Recorder.Eventf(storedObject(labeler), core.EventTypeWarning, "PolicyViolations", "%d objects miss required labels", status.Violations)
+		} else {
+			r.Recorder.Event(storedObject(labeler), core.EventTypeNormal, "PolicyCompliant", "Every selected object has the required labels")
+		}
+	}
+	if err := r.updateStatus(ctx, labeler, status); err != nil {
+		result.errs = append(result.errs, err)
+	}
+
+	return ctrl.Result{}, utilerrors.NewAggregate(result.errs)
+}
 
-	r.Client.List(context.Background(), podList)
+// syncConditions returns the Ready and Degraded conditions of a Labeler at
+// generation after a sync.
+func syncConditions(generation int64, result syncResult) (ready, degraded metav1.Condition) {
+	ready = metav1.Condition{
+		Type:               nulllabelerv1.ConditionReady,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: generation,
+		Reason:             reasonSynced,
+		Message:            fmt.Sprintf("%d of %d objects labeled", result.labeled, result.matched),
+	}
+	degraded = metav1.Condition{
+		Type:               nulllabelerv1.ConditionDegraded,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: generation,
+		Reason:             reasonSynced,
+		Message:            ready.Message,
+	}
 
-	for _, pod := range podList.Items {
-		if pod.Labels == nil {
-			pod.Labels = map[string]string{"null-labeler": labeler.Spec.Label}
+	if len(result.errs) > 0 || result.failed > 0 {
+		var message string
+		if result.failed > 0 {
+			message = fmt.Sprintf("%d objects could not be labeled or unlabeled", result.failed)
 		} else {
-			pod.Labels["null-labeler"] = labeler.Spec.Label
+			message = utilerrors.NewAggregate(result.errs).Error()
+		}
+		ready.Status, ready.Reason, ready.Message = metav1.ConditionFalse, reasonSyncFailed, message
+		degraded.Status, degraded.Reason, degraded.Message = metav1.ConditionTrue, reasonSyncFailed, message
+	}
+	return ready, degraded
+}
+
+// updateStatus writes status to labeler, unless nothing changed.
+func (r *LabelerReconciler) updateStatus(ctx context.Context, labeler *nulllabelerv1.Labeler, status *nulllabelerv1.LabelerStatus) error {
+	if equality.Semantic.DeepEqual(&labeler.Status, status) {
+		return nil
+	}
+	labeler.Status = *status
+	return writeLabeler(ctx, r.Status().Update, labeler)
+}
+
+// checkTargets makes sure the operator can label every kind labeler targets,
+// and that labeler can: only ClusterLabelers target cluster-scoped kinds.
+func (r *LabelerReconciler) checkTargets(labeler *nulllabelerv1.Labeler) error {
+	for _, kind := range targetsOf(labeler) {
+		t, ok := r.targets[kind]
+		if !ok {
+			return fmt.Errorf("target kind %s is not allowed by the operator", kind)
 		}
-		r.Update(ctx, &pod) //TODO: Care about the error returned here
+		if !t.namespaced && labeler.Namespace != "" {
+			return fmt.Errorf("target kind %s is cluster-scoped, which only ClusterLabelers can target", kind)
+		}
+	}
+	return nil
+}
+
+// targetKinds returns the kinds of the allow-list, in a stable order.
+func (r *LabelerReconciler) targetKinds() []nulllabelerv1.TargetKind {
+	kinds := make([]nulllabelerv1.TargetKind, 0, len(r.targets))
+	for kind := range r.targets {
+		kinds = append(kinds, kind)
 	}
+	sort.Slice(kinds, func(i, j int) bool { return kinds[i] < kinds[j] })
+	return kinds
+}
 
-	// your logic here
+// liveMatchers returns the matchers of the Labelers and ClusterLabelers that
+// are not on their way out, but for skip, which may be nil. Labelers with
+// broken specs do not label anything, so they are left out.
+func liveMatchers(ctx context.Context, c client.Reader, namespaceLabels map[string]labels.Set, skip *nulllabelerv1.Labeler) ([]*matcher, error) {
+	labelers, err := listLabelers(ctx, c)
+	if err != nil {
+		return nil, err
+	}
 
-	return ctrl.Result{}, nil
+	matchers := []*matcher{}
+	for _, labeler := range labelers {
+		if skip != nil && labelerName(labeler) == labelerName(skip) || !labeler.DeletionTimestamp.IsZero() {
+			continue
+		}
+
+		m, err := newMatcher(labeler, namespaceLabels)
+		if err == nil {
+			err = m.compile()
+		}
+		if err == nil {
+			matchers = append(matchers, m)
+		}
+	}
+	return matchers, nil
+}
+
+// conflictCounts counts the objects each conflict shows up on.
+type conflictCounts map[conflict]int32
+
+func (c conflictCounts) add(conflicts []conflict) {
+	for _, k := range conflicts {
+		c[k]++
+	}
+}
+
+// list returns the conflicts for the status of a Labeler, in a stable order.
+func (c conflictCounts) list() []nulllabelerv1.LabelConflict {
+	var conflicts []nulllabelerv1.LabelConflict
+	for k, objects := range c {
+		conflicts = append(conflicts, nulllabelerv1.LabelConflict{
+			Field:   k.field,
+			Key:     k.key,
+			Labeler: k.with,
+			Won:     k.won,
+			Objects: objects,
+		})
+	}
+	sort.Slice(conflicts, func(i, j int) bool {
+		a, b := conflicts[i], conflicts[j]
+		if a.Field != b.Field {
+			return a.Field < b.Field
+		}
+		if a.Key != b.Key {
+			return a.Key < b.Key
+		}
+		return a.Labeler < b.Labeler
+	})
+	return conflicts
+}
+
+// cleanup takes everything labeler applied back off the objects it applied it
+// to, and lets the Labeler go once that worked for every object.
+func (r *LabelerReconciler) cleanup(ctx context.Context, labeler *nulllabelerv1.Labeler) (ctrl.Result, error) {
+	if !controllerutil.ContainsFinalizer(labeler, cleanupFinalizer) {
+		return ctrl.Result{}, nil
+	}
+
+	r.resync(client.ObjectKeyFromObject(labeler))
+	result, _ := r.sync(ctx, labeler, nil, func(nulllabelerv1.TargetKind, client.Object) (wanted, error) {
+		return wanted{}, nil
+	})
+
+	if labeler.Status.Cleanup == nil {
+		labeler.Status.Cleanup = &nulllabelerv1.CleanupStatus{}
+	}
+	labeler.Status.Cleanup.Cleaned += result.applied
+	labeler.Status.Cleanup.Remaining = result.failed
+	labeler.Status.Failures = result.failures
+	if err := writeLabeler(ctx, r.Status().Update, labeler); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	if len(result.errs) > 0 {
+		return ctrl.Result{}, utilerrors.NewAggregate(result.errs)
+	}
+
+	controllerutil.RemoveFinalizer(labeler, cleanupFinalizer)
+	return ctrl.Result{}, writeLabeler(ctx, r.Update, labeler)
+}
+
+// wanted is what a Labeler wants on an object, and what stands in its way.
+type wanted struct {
+	labels, annotations map[string]string
+	// conflicts are those with other Labelers over the object.
+	conflicts []conflict
+	// missing are the required labels the object lacks.
+	missing []string
+}
+
+// wantFunc returns what a Labeler wants on obj, of kind.
+type wantFunc func(kind nulllabelerv1.TargetKind, obj client.Object) (wanted, error)
+
+// syncResult is what a pass over the target objects did.
+type syncResult struct {
+	// matched is the number of objects the Labeler selects.
+	matched int32
+	// labeled is the number of them that carry what the Labeler wants them to.
+	labeled int32
+	// applied is the number of objects that were changed.
+	applied int32
+	// migrated is the number of selected objects labels were removed from or
+	// renamed on.
+	migrated int32
+	// pendingMigration is the number of selected objects that still have
+	// labels to remove or rename.
+	pendingMigration int32
+	// failed is the number of objects that could not be changed, or not be
+	// given every label and annotation.
+	failed int32
+	// failures are the first maxReportedFailures of them.
+	failures []nulllabelerv1.LabelFailure
+	// conflicts are those with other Labelers over the selected objects, with
+	// the number of objects each shows up on.
+	conflicts conflictCounts
+	// violations is the number of selected objects missing required labels.
+	violations int32
+	// violating are the first maxReportedFailures of them.
+	violating []nulllabelerv1.LabelViolation
+	// errs are what is worth retrying for.
+	errs []error
+}
+
+// add adds what other did to r.
+func (r *syncResult) add(other syncResult) {
+	r.matched += other.matched
+	r.labeled += other.labeled
+	r.applied += other.applied
+	r.migrated += other.migrated
+	r.pendingMigration += other.pendingMigration
+	r.failed += other.failed
+	for _, failure := range other.failures {
+		if len(r.failures) < maxReportedFailures {
+			r.failures = append(r.failures, failure)
+		}
+	}
+	if len(other.conflicts) > 0 && r.conflicts == nil {
+		r.conflicts = conflictCounts{}
+	}
+	for k, objects := range other.conflicts {
+		r.conflicts[k] += objects
+	}
+	r.violations += other.violations
+	for _, violation := range other.violating {
+		if len(r.violating) < maxReportedFailures {
+			r.violating = append(r.violating, violation)
+		}
+	}
+	r.errs = append(r.errs, other.errs...)
+}
+
+// fail records that obj, of kind, could not be labeled as wanted.
+func (r *syncResult) fail(kind nulllabelerv1.TargetKind, obj client.Object, err error) {
+	r.failed++
+	if len(r.failures) < maxReportedFailures {
+		r.failures = append(r.failures, nulllabelerv1.LabelFailure{
+			Kind:      string(kind),
+			Namespace: obj.GetNamespace(),
+			Name:      obj.GetName(),
+			Message:   err.Error(),
+		})
+	}
+}
+
+// sync does the removals and renames of labeler on the objects self selects,
+// then applies to them, and to those labeler labeled before, the labels and
+// annotations want returns for them, as the field manager of labeler. Objects
+// labeler labeled before are looked at whether their kind is targeted or not,
+// as objects that are no longer selected have to lose their labels. self is
+// nil while labeler is being cleaned up. Next to the result of the whole pass,
+// sync returns that of every object, for syncChanged to start from.
+//
+// When want fails for an object, what it did return is applied all the same,
+// and the object is reported as failed. Retrying would not help it, so it does
+// not end up in the errors of the result.
+func (r *LabelerReconciler) sync(ctx context.Context, labeler *nulllabelerv1.Labeler, self *matcher,
+	want wantFunc) (syncResult, syncedObjects) {
+	manager := fieldManager(labeler)
+
+	result := syncResult{}
+	objects := syncedObjects{}
+	for _, kind := range r.targetKinds() {
+		objs, err := r.candidates(ctx, kind, manager, self)
+		if err != nil {
+			result.errs = append(result.errs, err)
+			continue
+		}
+
+		for _, obj := range objs {
+			objResult, found := r.syncObject(ctx, labeler, self, want, kind, obj)
+			if !found {
+				continue
+			}
+			objects.set(objectRef{kind: kind, key: client.ObjectKeyFromObject(obj)}, objResult)
+			result.add(objResult)
+		}
+	}
+
+	return result, objects
+}
+
+// syncChanged is sync for the objects in changed only, on top of objects, the
+// results of the last sync of labeler, which it updates. The result counts
+// every object all the same, but for what was applied, migrated and failed in
+// the pass.
+func (r *LabelerReconciler) syncChanged(ctx context.Context, labeler *nulllabelerv1.Labeler, self *matcher,
+	want wantFunc, objects syncedObjects, changed []objectRef) syncResult {
+	pass := syncResult{}
+	for _, ref := range changed {
+		t, ok := r.targets[ref.kind]
+		if !ok {
+			continue
+		}
+
+		obj := t.newObject()
+		if err := r.Client.Get(ctx, ref.key, obj); err != nil {
+			if !apierrors.IsNotFound(err) {
+				pass.errs = append(pass.errs, err)
+			}
+			delete(objects, ref)
+			continue
+		}
+
+		objResult, found := r.syncObject(ctx, labeler, self, want, ref.kind, obj)
+		if !found {
+			delete(objects, ref)
+			continue
+		}
+		objects.set(ref, objResult)
+		pass.applied += objResult.applied
+		pass.migrated += objResult.migrated
+		pass.errs = append(pass.errs, objResult.errs...)
+	}
+
+	result := objects.total()
+	result.applied, result.migrated, result.errs = pass.applied, pass.migrated, pass.errs
+	return result
+}
+
+// syncObject is sync for obj, of kind. It reports whether obj was still there.
+func (r *LabelerReconciler) syncObject(ctx context.Context, labeler *nulllabelerv1.Labeler, self *matcher,
+	want wantFunc, kind nulllabelerv1.TargetKind, obj client.Object) (result syncResult, found bool) {
+	manager := fieldManager(labeler)
+	// Labelers that do not mutate leave what they applied before alone.
+	readOnly := self != nil && modeOf(labeler) != nulllabelerv1.ModeMutate
+	selected := self != nil && self.selects(kind, obj)
+
+	var migrateErr error
+	var migrateConflicts []conflict
+	if selected {
+		labels, changed, conflicts := self.migrate(obj)
+		if !readOnly {
+			migrateConflicts = conflicts
+		}
+		if changed {
+			if !readOnly {
+				migrateErr = migrateLabels(ctx, r.Client, obj, labels)
+			}
+			switch {
+			case readOnly:
+				result.pendingMigration++
+			case migrateErr == nil:
+				result.migrated++
+			case apierrors.IsNotFound(migrateErr):
+				return syncResult{}, false
+			default:
+				result.pendingMigration++
+				result.errs = append(result.errs, fmt.Errorf("migrating labels of %s %s/%s: %w", kind, obj.GetNamespace(), obj.GetName(), migrateErr))
+			}
+		}
+	}
+
+	w, wantErr := want(kind, obj)
+
+	var applyErr error
+	if migrateErr == nil && !readOnly && !upToDate(obj, manager, w.labels, w.annotations) {
+		applyErr = applyMetadata(ctx, r.Client, r.targets[kind].gvk, client.ObjectKeyFromObject(obj), manager, w.labels, w.annotations)
+		switch {
+		case applyErr == nil:
+			result.applied++
+		case apierrors.IsNotFound(applyErr):
+			return syncResult{}, false
+		default:
+			result.errs = append(result.errs, fmt.Errorf("labeling %s %s/%s: %w", kind, obj.GetNamespace(), obj.GetName(), applyErr))
+		}
+	}
+
+	if selected {
+		result.matched++
+		if len(w.conflicts) > 0 || len(migrateConflicts) > 0 {
+			result.conflicts = conflictCounts{}
+			result.conflicts.add(w.conflicts)
+			result.conflicts.add(migrateConflicts)
+		}
+		if len(w.missing) > 0 {
+			result.violations++
+			result.violating = []nulllabelerv1.LabelViolation{{
+				Kind:      string(kind),
+				Namespace: obj.GetNamespace(),
+				Name:      obj.GetName(),
+				Missing:   w.missing,
+			}}
+		}
+	}
+
+	switch {
+	case migrateErr != nil:
+		result.fail(kind, obj, migrateErr)
+	case applyErr != nil:
+		result.fail(kind, obj, applyErr)
+	case wantErr != nil:
+		result.fail(kind, obj, wantErr)
+	case selected:
+		result.labeled++
+	}
+	return result, true
+}
+
+// candidates returns the objects of kind self selects, and those manager
+// labeled before, ordered by namespace and name. self may be nil.
+func (r *LabelerReconciler) candidates(ctx context.Context, kind nulllabelerv1.TargetKind, manager string, self *matcher) ([]client.Object, error) {
+	t := r.targets[kind]
+
+	opts := [][]client.ListOption{{client.MatchingFields{labelManagersField: manager}}}
+	if self != nil && self.targets[kind] {
+		if self.everyNamespace || !t.namespaced {
+			opts = append(opts, []client.ListOption{client.MatchingLabelsSelector{Selector: self.selector}})
+		} else {
+			for namespace := range self.namespaces {
+				opts = append(opts, []client.ListOption{client.InNamespace(namespace), client.MatchingLabelsSelector{Selector: self.selector}})
+			}
+		}
+	}
+
+	seen := map[client.ObjectKey]bool{}
+	var objs []client.Object
+	for _, listOpts := range opts {
+		list := t.newList()
+		if err := r.Client.List(ctx, list, listOpts...); err != nil {
+			return nil, err
+		}
+
+		items, err := meta.ExtractList(list)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, item := range items {
+			obj := item.(client.Object)
+			if key := client.ObjectKeyFromObject(obj); !seen[key] {
+				seen[key] = true
+				objs = append(objs, obj)
+			}
+		}
+	}
+
+	sort.Slice(objs, func(i, j int) bool {
+		if objs[i].GetNamespace() != objs[j].GetNamespace() {
+			return objs[i].GetNamespace() < objs[j].GetNamespace()
+		}
+		return objs[i].GetName() < objs[j].GetName()
+	})
+	return objs, nil
+}
+
+// namespaceLabels returns the labels of every namespace, by name.
+func (r *LabelerReconciler) namespaceLabels(ctx context.Context) (map[string]labels.Set, error) {
+	namespaceList := &core.NamespaceList{}
+	if err := r.Client.List(ctx, namespaceList); err != nil {
+		return nil, err
+	}
+
+	namespaces := map[string]labels.Set{}
+	for _, namespace := range namespaceList.Items {
+		namespaces[namespace.Name] = labels.Set(namespace.Labels)
+	}
+	return namespaces, nil
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *LabelerReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).
-		For(&nulllabelerv1.Labeler{}).
+	kinds := r.TargetKinds
+	if len(kinds) == 0 {
+		kinds = DefaultTargetKinds
+	}
+	targets, err := resolveTargets(mgr.GetRESTMapper(), kinds)
+	if err != nil {
+		return err
+	}
+	r.targets = targets
+	if err := r.checkAccess(context.Background()); err != nil {
+		return err
+	}
+
+	if err := indexFields(context.Background(), mgr.GetFieldIndexer(), r.targets); err != nil {
+		return err
+	}
+
+	b := ctrl.NewControllerManagedBy(mgr).
+		// Status updates do not change what a Labeler labels.
+		For(&nulllabelerv1.Labeler{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Watches(
+			&source.Kind{Type: &nulllabelerv1.ClusterLabeler{}},
+			&handler.EnqueueRequestForObject{},
+			builder.WithPredicates(predicate.GenerationChangedPredicate{}),
+		).
+		// A Labeler changing its spec can change what every other Labeler wins.
+		Watches(
+			&source.Kind{Type: &nulllabelerv1.Labeler{}},
+			handler.EnqueueRequestsFromMapFunc(r.GetAll),
+			builder.WithPredicates(predicate.GenerationChangedPredicate{}),
+		).
 		Watches(
-			&source.Kind{Type: &core.Pod{}},
+			&source.Kind{Type: &nulllabelerv1.ClusterLabeler{}},
 			handler.EnqueueRequestsFromMapFunc(r.GetAll),
+			builder.WithPredicates(predicate.GenerationChangedPredicate{}),
 		).
-		// Uncomment the following line adding a pointer to an instance of the controlled resource as an argument
-		// For().
-		Complete(r)
+		Watches(
+			&source.Kind{Type: &core.Namespace{}},
+			handler.EnqueueRequestsFromMapFunc(r.labelersForNamespace),
+		)
+
+	for _, kind := range r.targetKinds() {
+		b = b.Watches(
+			&source.Kind{Type: r.targets[kind].newObject()},
+			handler.EnqueueRequestsFromMapFunc(r.labelersFor(kind)),
+		)
+	}
+
+	return b.Complete(r)
 }
 
 func (r *LabelerReconciler) GetAll(o client.Object) []ctrl.Request {
 	result := []ctrl.Request{}
 
-	labelerList := nulllabelerv1.LabelerList{}
-	r.Client.List(context.Background(), &labelerList)
+	labelers, _ := listLabelers(context.Background(), r.Client)
 
-	for _, labeler := range labelerList.Items {
-		result = append(result, ctrl.Request{NamespacedName: client.ObjectKey{Namespace: labeler.Namespace, Name: labeler.Name}})
+	for _, labeler := range labelers {
+		key := client.ObjectKey{Namespace: labeler.Namespace, Name: labeler.Name}
+		r.resync(key)
+		result = append(result, ctrl.Request{NamespacedName: key})
 	}
 
 	return result
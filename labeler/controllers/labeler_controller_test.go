@@ -0,0 +1,569 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	core "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	nulllabelerv1 "github.com/null-channel/stupid-kube-operators/labeler/api/v1"
+)
+
+// applyClient is a fake client that does server-side apply of labels and
+// annotations, which is all the reconciler applies, and looks objects up by
+// the field managers of Labelers. The fake client does neither.
+type applyClient struct {
+	client.Client
+	// failPatch fails patches of the objects of the given names.
+	failPatch map[string]error
+}
+
+func (c *applyClient) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	if err := c.Client.List(ctx, list, opts...); err != nil {
+		return err
+	}
+
+	listOpts := client.ListOptions{}
+	listOpts.ApplyOptions(opts)
+	if listOpts.FieldSelector == nil {
+		return nil
+	}
+	requirement := listOpts.FieldSelector.Requirements()[0]
+	if requirement.Field != labelManagersField {
+		return nil
+	}
+
+	items, err := meta.ExtractList(list)
+	if err != nil {
+		return err
+	}
+	var managed []runtime.Object
+	for _, item := range items {
+		for _, manager := range labelManagers(item.(client.Object)) {
+			if manager == requirement.Value {
+				managed = append(managed, item)
+				break
+			}
+		}
+	}
+	return meta.SetList(list, managed)
+}
+
+func (c *applyClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	if err, ok := c.failPatch[obj.GetName()]; ok {
+		return err
+	}
+	if patch != client.Apply {
+		return c.Client.Patch(ctx, obj, patch, opts...)
+	}
+
+	patchOpts := client.PatchOptions{}
+	patchOpts.ApplyOptions(opts)
+	manager := patchOpts.FieldManager
+
+	// The tracker of the fake client keeps objects typed.
+	applied := obj.(*unstructured.Unstructured)
+	typed, err := c.Scheme().New(applied.GroupVersionKind())
+	if err != nil {
+		return err
+	}
+	current := typed.(client.Object)
+	if err := c.Client.Get(ctx, client.ObjectKeyFromObject(applied), current); err != nil {
+		return err
+	}
+
+	ownedLabels, ownedAnnotations := ownedKeys(current, manager)
+	current.SetLabels(applyKeys(current.GetLabels(), ownedLabels, applied.GetLabels()))
+	current.SetAnnotations(applyKeys(current.GetAnnotations(), ownedAnnotations, applied.GetAnnotations()))
+
+	var entries []metav1.ManagedFieldsEntry
+	for _, entry := range current.GetManagedFields() {
+		if entry.Manager != manager {
+			entries = append(entries, entry)
+		}
+	}
+	if len(applied.GetLabels()) > 0 || len(applied.GetAnnotations()) > 0 {
+		entry, err := appliedFieldsEntry(manager, applied.GetAPIVersion(), applied.GetLabels(), applied.GetAnnotations(), metav1.Now())
+		if err != nil {
+			return err
+		}
+		entries = append(entries, entry)
+	}
+	current.SetManagedFields(entries)
+
+	return c.Client.Update(ctx, current)
+}
+
+// applyKeys returns have with the keys in owned that are not in want any
+// more removed, and want set.
+func applyKeys(have map[string]string, owned map[string]bool, want map[string]string) map[string]string {
+	result := map[string]string{}
+	for key, value := range have {
+		if _, ok := want[key]; !owned[key] || ok {
+			result[key] = value
+		}
+	}
+	for key, value := range want {
+		result[key] = value
+	}
+	return result
+}
+
+// newTestReconciler returns a reconciler for pods and namespaces, on an
+// applyClient holding objs.
+func newTestReconciler(t testing.TB, objs ...client.Object) (*LabelerReconciler, *applyClient) {
+	s := runtime.NewScheme()
+	if err := scheme.AddToScheme(s); err != nil {
+		t.Fatal(err)
+	}
+	if err := nulllabelerv1.AddToScheme(s); err != nil {
+		t.Fatal(err)
+	}
+
+	mapper := meta.NewDefaultRESTMapper([]schema.GroupVersion{core.SchemeGroupVersion})
+	mapper.Add(core.SchemeGroupVersion.WithKind("Pod"), meta.RESTScopeNamespace)
+	mapper.Add(core.SchemeGroupVersion.WithKind("Namespace"), meta.RESTScopeRoot)
+	targets, err := resolveTargets(mapper, []nulllabelerv1.TargetKind{nulllabelerv1.TargetPod, nulllabelerv1.TargetNamespace})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := &applyClient{Client: fake.NewClientBuilder().WithScheme(s).WithObjects(objs...).Build()}
+	r := &LabelerReconciler{Client: c, Scheme: s, Recorder: record.NewFakeRecorder(10), targets: targets}
+	return r, c
+}
+
+// testPod returns a pod in namespace with labels.
+func testPod(namespace, name string, labels map[string]string) *core.Pod {
+	return &core.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, Labels: labels}}
+}
+
+// reconcileLabeler reconciles the Labeler, or ClusterLabeler, at key, and
+// returns it as it is after.
+func reconcileLabeler(t *testing.T, r *LabelerReconciler, key client.ObjectKey) (*nulllabelerv1.Labeler, error) {
+	t.Helper()
+	_, reconcileErr := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: key})
+	labeler, err := getLabeler(context.Background(), r.Client, key)
+	if err != nil && !apierrors.IsNotFound(err) {
+		t.Fatal(err)
+	}
+	return labeler, reconcileErr
+}
+
+// podLabels returns the labels of the pod at key.
+func podLabels(t *testing.T, c client.Client, namespace, name string) map[string]string {
+	t.Helper()
+	pod := &core.Pod{}
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: namespace, Name: name}, pod); err != nil {
+		t.Fatal(err)
+	}
+	return pod.Labels
+}
+
+func TestReconcileAppliesLabels(t *testing.T) {
+	labeler := &nulllabelerv1.Labeler{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "team"},
+		Spec: nulllabelerv1.LabelerSpec{
+			Labels:   map[string]string{"team": "web"},
+			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+		},
+	}
+	r, c := newTestReconciler(t,
+		&core.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}},
+		labeler,
+		testPod("default", "web-1", map[string]string{"app": "web", "owner": "ops"}),
+		testPod("default", "db-1", map[string]string{"app": "db"}),
+	)
+	key := client.ObjectKeyFromObject(labeler)
+
+	if _, err := reconcileLabeler(t, r, key); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := podLabels(t, c, "default", "web-1"), map[string]string{"app": "web", "owner": "ops", "team": "web"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("selected pod labels = %v, want %v", got, want)
+	}
+	if got, want := podLabels(t, c, "default", "db-1"), map[string]string{"app": "db"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("other pod labels = %v, want %v", got, want)
+	}
+
+	// The Labeler moves on to the other pod.
+	current, err := getLabeler(context.Background(), c, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	current.Spec.Selector = &metav1.LabelSelector{MatchLabels: map[string]string{"app": "db"}}
+	// The fake client does not bump the generation, as the API server does.
+	current.Generation++
+	if err := c.Update(context.Background(), current); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reconcileLabeler(t, r, key); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := podLabels(t, c, "default", "web-1"), map[string]string{"app": "web", "owner": "ops"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("deselected pod labels = %v, want %v", got, want)
+	}
+	if got, want := podLabels(t, c, "default", "db-1"), map[string]string{"app": "db", "team": "web"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("newly selected pod labels = %v, want %v", got, want)
+	}
+}
+
+func TestReconcileReportsFailures(t *testing.T) {
+	labeler := &nulllabelerv1.Labeler{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "team"},
+		Spec:       nulllabelerv1.LabelerSpec{Labels: map[string]string{"team": "web"}},
+	}
+	r, c := newTestReconciler(t,
+		&core.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}},
+		labeler,
+		testPod("default", "web-1", nil),
+		testPod("default", "locked", nil),
+	)
+	c.failPatch = map[string]error{"locked": apierrors.NewForbidden(schema.GroupResource{Resource: "pods"}, "locked", nil)}
+
+	got, err := reconcileLabeler(t, r, client.ObjectKeyFromObject(labeler))
+	if err == nil {
+		t.Error("Reconcile() did not fail, want the error to retry for")
+	}
+	if podLabels(t, c, "default", "web-1")["team"] != "web" {
+		t.Error("failure on one pod held back the others")
+	}
+	if got.Status.Failed != 1 || len(got.Status.Failures) != 1 || got.Status.Failures[0].Name != "locked" {
+		t.Errorf("status = %+v, want the locked pod failed", got.Status)
+	}
+}
+
+func TestReconcileCleansUpDeletedLabeler(t *testing.T) {
+	tests := []struct {
+		name          string
+		failPatch     map[string]error
+		wantErr       bool
+		wantFinalizer bool
+		wantCleanup   nulllabelerv1.CleanupStatus
+	}{
+		{
+			name:        "takes its labels back",
+			wantCleanup: nulllabelerv1.CleanupStatus{Cleaned: 2},
+		},
+		{
+			name:          "waits for every object",
+			failPatch:     map[string]error{"web-2": apierrors.NewServiceUnavailable("try again")},
+			wantErr:       true,
+			wantFinalizer: true,
+			wantCleanup:   nulllabelerv1.CleanupStatus{Cleaned: 1, Remaining: 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			labeler := &nulllabelerv1.Labeler{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "team"},
+				Spec:       nulllabelerv1.LabelerSpec{Labels: map[string]string{"team": "web"}},
+			}
+			r, c := newTestReconciler(t,
+				&core.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}},
+				labeler,
+				testPod("default", "web-1", map[string]string{"owner": "ops"}),
+				testPod("default", "web-2", nil),
+			)
+			key := client.ObjectKeyFromObject(labeler)
+			if _, err := reconcileLabeler(t, r, key); err != nil {
+				t.Fatal(err)
+			}
+
+			// The fake client deletes right away, finalizers or not.
+			deleting, err := getLabeler(ctx, c, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			now := metav1.Now()
+			deleting.DeletionTimestamp = &now
+			if err := c.Update(ctx, deleting); err != nil {
+				t.Fatal(err)
+			}
+			c.failPatch = tt.failPatch
+
+			got, err := reconcileLabeler(t, r, key)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Reconcile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got, want := podLabels(t, c, "default", "web-1"), map[string]string{"owner": "ops"}; !reflect.DeepEqual(got, want) {
+				t.Errorf("pod labels = %v, want %v", got, want)
+			}
+			if has := controllerutil.ContainsFinalizer(got, cleanupFinalizer); has != tt.wantFinalizer {
+				t.Errorf("finalizer still there: %v, want %v", has, tt.wantFinalizer)
+			}
+			if got.Status.Cleanup == nil || *got.Status.Cleanup != tt.wantCleanup {
+				t.Errorf("cleanup = %+v, want %+v", got.Status.Cleanup, tt.wantCleanup)
+			}
+		})
+	}
+}
+
+func TestSyncConditions(t *testing.T) {
+	tests := []struct {
+		name         string
+		result       syncResult
+		wantReady    metav1.ConditionStatus
+		wantDegraded metav1.ConditionStatus
+		wantMessage  string
+	}{
+		{
+			name:         "everything labeled",
+			result:       syncResult{matched: 3, labeled: 3},
+			wantReady:    metav1.ConditionTrue,
+			wantDegraded: metav1.ConditionFalse,
+			wantMessage:  "3 of 3 objects labeled",
+		},
+		{
+			name:         "objects failed",
+			result:       syncResult{matched: 3, labeled: 2, failed: 1},
+			wantReady:    metav1.ConditionFalse,
+			wantDegraded: metav1.ConditionTrue,
+			wantMessage:  "1 objects could not be labeled or unlabeled",
+		},
+		{
+			name:         "listing failed",
+			result:       syncResult{errs: []error{apierrors.NewServiceUnavailable("try again")}},
+			wantReady:    metav1.ConditionFalse,
+			wantDegraded: metav1.ConditionTrue,
+			wantMessage:  "try again",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ready, degraded := syncConditions(4, tt.result)
+			if ready.Status != tt.wantReady || degraded.Status != tt.wantDegraded {
+				t.Errorf("Ready %s, Degraded %s, want %s, %s", ready.Status, degraded.Status, tt.wantReady, tt.wantDegraded)
+			}
+			if ready.Message != tt.wantMessage || degraded.Message != tt.wantMessage {
+				t.Errorf("messages %q and %q, want %q", ready.Message, degraded.Message, tt.wantMessage)
+			}
+			if ready.ObservedGeneration != 4 || degraded.ObservedGeneration != 4 {
+				t.Errorf("conditions observed generations %d and %d, want 4", ready.ObservedGeneration, degraded.ObservedGeneration)
+			}
+		})
+	}
+}
+
+func TestReconcileReportsStatus(t *testing.T) {
+	labeler := &nulllabelerv1.Labeler{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "team", Generation: 2},
+		Spec: nulllabelerv1.LabelerSpec{
+			Labels:   map[string]string{"team": "web"},
+			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+		},
+	}
+	r, _ := newTestReconciler(t,
+		&core.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}},
+		labeler,
+		testPod("default", "web-1", map[string]string{"app": "web"}),
+		testPod("default", "web-2", map[string]string{"app": "web"}),
+		testPod("default", "db-1", map[string]string{"app": "db"}),
+	)
+
+	got, err := reconcileLabeler(t, r, client.ObjectKeyFromObject(labeler))
+	if err != nil {
+		t.Fatal(err)
+	}
+	status := got.Status
+	if status.Matched != 2 || status.Labeled != 2 || status.Failed != 0 {
+		t.Errorf("matched %d, labeled %d, failed %d, want 2, 2, 0", status.Matched, status.Labeled, status.Failed)
+	}
+	if status.ObservedGeneration != got.Generation || status.LastSyncTime == nil {
+		t.Errorf("observed generation %d of %d, last sync %v, want the generation and a sync time",
+			status.ObservedGeneration, got.Generation, status.LastSyncTime)
+	}
+	ready := meta.IsStatusConditionTrue(status.Conditions, nulllabelerv1.ConditionReady)
+	if degraded := meta.IsStatusConditionTrue(status.Conditions, nulllabelerv1.ConditionDegraded); !ready || degraded {
+		t.Errorf("conditions = %+v, want Ready and not Degraded", status.Conditions)
+	}
+}
+
+func TestReconcileModes(t *testing.T) {
+	tests := []struct {
+		mode        nulllabelerv1.LabelerMode
+		wantLabels  map[string]string
+		wantMissing []string
+	}{
+		{
+			mode:        nulllabelerv1.ModeAudit,
+			wantLabels:  map[string]string{"app": "web"},
+			wantMissing: []string{"cost-center", "owner"},
+		},
+		{
+			mode:        nulllabelerv1.ModeEnforce,
+			wantLabels:  map[string]string{"app": "web"},
+			wantMissing: []string{"cost-center", "owner"},
+		},
+		{
+			mode:        nulllabelerv1.ModeMutate,
+			wantLabels:  map[string]string{"app": "web", "team": "web", "cost-center": "ops"},
+			wantMissing: []string{"owner"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.mode), func(t *testing.T) {
+			labeler := &nulllabelerv1.Labeler{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "policy"},
+				Spec: nulllabelerv1.LabelerSpec{
+					Mode:   tt.mode,
+					Labels: map[string]string{"team": "web"},
+					Required: []nulllabelerv1.RequiredLabel{
+						{Key: "cost-center", Default: "{{ .Namespace.Labels.team }}"},
+						{Key: "owner"},
+					},
+				},
+			}
+			r, c := newTestReconciler(t,
+				&core.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default", Labels: map[string]string{"team": "ops"}}},
+				labeler,
+				testPod("default", "compliant", map[string]string{"app": "web", "cost-center": "web", "owner": "ops", "team": "web"}),
+				testPod("default", "lacking", map[string]string{"app": "web"}),
+			)
+
+			got, err := reconcileLabeler(t, r, client.ObjectKeyFromObject(labeler))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if labels := podLabels(t, c, "default", "lacking"); !reflect.DeepEqual(labels, tt.wantLabels) {
+				t.Errorf("labels = %v, want %v", labels, tt.wantLabels)
+			}
+			wantViolating := []nulllabelerv1.LabelViolation{{Kind: "Pod", Namespace: "default", Name: "lacking", Missing: tt.wantMissing}}
+			if got.Status.Violations != 1 || !reflect.DeepEqual(got.Status.Violating, wantViolating) {
+				t.Errorf("violations %d, %+v, want %+v", got.Status.Violations, got.Status.Violating, wantViolating)
+			}
+
+			select {
+			case event := <-r.Recorder.(*record.FakeRecorder).Events:
+				if !strings.HasPrefix(event, "Warning PolicyViolations") {
+					t.Errorf("event %q, want a PolicyViolations warning", event)
+				}
+			default:
+				t.Error("no event for the violations")
+			}
+		})
+	}
+}
+
+func TestReconcileScopes(t *testing.T) {
+	ctx := context.Background()
+	tenant := &nulllabelerv1.Labeler{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "team-a", Name: "tenant"},
+		Spec: nulllabelerv1.LabelerSpec{
+			Labels: map[string]string{"tenant": "a"},
+			// Taking in every namespace does not take a Labeler out of its own.
+			NamespaceSelector: &metav1.LabelSelector{},
+		},
+	}
+	cluster := &nulllabelerv1.ClusterLabeler{
+		ObjectMeta: metav1.ObjectMeta{Name: "prod"},
+		Spec: nulllabelerv1.LabelerSpec{
+			Labels:            map[string]string{"env": "prod"},
+			NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"env": "prod"}},
+			Targets:           []nulllabelerv1.TargetKind{nulllabelerv1.TargetPod, nulllabelerv1.TargetNamespace},
+		},
+	}
+	r, c := newTestReconciler(t,
+		&core.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a"}},
+		&core.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-b", Labels: map[string]string{"env": "prod"}}},
+		tenant, cluster,
+		testPod("team-a", "web-1", nil),
+		testPod("team-b", "web-1", nil),
+	)
+
+	if _, err := reconcileLabeler(t, r, client.ObjectKeyFromObject(tenant)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reconcileLabeler(t, r, client.ObjectKeyFromObject(cluster)); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := podLabels(t, c, "team-a", "web-1"), map[string]string{"tenant": "a"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("pod in the namespace of the Labeler has labels %v, want %v", got, want)
+	}
+	if got, want := podLabels(t, c, "team-b", "web-1"), map[string]string{"env": "prod"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("pod in another namespace has labels %v, want %v", got, want)
+	}
+	// ClusterLabelers label cluster-scoped objects whatever their namespace selector.
+	for _, name := range []string{"team-a", "team-b"} {
+		namespace := &core.Namespace{}
+		if err := c.Get(ctx, client.ObjectKey{Name: name}, namespace); err != nil {
+			t.Fatal(err)
+		}
+		if namespace.Labels["env"] != "prod" {
+			t.Errorf("namespace %s has labels %v, want it labeled by the ClusterLabeler", name, namespace.Labels)
+		}
+	}
+}
+
+func TestCheckTargets(t *testing.T) {
+	tests := []struct {
+		name      string
+		namespace string
+		targets   []nulllabelerv1.TargetKind
+		wantErr   string
+	}{
+		{name: "Labeler targeting pods", namespace: "default", targets: []nulllabelerv1.TargetKind{nulllabelerv1.TargetPod}},
+		{name: "ClusterLabeler targeting namespaces", targets: []nulllabelerv1.TargetKind{nulllabelerv1.TargetNamespace}},
+		{
+			name:      "Labeler targeting namespaces",
+			namespace: "default",
+			targets:   []nulllabelerv1.TargetKind{nulllabelerv1.TargetNamespace},
+			wantErr:   "only ClusterLabelers can target",
+		},
+		{
+			name:      "kind off the allow-list",
+			namespace: "default",
+			targets:   []nulllabelerv1.TargetKind{nulllabelerv1.TargetService},
+			wantErr:   "not allowed by the operator",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, _ := newTestReconciler(t)
+			labeler := &nulllabelerv1.Labeler{
+				ObjectMeta: metav1.ObjectMeta{Namespace: tt.namespace, Name: "labeler"},
+				Spec:       nulllabelerv1.LabelerSpec{Targets: tt.targets},
+			}
+			err := r.checkTargets(labeler)
+			if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("checkTargets() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	nulllabelerv1 "github.com/null-channel/stupid-kube-operators/labeler/api/v1"
+)
+
+// ClusterLabelers are handled as Labelers without a namespace: they have the
+// same spec and status, and only differ in what they can select. The helpers
+// below read and write both kinds that way.
+
+// asLabeler returns cl as a Labeler without a namespace.
+func asLabeler(cl *nulllabelerv1.ClusterLabeler) *nulllabelerv1.Labeler {
+	return &nulllabelerv1.Labeler{ObjectMeta: cl.ObjectMeta, Spec: cl.Spec, Status: cl.Status}
+}
+
+// storedObject returns labeler as what it is stored as: itself, or the
+// ClusterLabeler it stands for.
+func storedObject(labeler *nulllabelerv1.Labeler) client.Object {
+	if labeler.Namespace != "" {
+		return labeler
+	}
+	return &nulllabelerv1.ClusterLabeler{ObjectMeta: labeler.ObjectMeta, Spec: labeler.Spec, Status: labeler.Status}
+}
+
+// getLabeler reads the Labeler key names, or the ClusterLabeler when key has
+// no namespace.
+func getLabeler(ctx context.Context, c client.Reader, key client.ObjectKey) (*nulllabelerv1.Labeler, error) {
+	if key.Namespace != "" {
+		labeler := &nulllabelerv1.Labeler{}
+		return labeler, c.Get(ctx, key, labeler)
+	}
+
+	cl := &nulllabelerv1.ClusterLabeler{}
+	if err := c.Get(ctx, key, cl); err != nil {
+		return nil, err
+	}
+	return asLabeler(cl), nil
+}
+
+// listLabelers lists the Labelers and the ClusterLabelers matching opts.
+func listLabelers(ctx context.Context, c client.Reader, opts ...client.ListOption) ([]*nulllabelerv1.Labeler, error) {
+	labelerList := &nulllabelerv1.LabelerList{}
+	if err := c.List(ctx, labelerList, opts...); err != nil {
+		return nil, err
+	}
+	clusterLabelerList := &nulllabelerv1.ClusterLabelerList{}
+	if err := c.List(ctx, clusterLabelerList, opts...); err != nil {
+		return nil, err
+	}
+
+	labelers := make([]*nulllabelerv1.Labeler, 0, len(labelerList.Items)+len(clusterLabelerList.Items))
+	for i := range labelerList.Items {
+		labelers = append(labelers, &labelerList.Items[i])
+	}
+	for i := range clusterLabelerList.Items {
+		labelers = append(labelers, asLabeler(&clusterLabelerList.Items[i]))
+	}
+	return labelers, nil
+}
+
+// writeLabeler writes labeler with update, which is the Update of a client or
+// of its status writer, as what it is stored as. labeler is refreshed from
+// the result.
+func writeLabeler(ctx context.Context, update func(context.Context, client.Object, ...client.UpdateOption) error,
+	labeler *nulllabelerv1.Labeler) error {
+	if labeler.Namespace != "" {
+		return update(ctx, labeler)
+	}
+
+	cl := storedObject(labeler).(*nulllabelerv1.ClusterLabeler)
+	err := update(ctx, cl)
+	labeler.ObjectMeta, labeler.Spec, labeler.Status = cl.ObjectMeta, cl.Spec, cl.Status
+	return err
+}
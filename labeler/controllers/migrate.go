@@ -0,0 +1,147 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	nulllabelerv1 "github.com/null-channel/stupid-kube-operators/labeler/api/v1"
+)
+
+// checkMigrations makes sure the removals and renames of labeler are label
+// keys, and do not fight each other or the labels the Labeler sets or
+// requires, which would have it undo its own work on every sync.
+func checkMigrations(labeler *nulllabelerv1.Labeler) error {
+	kept := map[string]string{}
+	for key := range labeler.Spec.Labels {
+		kept[key] = "is set by the labeler"
+	}
+	for _, required := range labeler.Spec.Required {
+		kept[required.Key] = "is required"
+	}
+
+	removed := map[string]bool{}
+	for _, key := range labeler.Spec.Remove {
+		if problems := validation.IsQualifiedName(key); len(problems) > 0 {
+			return fmt.Errorf("removed label %q: %s", key, strings.Join(problems, "; "))
+		}
+		if why, ok := kept[key]; ok {
+			return fmt.Errorf("removed label %q %s", key, why)
+		}
+		removed[key] = true
+	}
+
+	for from, to := range labeler.Spec.Rename {
+		for _, key := range []string{from, to} {
+			if problems := validation.IsQualifiedName(key); len(problems) > 0 {
+				return fmt.Errorf("renamed label %q: %s", key, strings.Join(problems, "; "))
+			}
+		}
+		if why, ok := kept[from]; ok {
+			return fmt.Errorf("renamed label %q %s", from, why)
+		}
+		if removed[from] || removed[to] {
+			return fmt.Errorf("label %q is both renamed to %q and removed", from, to)
+		}
+		if _, ok := labeler.Spec.Rename[to]; ok {
+			return fmt.Errorf("label %q is renamed to %q, which is renamed in turn", from, to)
+		}
+	}
+	return nil
+}
+
+// migrate returns the labels of obj with the removals and renames of the
+// Labeler done, and whether that changed anything. Renames are done in the
+// order of their keys, so that two of them moving to the same key always
+// agree on which value it gets. Labels other Labelers applied are left alone,
+// as they would only apply them again: they are returned as conflicts the
+// Labeler lost.
+func (m *matcher) migrate(obj client.Object) (map[string]string, bool, []conflict) {
+	current := obj.GetLabels()
+	changed := false
+	migrated := make(map[string]string, len(current))
+	for key, value := range current {
+		migrated[key] = value
+	}
+
+	owners := labelOwners(obj, fieldManager(m.labeler))
+	var conflicts []conflict
+	free := func(key string) bool {
+		for _, owner := range owners[key] {
+			conflicts = append(conflicts, conflict{field: nulllabelerv1.ConflictLabel, key: key, with: labelerOf(owner)})
+		}
+		return len(owners[key]) == 0
+	}
+
+	for _, key := range m.labeler.Spec.Remove {
+		if _, ok := migrated[key]; ok && free(key) {
+			delete(migrated, key)
+			changed = true
+		}
+	}
+
+	froms := make([]string, 0, len(m.labeler.Spec.Rename))
+	for from := range m.labeler.Spec.Rename {
+		froms = append(froms, from)
+	}
+	sort.Strings(froms)
+	for _, from := range froms {
+		value, ok := migrated[from]
+		if !ok || !free(from) {
+			continue
+		}
+		to := m.labeler.Spec.Rename[from]
+		if _, taken := migrated[to]; !taken {
+			migrated[to] = value
+		}
+		delete(migrated, from)
+		changed = true
+	}
+
+	return migrated, changed, conflicts
+}
+
+// migrates reports whether the Labeler removes or renames the label key.
+func (m *matcher) migrates(key string) bool {
+	for _, removed := range m.labeler.Spec.Remove {
+		if removed == key {
+			return true
+		}
+	}
+	_, renamed := m.labeler.Spec.Rename[key]
+	return renamed
+}
+
+// migrateLabels sets labels on obj, with a merge patch that fails if obj
+// changed since it was read, so a renamed label never gets a stale value. The
+// labels are not applied: they are not the Labeler's to take back. obj is
+// left as it was when the patch fails.
+func migrateLabels(ctx context.Context, c client.Client, obj client.Object, labels map[string]string) error {
+	original := obj.DeepCopyObject().(client.Object)
+	obj.SetLabels(labels)
+	if err := c.Patch(ctx, obj, client.MergeFromWithOptions(original, client.MergeFromWithOptimisticLock{})); err != nil {
+		obj.SetLabels(original.GetLabels())
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,269 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	nulllabelerv1 "github.com/null-channel/stupid-kube-operators/labeler/api/v1"
+)
+
+func TestCheckMigrations(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    nulllabelerv1.LabelerSpec
+		wantErr string
+	}{
+		{
+			name: "removals and renames",
+			spec: nulllabelerv1.LabelerSpec{
+				Labels: map[string]string{"team": "web"},
+				Remove: []string{"legacy-team"},
+				Rename: map[string]string{"app": "app.kubernetes.io/name"},
+			},
+		},
+		{
+			name:    "invalid key",
+			spec:    nulllabelerv1.LabelerSpec{Remove: []string{"not a key"}},
+			wantErr: `removed label "not a key"`,
+		},
+		{
+			name:    "removing a label it sets",
+			spec:    nulllabelerv1.LabelerSpec{Labels: map[string]string{"team": "web"}, Remove: []string{"team"}},
+			wantErr: "is set by the labeler",
+		},
+		{
+			name:    "renaming a required label",
+			spec:    nulllabelerv1.LabelerSpec{Required: []nulllabelerv1.RequiredLabel{{Key: "app"}}, Rename: map[string]string{"app": "name"}},
+			wantErr: "is required",
+		},
+		{
+			name:    "renaming to a removed label",
+			spec:    nulllabelerv1.LabelerSpec{Remove: []string{"name"}, Rename: map[string]string{"app": "name"}},
+			wantErr: "both renamed",
+		},
+		{
+			name:    "chained renames",
+			spec:    nulllabelerv1.LabelerSpec{Rename: map[string]string{"a": "b", "b": "c"}},
+			wantErr: "renamed in turn",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkMigrations(&nulllabelerv1.Labeler{Spec: tt.spec})
+			if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("checkMigrations() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	spec := nulllabelerv1.LabelerSpec{
+		Remove: []string{"legacy-team"},
+		Rename: map[string]string{"app": "app.kubernetes.io/name", "name": "app.kubernetes.io/name"},
+	}
+
+	tests := []struct {
+		name          string
+		labels        map[string]string
+		owners        map[string]map[string]string
+		want          map[string]string
+		wantChanged   bool
+		wantConflicts []conflict
+	}{
+		{
+			name:        "removes and renames",
+			labels:      map[string]string{"legacy-team": "web", "app": "shop", "tier": "front"},
+			want:        map[string]string{"app.kubernetes.io/name": "shop", "tier": "front"},
+			wantChanged: true,
+		},
+		{
+			name:        "keeps the value of the new key",
+			labels:      map[string]string{"app": "shop", "app.kubernetes.io/name": "store"},
+			want:        map[string]string{"app.kubernetes.io/name": "store"},
+			wantChanged: true,
+		},
+		{
+			name:        "renames to the same key in the order of the old keys",
+			labels:      map[string]string{"name": "store", "app": "shop"},
+			want:        map[string]string{"app.kubernetes.io/name": "shop"},
+			wantChanged: true,
+		},
+		{
+			name:   "leaves the labels of other Labelers",
+			labels: map[string]string{"legacy-team": "web", "app": "shop", "tier": "front"},
+			owners: map[string]map[string]string{
+				"default/teams": {"legacy-team": "web"},
+				"shop":          {"app": "shop"},
+			},
+			want: map[string]string{"legacy-team": "web", "app": "shop", "tier": "front"},
+			wantConflicts: []conflict{
+				{field: nulllabelerv1.ConflictLabel, key: "legacy-team", with: "default/teams"},
+				{field: nulllabelerv1.ConflictLabel, key: "app", with: "shop"},
+			},
+		},
+		{
+			name:        "takes back its own labels",
+			labels:      map[string]string{"legacy-team": "web"},
+			owners:      map[string]map[string]string{"default/conventions": {"legacy-team": "web"}},
+			want:        map[string]string{},
+			wantChanged: true,
+		},
+		{
+			name:   "migrated already",
+			labels: map[string]string{"app.kubernetes.io/name": "shop"},
+			want:   map[string]string{"app.kubernetes.io/name": "shop"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &matcher{labeler: &nulllabelerv1.Labeler{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "conventions"},
+				Spec:       spec,
+			}}
+			pod := testPod("default", "web-1", tt.labels)
+			for owner, labels := range tt.owners {
+				entry, err := appliedFieldsEntry(fieldManagerPrefix+owner, "v1", labels, nil, metav1.Now())
+				if err != nil {
+					t.Fatal(err)
+				}
+				pod.ManagedFields = append(pod.ManagedFields, entry)
+			}
+
+			got, changed, conflicts := m.migrate(pod)
+			if changed != tt.wantChanged || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("migrate() = %v, %v, want %v, %v", got, changed, tt.want, tt.wantChanged)
+			}
+			if !reflect.DeepEqual(conflicts, tt.wantConflicts) {
+				t.Errorf("migrate() conflicts = %v, want %v", conflicts, tt.wantConflicts)
+			}
+		})
+	}
+}
+
+func TestReconcileMigrates(t *testing.T) {
+	tests := []struct {
+		mode         nulllabelerv1.LabelerMode
+		wantLabels   map[string]string
+		wantMigrated int32
+		wantPending  int32
+	}{
+		{
+			mode:         nulllabelerv1.ModeMutate,
+			wantLabels:   map[string]string{"app.kubernetes.io/name": "shop", "team": "web"},
+			wantMigrated: 1,
+		},
+		{
+			mode:        nulllabelerv1.ModeAudit,
+			wantLabels:  map[string]string{"app": "shop", "legacy-team": "web"},
+			wantPending: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.mode), func(t *testing.T) {
+			labeler := &nulllabelerv1.Labeler{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "conventions"},
+				Spec: nulllabelerv1.LabelerSpec{
+					Mode:   tt.mode,
+					Labels: map[string]string{"team": "web"},
+					Remove: []string{"legacy-team"},
+					Rename: map[string]string{"app": "app.kubernetes.io/name"},
+				},
+			}
+			r, c := newTestReconciler(t,
+				&core.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}},
+				labeler,
+				testPod("default", "shop-1", map[string]string{"app": "shop", "legacy-team": "web"}),
+			)
+
+			// A second pass finds nothing left to do.
+			for pass := 1; pass <= 2; pass++ {
+				got, err := reconcileLabeler(t, r, client.ObjectKeyFromObject(labeler))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if labels := podLabels(t, c, "default", "shop-1"); !reflect.DeepEqual(labels, tt.wantLabels) {
+					t.Errorf("pass %d: labels = %v, want %v", pass, labels, tt.wantLabels)
+				}
+				if got.Status.Migrated != tt.wantMigrated || got.Status.PendingMigration != tt.wantPending {
+					t.Errorf("pass %d: migrated %d, pending %d, want %d, %d",
+						pass, got.Status.Migrated, got.Status.PendingMigration, tt.wantMigrated, tt.wantPending)
+				}
+			}
+		})
+	}
+}
+
+func TestReconcileLeavesLabelsOfOtherLabelers(t *testing.T) {
+	teams := &nulllabelerv1.Labeler{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "teams"},
+		Spec:       nulllabelerv1.LabelerSpec{Labels: map[string]string{"team": "web"}},
+	}
+	conventions := &nulllabelerv1.Labeler{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "conventions"},
+		Spec: nulllabelerv1.LabelerSpec{
+			// Above the other Labeler, which does not matter here.
+			Priority: 10,
+			Remove:   []string{"team"},
+		},
+	}
+	r, c := newTestReconciler(t,
+		&core.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}},
+		teams,
+		conventions,
+		testPod("default", "shop-1", nil),
+	)
+
+	// Once both went through, neither changes the pod any more.
+	var got map[string]*nulllabelerv1.Labeler
+	for pass := 1; pass <= 2; pass++ {
+		got = map[string]*nulllabelerv1.Labeler{}
+		for _, labeler := range []*nulllabelerv1.Labeler{teams, conventions} {
+			l, err := reconcileLabeler(t, r, client.ObjectKeyFromObject(labeler))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got[labeler.Name] = l
+		}
+		if labels := podLabels(t, c, "default", "shop-1"); labels["team"] != "web" {
+			t.Errorf("pass %d: labels = %v, want the team label kept", pass, labels)
+		}
+	}
+
+	if got["conventions"].Status.Migrated != 0 || got["conventions"].Status.PendingMigration != 0 {
+		t.Errorf("migrated %d, pending %d, want nothing", got["conventions"].Status.Migrated, got["conventions"].Status.PendingMigration)
+	}
+	wantConflicts := map[string][]nulllabelerv1.LabelConflict{
+		"teams":       {{Field: nulllabelerv1.ConflictLabel, Key: "team", Labeler: "default/conventions", Won: true, Objects: 1}},
+		"conventions": {{Field: nulllabelerv1.ConflictLabel, Key: "team", Labeler: "default/teams", Objects: 1}},
+	}
+	for name, want := range wantConflicts {
+		if conflicts := got[name].Status.Conflicts; !reflect.DeepEqual(conflicts, want) {
+			t.Errorf("%s conflicts = %v, want %v", name, conflicts, want)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	nulllabelerv1 "github.com/null-channel/stupid-kube-operators/labeler/api/v1"
+)
+
+const mutatePodPath = "/mutate--v1-pod"
+
+// PodLabeler gives pods the labels and annotations of the Labelers selecting
+// them as they are created, so they never run without them and do not need a
+// second write. The LabelerReconciler labels the pods that were there before,
+// and those the webhook missed.
+type PodLabeler struct {
+	Client  client.Client
+	decoder *admission.Decoder
+}
+
+// The failure policy is Ignore: pods the webhook could not label are labeled
+// by the reconciler soon after, which beats not being able to create pods.
+// Pods created while the webhook is down escape enforcement, and are only
+// reported by the reconciler.
+//+kubebuilder:webhook:path=/mutate--v1-pod,mutating=true,failurePolicy=ignore,sideEffects=None,groups="",resources=pods,verbs=create,versions=v1,name=mpod.nulllabeler.thenullchannel.dev,admissionReviewVersions={v1,v1beta1}
+
+// Handle does the removals and renames of the Labelers selecting the pod, and
+// sets on it the labels and annotations they want, resolving conflicts between
+// them the way the reconciler does. Every Labeler is recorded as the
+// server-side apply owner of what it set, so the reconciler finds the pod up
+// to date, and can take it all back later.
+//
+// Pods still missing labels an Enforce Labeler selecting them requires, once
+// the Mutate Labelers filled in their defaults, are rejected.
+func (l *PodLabeler) Handle(ctx context.Context, req admission.Request) admission.Response {
+	pod := &core.Pod{}
+	if err := l.decoder.Decode(req, pod); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	if pod.Namespace == "" {
+		pod.Namespace = req.Namespace
+	}
+
+	labeled, denied, err := l.label(ctx, pod, metav1.Now())
+	switch {
+	case err != nil:
+		return admission.Errored(http.StatusInternalServerError, err)
+	case denied != "":
+		return admission.Denied(denied)
+	case !labeled:
+		return admission.Allowed("no Labeler selects the pod")
+	}
+
+	marshaled, err := json.Marshal(pod)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	return admission.PatchResponseFromRaw(req.Object.Raw, marshaled)
+}
+
+// label labels pod, which is being created, for Handle, recording the
+// Labelers as having applied what they set at now. It returns whether any
+// Labeler selects the pod, and why the pod is denied, if it is.
+func (l *PodLabeler) label(ctx context.Context, pod *core.Pod, now metav1.Time) (bool, string, error) {
+	namespace := &core.Namespace{}
+	if err := l.Client.Get(ctx, client.ObjectKey{Name: pod.Namespace}, namespace); err != nil {
+		return false, "", err
+	}
+	namespaceLabels := map[string]labels.Set{namespace.Name: labels.Set(namespace.Labels)}
+
+	matchers, err := liveMatchers(ctx, l.Client, namespaceLabels, nil)
+	if err != nil {
+		return false, "", err
+	}
+
+	var selecting []*matcher
+	for _, m := range matchers {
+		if m.selects(nulllabelerv1.TargetPod, pod) {
+			selecting = append(selecting, m)
+		}
+	}
+	if len(selecting) == 0 {
+		return false, "", nil
+	}
+
+	// Removals and renames go first, for everything after to see the labels
+	// the pod ends up with. The labels they leave to other Labelers are not
+	// reported: the reconciler does that.
+	for _, m := range selecting {
+		if modeOf(m.labeler) != nulllabelerv1.ModeMutate {
+			continue
+		}
+		if migrated, changed, _ := m.migrate(pod); changed {
+			pod.Labels = migrated
+		}
+	}
+
+	var contenders []contender
+	for _, m := range selecting {
+		contenders = append(contenders, admissionContender(m, pod))
+	}
+
+	resolutions := resolve(contenders)
+	for _, m := range selecting {
+		res := resolutions[labelerName(m.labeler)]
+		if len(res.labels) == 0 && len(res.annotations) == 0 {
+			continue
+		}
+
+		for key, value := range res.labels {
+			metav1.SetMetaDataLabel(&pod.ObjectMeta, key, value)
+		}
+		for key, value := range res.annotations {
+			metav1.SetMetaDataAnnotation(&pod.ObjectMeta, key, value)
+		}
+		entry, err := appliedFieldsEntry(fieldManager(m.labeler), "v1", res.labels, res.annotations, now)
+		if err != nil {
+			return false, "", err
+		}
+		pod.ManagedFields = append(pod.ManagedFields, entry)
+	}
+
+	for _, m := range selecting {
+		if modeOf(m.labeler) != nulllabelerv1.ModeEnforce {
+			continue
+		}
+		if missing := m.missing(pod, nil); len(missing) > 0 {
+			return true, fmt.Sprintf("Labeler %s requires labels %s", labelerName(m.labeler), strings.Join(missing, ", ")), nil
+		}
+	}
+	return true, "", nil
+}
+
+// admissionContender returns the Labeler of m as a contender for the keys of
+// pod, which is being created. Pods created from a generateName, like those
+// of ReplicaSets, have no name yet: values that depend on it are left out, for
+// the reconciler to set once the pod has its name. Values that do not render
+// are reported by the reconciler too.
+func admissionContender(m *matcher, pod *core.Pod) contender {
+	c, _ := m.contender(nulllabelerv1.TargetPod, pod)
+	if pod.Name != "" {
+		return c
+	}
+
+	named := pod.DeepCopy()
+	named.Name = pod.GenerateName + "name"
+	other, _ := m.contender(nulllabelerv1.TargetPod, named)
+	c.labels = sameValues(c.labels, other.labels)
+	c.annotations = sameValues(c.annotations, other.annotations)
+	return c
+}
+
+// sameValues returns the entries of a that b has too, with the same value.
+func sameValues(a, b map[string]string) map[string]string {
+	same := map[string]string{}
+	for key, value := range a {
+		if other, ok := b[key]; ok && other == value {
+			same[key] = value
+		}
+	}
+	return same
+}
+
+// InjectDecoder implements admission.DecoderInjector.
+func (l *PodLabeler) InjectDecoder(d *admission.Decoder) error {
+	l.decoder = d
+	return nil
+}
+
+// SetupWebhookWithManager registers the webhook with the webhook server of the Manager.
+func (l *PodLabeler) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	mgr.GetWebhookServer().Register(mutatePodPath, &webhook.Admission{Handler: l})
+	return nil
+}
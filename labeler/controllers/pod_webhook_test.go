@@ -0,0 +1,155 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	nulllabelerv1 "github.com/null-channel/stupid-kube-operators/labeler/api/v1"
+)
+
+// replicaSetPod returns a pod the way a ReplicaSet creates it: from a
+// generateName, without a name.
+func replicaSetPod() *core.Pod {
+	controller := true
+	return &core.Pod{ObjectMeta: metav1.ObjectMeta{
+		Namespace:       "default",
+		GenerateName:    "web-5d4f-",
+		Labels:          map[string]string{"app": "web"},
+		OwnerReferences: []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "web-5d4f", Controller: &controller}},
+	}}
+}
+
+func TestPodLabelerLabelsGeneratedPods(t *testing.T) {
+	ctx := context.Background()
+	labeler := &nulllabelerv1.Labeler{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "team"},
+		Spec: nulllabelerv1.LabelerSpec{Labels: map[string]string{
+			"team":  "web",
+			"owner": "{{ .Owner.Name }}",
+			"pod":   "{{ .Name }}",
+		}},
+	}
+	r, c := newTestReconciler(t, &core.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}}, labeler)
+	manager := fieldManager(labeler)
+
+	pod := replicaSetPod()
+	labeled, denied, err := (&PodLabeler{Client: c}).label(ctx, pod, metav1.Now())
+	if err != nil || !labeled || denied != "" {
+		t.Fatalf("label() = %v, %q, %v, want the pod labeled", labeled, denied, err)
+	}
+	want := map[string]string{"app": "web", "team": "web", "owner": "web-5d4f"}
+	if !reflect.DeepEqual(pod.Labels, want) {
+		t.Errorf("labels at admission = %v, want %v, without the name the pod does not have yet", pod.Labels, want)
+	}
+	if owned, _ := ownedKeys(pod, manager); !reflect.DeepEqual(owned, map[string]bool{"team": true, "owner": true}) {
+		t.Errorf("labeler owns %v, want what it set", owned)
+	}
+
+	// The API server names the pod; the reconciler adds what depends on it.
+	pod.Name = pod.GenerateName + "x7k2p"
+	if err := c.Create(ctx, pod); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reconcileLabeler(t, r, client.ObjectKeyFromObject(labeler)); err != nil {
+		t.Fatal(err)
+	}
+	want["pod"] = pod.Name
+	got := &core.Pod{}
+	if err := c.Get(ctx, client.ObjectKeyFromObject(pod), got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got.Labels, want) {
+		t.Errorf("labels after reconcile = %v, want %v", got.Labels, want)
+	}
+	if owned, _ := ownedKeys(got, manager); len(owned) != 3 {
+		t.Errorf("labeler owns %v, want all three labels", owned)
+	}
+}
+
+func TestPodLabelerHandle(t *testing.T) {
+	namespace := &core.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}}
+	mutate := &nulllabelerv1.Labeler{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "team"},
+		Spec:       nulllabelerv1.LabelerSpec{Labels: map[string]string{"team": "web"}},
+	}
+	enforce := &nulllabelerv1.Labeler{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "cost-center"},
+		Spec: nulllabelerv1.LabelerSpec{
+			Mode:     nulllabelerv1.ModeEnforce,
+			Required: []nulllabelerv1.RequiredLabel{{Key: "cost-center"}},
+		},
+	}
+
+	tests := []struct {
+		name        string
+		objs        []client.Object
+		allowed     bool
+		wantPatches bool
+		message     string
+	}{
+		{name: "no labelers", allowed: true},
+		{name: "labeled", objs: []client.Object{mutate}, allowed: true, wantPatches: true},
+		{name: "missing required labels", objs: []client.Object{mutate, enforce}, message: "Labeler default/cost-center requires labels cost-center"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, c := newTestReconciler(t, append(tt.objs, namespace)...)
+			decoder, err := admission.NewDecoder(r.Scheme)
+			if err != nil {
+				t.Fatal(err)
+			}
+			l := &PodLabeler{Client: c}
+			if err := l.InjectDecoder(decoder); err != nil {
+				t.Fatal(err)
+			}
+
+			pod := replicaSetPod()
+			pod.Namespace = ""
+			raw, err := json.Marshal(pod)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp := l.Handle(context.Background(), admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+				Operation: admissionv1.Create,
+				Namespace: "default",
+				Object:    runtime.RawExtension{Raw: raw},
+			}})
+			if resp.Allowed != tt.allowed {
+				t.Fatalf("Handle() allowed = %v, want %v: %+v", resp.Allowed, tt.allowed, resp.Result)
+			}
+			if (len(resp.Patches) > 0) != tt.wantPatches {
+				t.Errorf("Handle() patches = %v, want patches: %v", resp.Patches, tt.wantPatches)
+			}
+			if tt.message != "" && (resp.Result == nil || !strings.Contains(string(resp.Result.Reason), tt.message)) {
+				t.Errorf("Handle() result = %+v, want a message with %q", resp.Result, tt.message)
+			}
+		})
+	}
+}
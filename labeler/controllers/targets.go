@@ -0,0 +1,386 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	authorization "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/cluster"
+
+	nulllabelerv1 "github.com/null-channel/stupid-kube-operators/labeler/api/v1"
+)
+
+// DefaultTargetKinds are the kinds Labelers can target unless the operator is
+// given an allow-list of its own. The RBAC rules of the operator cover them.
+var DefaultTargetKinds = []nulllabelerv1.TargetKind{
+	nulllabelerv1.TargetPod,
+	nulllabelerv1.TargetService,
+	nulllabelerv1.TargetPersistentVolumeClaim,
+	nulllabelerv1.TargetNamespace,
+	nulllabelerv1.TargetDeployment,
+	nulllabelerv1.TargetStatefulSet,
+	nulllabelerv1.TargetDaemonSet,
+}
+
+// legacyGroups are the groups of the kinds Labelers named without their group
+// before they could target any kind.
+var legacyGroups = map[string]string{
+	"Deployment":  "apps",
+	"StatefulSet": "apps",
+	"DaemonSet":   "apps",
+}
+
+// normalizeKind returns kind as Kind.group, or as Kind for the core group.
+func normalizeKind(kind nulllabelerv1.TargetKind) nulllabelerv1.TargetKind {
+	if group, ok := legacyGroups[string(kind)]; ok {
+		return nulllabelerv1.TargetKind(string(kind) + "." + group)
+	}
+	return kind
+}
+
+// targetType is a kind of object Labelers can target. Objects of every kind
+// are handled as unstructured.Unstructured.
+type targetType struct {
+	gvk        schema.GroupVersionKind
+	resource   schema.GroupVersionResource
+	namespaced bool
+}
+
+func (t targetType) newObject() client.Object {
+	u := &unstructured.Unstructured{}
+	u.SetGroupVersionKind(t.gvk)
+	return u
+}
+
+func (t targetType) newList() client.ObjectList {
+	u := &unstructured.UnstructuredList{}
+	u.SetGroupVersionKind(t.gvk.GroupVersion().WithKind(t.gvk.Kind + "List"))
+	return u
+}
+
+// NewClientBuilder returns the builder of the client the reconciler needs from
+// the manager. Unlike the default client, it reads unstructured objects from
+// the cache as well, since target objects are unstructured and are looked up
+// by fields only the cache indexes.
+func NewClientBuilder() cluster.ClientBuilder {
+	return &clientBuilder{}
+}
+
+type clientBuilder struct {
+	uncached []client.Object
+}
+
+func (b *clientBuilder) WithUncached(objs ...client.Object) cluster.ClientBuilder {
+	b.uncached = append(b.uncached, objs...)
+	return b
+}
+
+func (b *clientBuilder) Build(cache cache.Cache, config *rest.Config, options client.Options) (client.Client, error) {
+	c, err := client.New(config, options)
+	if err != nil {
+		return nil, err
+	}
+	return newCachingClient(cache, c, b.uncached...)
+}
+
+// newCachingClient returns a client that reads from cache, unstructured
+// objects included, but for the uncached kinds, and goes through c otherwise.
+func newCachingClient(cache client.Reader, c client.Client, uncached ...client.Object) (client.Client, error) {
+	return client.NewDelegatingClient(client.NewDelegatingClientInput{
+		CacheReader:       cache,
+		Client:            c,
+		UncachedObjects:   uncached,
+		CacheUnstructured: true,
+	})
+}
+
+// resolveTargets looks the kinds of the allow-list up with mapper.
+func resolveTargets(mapper meta.RESTMapper, kinds []nulllabelerv1.TargetKind) (map[nulllabelerv1.TargetKind]targetType, error) {
+	targets := map[nulllabelerv1.TargetKind]targetType{}
+	for _, kind := range kinds {
+		kind = normalizeKind(kind)
+		mapping, err := mapper.RESTMapping(schema.ParseGroupKind(string(kind)))
+		if err != nil {
+			return nil, fmt.Errorf("target kind %s: %w", kind, err)
+		}
+		targets[kind] = targetType{
+			gvk:        mapping.GroupVersionKind,
+			resource:   mapping.Resource,
+			namespaced: mapping.Scope.Name() == meta.RESTScopeNameNamespace,
+		}
+	}
+	return targets, nil
+}
+
+// targetVerbs are what the operator does with objects of the target kinds.
+var targetVerbs = []string{"get", "list", "watch", "patch"}
+
+// checkAccess makes sure the operator can do what it does with objects of
+// every kind of the allow-list, in every namespace. The RBAC rules of the
+// operator only cover DefaultTargetKinds: without rules of their own, other
+// kinds would fail every sync.
+func (r *LabelerReconciler) checkAccess(ctx context.Context) error {
+	var missing []string
+	for _, kind := range r.targetKinds() {
+		resource := r.targets[kind].resource
+
+		var denied []string
+		for _, verb := range targetVerbs {
+			review := &authorization.SelfSubjectAccessReview{
+				Spec: authorization.SelfSubjectAccessReviewSpec{
+					ResourceAttributes: &authorization.ResourceAttributes{
+						Group:    resource.Group,
+						Resource: resource.Resource,
+						Verb:     verb,
+					},
+				},
+			}
+			if err := r.Client.Create(ctx, review); err != nil {
+				return fmt.Errorf("checking access to target kind %s: %w", kind, err)
+			}
+			if !review.Status.Allowed {
+				denied = append(denied, verb)
+			}
+		}
+		if len(denied) > 0 {
+			missing = append(missing, fmt.Sprintf("%s (%s)", resource.GroupResource(), strings.Join(denied, ", ")))
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("the operator needs RBAC rules to %s the target kinds, and is not allowed to on %s",
+			strings.Join(targetVerbs, ", "), strings.Join(missing, "; "))
+	}
+	return nil
+}
+
+// targetsOf returns the kinds labeler targets.
+func targetsOf(labeler *nulllabelerv1.Labeler) []nulllabelerv1.TargetKind {
+	if len(labeler.Spec.Targets) == 0 {
+		return []nulllabelerv1.TargetKind{nulllabelerv1.TargetPod}
+	}
+
+	kinds := make([]nulllabelerv1.TargetKind, 0, len(labeler.Spec.Targets))
+	for _, kind := range labeler.Spec.Targets {
+		kinds = append(kinds, normalizeKind(kind))
+	}
+	return kinds
+}
+
+// matcher decides which objects a Labeler selects.
+type matcher struct {
+	labeler    *nulllabelerv1.Labeler
+	selector   labels.Selector
+	namespaces map[string]bool
+	// everyNamespace is true when the Labeler does not limit itself to some namespaces.
+	everyNamespace  bool
+	namespaceLabels map[string]labels.Set
+	targets         map[nulllabelerv1.TargetKind]bool
+
+	// labels, annotations and defaults are set by compile.
+	labels      valueTemplates
+	annotations valueTemplates
+	// defaults are the defaults of the required labels that have one.
+	defaults valueTemplates
+}
+
+// newMatcher returns the matcher of labeler, given the labels of every namespace.
+func newMatcher(labeler *nulllabelerv1.Labeler, namespaceLabels map[string]labels.Set) (*matcher, error) {
+	selector, err := selectorFor(labeler.Spec.Selector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid selector: %w", err)
+	}
+
+	namespaceSelector, err := selectorFor(labeler.Spec.NamespaceSelector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid namespace selector: %w", err)
+	}
+
+	m := &matcher{
+		labeler:         labeler,
+		selector:        selector,
+		namespaces:      map[string]bool{},
+		everyNamespace:  labeler.Namespace == "" && namespaceSelector.Empty(),
+		namespaceLabels: namespaceLabels,
+		targets:         map[nulllabelerv1.TargetKind]bool{},
+	}
+	for name, set := range namespaceLabels {
+		// Labelers are confined to their own namespace.
+		if labeler.Namespace != "" && name != labeler.Namespace {
+			continue
+		}
+		if namespaceSelector.Matches(set) {
+			m.namespaces[name] = true
+		}
+	}
+	for _, kind := range targetsOf(labeler) {
+		m.targets[kind] = true
+	}
+	return m, nil
+}
+
+// selects reports whether obj, of kind, is one of the objects of the Labeler.
+// Cluster-scoped objects are selected by ClusterLabelers, by their labels
+// alone.
+func (m *matcher) selects(kind nulllabelerv1.TargetKind, obj client.Object) bool {
+	if !m.targets[kind] {
+		return false
+	}
+	if obj.GetNamespace() == "" && m.labeler.Namespace != "" {
+		return false
+	}
+	if obj.GetNamespace() != "" && !m.namespaces[obj.GetNamespace()] {
+		return false
+	}
+	return m.selector.Matches(labels.Set(obj.GetLabels()))
+}
+
+// compile parses the label and annotation values of the Labeler as templates,
+// and checks its removals and renames. It is only needed before contender or
+// migrate are called.
+func (m *matcher) compile() error {
+	if err := checkMigrations(m.labeler); err != nil {
+		return fmt.Errorf("invalid migration: %w", err)
+	}
+
+	var err error
+	if m.labels, err = parseValues("label", m.labeler.Spec.Labels); err != nil {
+		return fmt.Errorf("invalid template: %w", err)
+	}
+	if m.annotations, err = parseValues("annotation", m.labeler.Spec.Annotations); err != nil {
+		return fmt.Errorf("invalid template: %w", err)
+	}
+
+	defaults := map[string]string{}
+	for _, required := range m.labeler.Spec.Required {
+		if required.Default != "" {
+			defaults[required.Key] = required.Default
+		}
+	}
+	if m.defaults, err = parseValues("default of label", defaults); err != nil {
+		return fmt.Errorf("invalid template: %w", err)
+	}
+	return nil
+}
+
+// modeOf returns the mode of labeler.
+func modeOf(labeler *nulllabelerv1.Labeler) nulllabelerv1.LabelerMode {
+	if labeler.Spec.Mode == "" {
+		return nulllabelerv1.ModeMutate
+	}
+	return labeler.Spec.Mode
+}
+
+// contender returns the Labeler of m as a contender for the keys of obj, of
+// kind, with its values rendered for obj. Keys that do not render to valid
+// values are left out, and returned as an error.
+//
+// The defaults of required labels are claimed when obj does not have the
+// label, or has it from the Labeler already. Labelers in other modes than
+// Mutate do not claim anything.
+func (m *matcher) contender(kind nulllabelerv1.TargetKind, obj client.Object) (contender, error) {
+	c := contender{
+		name:     labelerName(m.labeler),
+		priority: m.labeler.Spec.Priority,
+	}
+	if modeOf(m.labeler) != nulllabelerv1.ModeMutate {
+		return c, nil
+	}
+
+	data := newTemplateData(string(kind), obj, m.namespaceLabels[obj.GetNamespace()])
+
+	labelValues, labelErr := m.labels.render("label", data, validLabelValue)
+	annotationValues, annotationErr := m.annotations.render("annotation", data, nil)
+
+	owned, _ := ownedKeys(obj, fieldManager(m.labeler))
+	defaults := valueTemplates{}
+	for key, t := range m.defaults {
+		_, has := obj.GetLabels()[key]
+		_, set := labelValues[key]
+		if (!has || owned[key]) && !set {
+			defaults[key] = t
+		}
+	}
+	defaultValues, defaultErr := defaults.render("default of label", data, validLabelValue)
+
+	if len(defaultValues) > 0 && labelValues == nil {
+		labelValues = map[string]string{}
+	}
+	for key, value := range defaultValues {
+		labelValues[key] = value
+	}
+
+	c.labels = labelValues
+	c.annotations = annotationValues
+	return c, utilerrors.NewAggregate([]error{labelErr, annotationErr, defaultErr})
+}
+
+// missing returns the keys of the required labels obj has neither of its own
+// nor in adding, sorted.
+func (m *matcher) missing(obj client.Object, adding map[string]string) []string {
+	var keys []string
+	for _, required := range m.labeler.Spec.Required {
+		if _, has := obj.GetLabels()[required.Key]; has {
+			continue
+		}
+		if _, added := adding[required.Key]; added {
+			continue
+		}
+		keys = append(keys, required.Key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// labelerName names labeler as namespace/name, or by its name alone for a
+// ClusterLabeler.
+func labelerName(labeler *nulllabelerv1.Labeler) string {
+	if labeler.Namespace == "" {
+		return labeler.Name
+	}
+	return labeler.Namespace + "/" + labeler.Name
+}
+
+// selectorFor converts selector, matching everything when it is nil.
+func selectorFor(selector *metav1.LabelSelector) (labels.Selector, error) {
+	if selector == nil {
+		return labels.Everything(), nil
+	}
+	return metav1.LabelSelectorAsSelector(selector)
+}
+
+func containsAll(have, want map[string]string) bool {
+	for k, v := range want {
+		if current, ok := have[k]; !ok || current != v {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,157 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	authorization "k8s.io/api/authorization/v1"
+	core "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	nulllabelerv1 "github.com/null-channel/stupid-kube-operators/labeler/api/v1"
+)
+
+// reviewClient answers access reviews, allowing every verb on every resource
+// but those in denied, as resource/verb.
+type reviewClient struct {
+	client.Client
+	denied map[string]bool
+}
+
+func (c *reviewClient) Create(_ context.Context, obj client.Object, _ ...client.CreateOption) error {
+	review := obj.(*authorization.SelfSubjectAccessReview)
+	attributes := review.Spec.ResourceAttributes
+	resource := schema.GroupResource{Group: attributes.Group, Resource: attributes.Resource}
+	review.Status.Allowed = !c.denied[resource.String()+"/"+attributes.Verb]
+	return nil
+}
+
+func TestCheckAccess(t *testing.T) {
+	widgets := schema.GroupVersion{Group: "example.com", Version: "v1"}
+	mapper := meta.NewDefaultRESTMapper([]schema.GroupVersion{core.SchemeGroupVersion, widgets})
+	mapper.Add(core.SchemeGroupVersion.WithKind("Pod"), meta.RESTScopeNamespace)
+	mapper.Add(widgets.WithKind("Widget"), meta.RESTScopeNamespace)
+	targets, err := resolveTargets(mapper, []nulllabelerv1.TargetKind{"Pod", "Widget.example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		denied  map[string]bool
+		wantErr string
+	}{
+		{name: "allowed everything"},
+		{
+			name:    "kind without rules",
+			denied:  map[string]bool{"widgets.example.com/get": true, "widgets.example.com/list": true, "widgets.example.com/watch": true, "widgets.example.com/patch": true},
+			wantErr: "widgets.example.com (get, list, watch, patch)",
+		},
+		{
+			name:    "rules missing a verb",
+			denied:  map[string]bool{"pods/patch": true},
+			wantErr: "pods (patch)",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &LabelerReconciler{Client: &reviewClient{denied: tt.denied}, targets: targets}
+			err := r.checkAccess(context.Background())
+			if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("checkAccess() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// apiServerClient lists objects the way the API server does, which knows
+// nothing of the field indexes of the cache.
+type apiServerClient struct {
+	client.Client
+}
+
+func (c *apiServerClient) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	listOpts := client.ListOptions{}
+	listOpts.ApplyOptions(opts)
+	if listOpts.FieldSelector != nil && !listOpts.FieldSelector.Empty() {
+		for _, requirement := range listOpts.FieldSelector.Requirements() {
+			if requirement.Field != "metadata.name" && requirement.Field != "metadata.namespace" {
+				return apierrors.NewBadRequest("field label not supported: " + requirement.Field)
+			}
+		}
+	}
+	return c.Client.List(ctx, list, opts...)
+}
+
+func TestReconcileThroughCachingClient(t *testing.T) {
+	ctx := context.Background()
+	labeler := &nulllabelerv1.Labeler{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "team"},
+		Spec:       nulllabelerv1.LabelerSpec{Labels: map[string]string{"team": "web"}},
+	}
+	r, c := newTestReconciler(t,
+		&core.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}},
+		labeler,
+		testPod("default", "web-1", nil),
+	)
+	// The applyClient stands in for the cache, and its field index.
+	cachingClient, err := newCachingClient(c, &apiServerClient{Client: c})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Client = cachingClient
+	key := client.ObjectKeyFromObject(labeler)
+
+	if _, err := reconcileLabeler(t, r, key); err != nil {
+		t.Fatal(err)
+	}
+	if labels := podLabels(t, c, "default", "web-1"); labels["team"] != "web" {
+		t.Errorf("pod labels = %v, want it labeled", labels)
+	}
+
+	// The fake client deletes right away, finalizers or not.
+	deleting, err := getLabeler(ctx, c, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := metav1.Now()
+	deleting.DeletionTimestamp = &now
+	if err := c.Update(ctx, deleting); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := reconcileLabeler(t, r, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if labels := podLabels(t, c, "default", "web-1"); len(labels) != 0 {
+		t.Errorf("pod labels = %v, want them taken back", labels)
+	}
+	if controllerutil.ContainsFinalizer(got, cleanupFinalizer) {
+		t.Errorf("finalizer still there")
+	}
+
+}
@@ -0,0 +1,190 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"text/template"
+
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// templateFuncs are the functions label and annotation templates can use on
+// top of the text/template builtins.
+var templateFuncs = template.FuncMap{
+	"tag": imageTag,
+}
+
+// templateData is what label and annotation templates are evaluated against.
+type templateData struct {
+	Kind        string
+	Name        string
+	Namespace   namespaceData
+	Labels      map[string]string
+	Annotations map[string]string
+	// Owner is the controller of the object, if it has one.
+	Owner ownerData
+	// NodeName is the node a pod runs on. It is empty for other kinds.
+	NodeName string
+	// Spec is the spec of the object, with the field names of its Go type, or
+	// as in JSON for kinds without one.
+	Spec interface{}
+}
+
+type namespaceData struct {
+	Name   string
+	Labels map[string]string
+}
+
+type ownerData struct {
+	Kind string
+	Name string
+}
+
+// Annotation returns the value of the annotation key, or nothing.
+func (d templateData) Annotation(key string) string {
+	return d.Annotations[key]
+}
+
+// newTemplateData returns the data the templates of obj, of kind, are
+// evaluated against, given the labels of its namespace.
+func newTemplateData(kind string, obj client.Object, namespaceLabels labels.Set) templateData {
+	data := templateData{
+		Kind:        kind,
+		Name:        obj.GetName(),
+		Namespace:   namespaceData{Name: obj.GetNamespace(), Labels: namespaceLabels},
+		Labels:      obj.GetLabels(),
+		Annotations: obj.GetAnnotations(),
+	}
+
+	if owner := metav1.GetControllerOfNoCopy(obj); owner != nil {
+		data.Owner = ownerData{Kind: owner.Kind, Name: owner.Name}
+	}
+	if pod, ok := obj.(*core.Pod); ok {
+		data.NodeName = pod.Spec.NodeName
+	}
+	if u, ok := obj.(*unstructured.Unstructured); ok {
+		data.Spec = u.Object["spec"]
+	} else if v := reflect.Indirect(reflect.ValueOf(obj)); v.Kind() == reflect.Struct {
+		if spec := v.FieldByName("Spec"); spec.IsValid() {
+			data.Spec = spec.Interface()
+		}
+	}
+	return data
+}
+
+// typedObject converts obj to its Go type when it is unstructured and s knows
+// the type, so that templates see the same field names for built-in kinds
+// whichever way the object was read.
+func typedObject(s *runtime.Scheme, obj client.Object) client.Object {
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return obj
+	}
+
+	typed, err := s.New(u.GroupVersionKind())
+	if err != nil {
+		return obj
+	}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, typed); err != nil {
+		return obj
+	}
+	if typedObj, ok := typed.(client.Object); ok {
+		return typedObj
+	}
+	return obj
+}
+
+// valueTemplates are the values of the labels or annotations of a Labeler,
+// parsed as templates.
+type valueTemplates map[string]*template.Template
+
+// parseValues parses values as templates. Values without actions are
+// templates too; they render as themselves.
+func parseValues(field string, values map[string]string) (valueTemplates, error) {
+	templates := valueTemplates{}
+	for key, value := range values {
+		t, err := template.New(key).Funcs(templateFuncs).Option("missingkey=zero").Parse(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s %q: %w", field, key, err)
+		}
+		templates[key] = t
+	}
+	return templates, nil
+}
+
+// render evaluates the templates against data. Keys that fail to render, or
+// that render to something validate rejects, are left out, and what went
+// wrong with them is returned as an error.
+func (t valueTemplates) render(field string, data templateData, validate func(string) []string) (map[string]string, error) {
+	if len(t) == 0 {
+		return nil, nil
+	}
+
+	keys := make([]string, 0, len(t))
+	for key := range t {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	values := map[string]string{}
+	var errs []error
+	for _, key := range keys {
+		var b strings.Builder
+		if err := t[key].Execute(&b, data); err != nil {
+			errs = append(errs, fmt.Errorf("%s %q: %w", field, key, err))
+			continue
+		}
+
+		value := b.String()
+		if validate != nil {
+			if problems := validate(value); len(problems) > 0 {
+				errs = append(errs, fmt.Errorf("%s %q: invalid value %q: %s", field, key, value, strings.Join(problems, "; ")))
+				continue
+			}
+		}
+		values[key] = value
+	}
+	return values, utilerrors.NewAggregate(errs)
+}
+
+// validLabelValue validates value against the syntax of label values.
+func validLabelValue(value string) []string {
+	return validation.IsValidLabelValue(value)
+}
+
+// imageTag returns the tag of a container image reference, "latest" when it
+// has none. Digests are ignored.
+func imageTag(image string) string {
+	if at := strings.Index(image, "@"); at >= 0 {
+		image = image[:at]
+	}
+	if colon := strings.LastIndex(image, ":"); colon > strings.LastIndex(image, "/") {
+		return image[colon+1:]
+	}
+	return "latest"
+}
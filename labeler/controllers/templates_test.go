@@ -0,0 +1,242 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	apps "k8s.io/api/apps/v1"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestImageTag(t *testing.T) {
+	tests := []struct {
+		image string
+		want  string
+	}{
+		{image: "nginx", want: "latest"},
+		{image: "nginx:1.21", want: "1.21"},
+		{image: "registry:5000/nginx", want: "latest"},
+		{image: "registry:5000/team/nginx:1.21-alpine", want: "1.21-alpine"},
+		{image: "nginx@sha256:0d17b565c37bcbd895e9d92315a05c1c3c9a29f762b011a10c54a66cd53c9b31", want: "latest"},
+		{image: "nginx:1.21@sha256:0d17b565c37bcbd895e9d92315a05c1c3c9a29f762b011a10c54a66cd53c9b31", want: "1.21"},
+	}
+
+	for _, tt := range tests {
+		if got := imageTag(tt.image); got != tt.want {
+			t.Errorf("imageTag(%q) = %q, want %q", tt.image, got, tt.want)
+		}
+	}
+}
+
+func TestValidLabelValue(t *testing.T) {
+	tests := []struct {
+		value string
+		valid bool
+	}{
+		{value: "web", valid: true},
+		{value: "", valid: true},
+		{value: "v1.21_alpine-3", valid: true},
+		{value: "two words"},
+		{value: "-web"},
+		{value: strings.Repeat("a", 64)},
+	}
+
+	for _, tt := range tests {
+		if problems := validLabelValue(tt.value); (len(problems) == 0) != tt.valid {
+			t.Errorf("validLabelValue(%q) = %v, want valid: %v", tt.value, problems, tt.valid)
+		}
+	}
+}
+
+func TestNewTemplateData(t *testing.T) {
+	controller := true
+	pod := &core.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:       "default",
+			Name:            "web-1",
+			Labels:          map[string]string{"app": "web"},
+			OwnerReferences: []metav1.OwnerReference{{Kind: "ReplicaSet", Name: "web-5d4f", Controller: &controller}},
+		},
+		Spec: core.PodSpec{NodeName: "node-1", Containers: []core.Container{{Name: "web", Image: "nginx:1.21"}}},
+	}
+	deployment := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "apps/v1",
+		"kind":       "Deployment",
+		"metadata":   map[string]interface{}{"namespace": "default", "name": "web"},
+		"spec":       map[string]interface{}{"replicas": int64(3)},
+	}}
+	namespaceLabels := labels.Set{"team": "web"}
+
+	tests := []struct {
+		name string
+		kind string
+		obj  client.Object
+		want templateData
+	}{
+		{
+			name: "pod",
+			kind: "Pod",
+			obj:  pod,
+			want: templateData{
+				Kind:      "Pod",
+				Name:      "web-1",
+				Namespace: namespaceData{Name: "default", Labels: namespaceLabels},
+				Labels:    map[string]string{"app": "web"},
+				Owner:     ownerData{Kind: "ReplicaSet", Name: "web-5d4f"},
+				NodeName:  "node-1",
+				Spec:      pod.Spec,
+			},
+		},
+		{
+			name: "unstructured deployment",
+			kind: "Deployment.apps",
+			obj:  deployment,
+			want: templateData{
+				Kind:      "Deployment.apps",
+				Name:      "web",
+				Namespace: namespaceData{Name: "default", Labels: namespaceLabels},
+				Spec:      map[string]interface{}{"replicas": int64(3)},
+			},
+		},
+		{
+			name: "typed deployment, without labels or an owner",
+			kind: "Deployment.apps",
+			obj:  typedObject(scheme.Scheme, deployment),
+			want: templateData{
+				Kind:      "Deployment.apps",
+				Name:      "web",
+				Namespace: namespaceData{Name: "default", Labels: namespaceLabels},
+				Spec:      apps.DeploymentSpec{Replicas: func() *int32 { r := int32(3); return &r }()},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := newTemplateData(tt.kind, tt.obj, namespaceLabels); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("newTemplateData() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseValues(t *testing.T) {
+	if _, err := parseValues("label", map[string]string{"team": "{{ .Namespace.Labels.team }}"}); err != nil {
+		t.Errorf("parseValues() error = %v", err)
+	}
+	if _, err := parseValues("label", map[string]string{"team": "{{ .Namespace.Labels.team"}); err == nil || !strings.Contains(err.Error(), `label "team"`) {
+		t.Errorf("parseValues() error = %v, want the bad template named", err)
+	}
+	if _, err := parseValues("label", map[string]string{"team": "{{ unknown .Name }}"}); err == nil {
+		t.Error("parseValues() accepted an unknown function")
+	}
+}
+
+func TestRender(t *testing.T) {
+	pod := &core.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   "default",
+			Name:        "web-1",
+			Labels:      map[string]string{"app": "web"},
+			Annotations: map[string]string{"description": "the web pod"},
+		},
+		Spec: core.PodSpec{Containers: []core.Container{{Name: "web", Image: "nginx:1.21"}}},
+	}
+	data := newTemplateData("Pod", pod, labels.Set{"team": "web"})
+
+	tests := []struct {
+		name     string
+		values   map[string]string
+		validate func(string) []string
+		want     map[string]string
+		wantErr  string
+	}{
+		{
+			name:     "plain values",
+			values:   map[string]string{"tier": "front"},
+			validate: validLabelValue,
+			want:     map[string]string{"tier": "front"},
+		},
+		{
+			name: "fields and functions",
+			values: map[string]string{
+				"team":    "{{ .Namespace.Labels.team }}",
+				"app":     "{{ .Labels.app }}-{{ .Kind }}",
+				"version": "{{ (index .Spec.Containers 0).Image | tag }}",
+				"about":   `{{ .Annotation "description" | len }}`,
+			},
+			validate: validLabelValue,
+			want:     map[string]string{"team": "web", "app": "web-Pod", "version": "1.21", "about": "11"},
+		},
+		{
+			name:     "missing map keys render empty",
+			values:   map[string]string{"owner": "{{ .Labels.owner }}{{ .Namespace.Labels.owner }}"},
+			validate: validLabelValue,
+			want:     map[string]string{"owner": ""},
+		},
+		{
+			name:     "missing fields fail",
+			values:   map[string]string{"owner": "{{ .Owner.Team }}", "tier": "front"},
+			validate: validLabelValue,
+			want:     map[string]string{"tier": "front"},
+			wantErr:  `label "owner"`,
+		},
+		{
+			name:     "out of range",
+			values:   map[string]string{"sidecar": "{{ (index .Spec.Containers 1).Image }}"},
+			validate: validLabelValue,
+			want:     map[string]string{},
+			wantErr:  `label "sidecar"`,
+		},
+		{
+			name:     "invalid label values",
+			values:   map[string]string{"description": `{{ .Annotation "description" }}`},
+			validate: validLabelValue,
+			want:     map[string]string{},
+			wantErr:  `label "description": invalid value "the web pod"`,
+		},
+		{
+			name:   "annotations take anything",
+			values: map[string]string{"description": `{{ .Annotation "description" }}`},
+			want:   map[string]string{"description": "the web pod"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			templates, err := parseValues("label", tt.values)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := templates.render("label", data, tt.validate)
+			if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("render() error = %v, want %q", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("render() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
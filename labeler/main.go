@@ -19,6 +19,7 @@ package main
 import (
 	"flag"
 	"os"
+	"strings"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
@@ -53,11 +54,15 @@ func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var targetKinds string
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.StringVar(&targetKinds, "target-kinds", joinKinds(controllers.DefaultTargetKinds),
+		"The comma-separated kinds Labelers can target, as Kind.group or as Kind for the core group. "+
+			"The operator has to be allowed to get, list, watch and patch objects of every kind, and does not start otherwise.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -73,6 +78,7 @@ func main() {
 		HealthProbeBindAddress: probeAddr,
 		LeaderElection:         enableLeaderElection,
 		LeaderElectionID:       "73ee3b1b.thenullchannel.dev",
+		ClientBuilder:          controllers.NewClientBuilder(),
 	})
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
@@ -80,12 +86,22 @@ func main() {
 	}
 
 	if err = (&controllers.LabelerReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+		Client:      mgr.GetClient(),
+		Scheme:      mgr.GetScheme(),
+		Recorder:    mgr.GetEventRecorderFor("labeler"),
+		TargetKinds: splitKinds(targetKinds),
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Labeler")
 		os.Exit(1)
 	}
+	if os.Getenv("ENABLE_WEBHOOKS") != "false" {
+		if err = (&controllers.PodLabeler{
+			Client: mgr.GetClient(),
+		}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "Pod")
+			os.Exit(1)
+		}
+	}
 	//+kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
@@ -103,3 +119,21 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+func joinKinds(kinds []nulllabelerv1.TargetKind) string {
+	names := make([]string, 0, len(kinds))
+	for _, kind := range kinds {
+		names = append(names, string(kind))
+	}
+	return strings.Join(names, ",")
+}
+
+func splitKinds(list string) []nulllabelerv1.TargetKind {
+	var kinds []nulllabelerv1.TargetKind
+	for _, name := range strings.Split(list, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			kinds = append(kinds, nulllabelerv1.TargetKind(name))
+		}
+	}
+	return kinds
+}
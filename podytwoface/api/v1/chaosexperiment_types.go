@@ -0,0 +1,102 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// NOTE: json tags are required.  Any new fields you add must have json tags for the fields to be serialized.
+
+type ExperimentPhase string
+
+const (
+	ExperimentPhasePending  = ExperimentPhase("Pending")
+	ExperimentPhaseRunning  = ExperimentPhase("Running")
+	ExperimentPhaseFinished = ExperimentPhase("Finished")
+)
+
+// ChaosExperimentSpec defines the desired state of ChaosExperiment
+type ChaosExperimentSpec struct {
+	// Policy is the name of the PodyTwoFace policy whose work is being reported on.
+	Policy string `json:"policy"`
+
+	// Duration is how long the experiment runs for.
+	Duration metav1.Duration `json:"duration"`
+}
+
+// ChaosExperimentStatus defines the observed state of ChaosExperiment
+type ChaosExperimentStatus struct {
+	Phase   ExperimentPhase `json:"phase,omitempty"`
+	Message string          `json:"message,omitempty"`
+
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+	EndTime   *metav1.Time `json:"endTime,omitempty"`
+
+	// Considered is the number of pods the policy flipped a coin for.
+	Considered int64 `json:"considered,omitempty"`
+	// Spared is the number of pods that won the coin flip.
+	Spared int64 `json:"spared,omitempty"`
+	// Killed is the number of pods that had the fault of the policy injected.
+	Killed int64 `json:"killed,omitempty"`
+	// EvictionBlocked is the number of evictions refused by a PodDisruptionBudget.
+	EvictionBlocked int64 `json:"evictionBlocked,omitempty"`
+
+	// Recoveries tracks how long the workloads that were hit took to get back to their desired replicas.
+	Recoveries []WorkloadRecovery `json:"recoveries,omitempty"`
+
+	// Report is the name of the ConfigMap holding the final report.
+	Report string `json:"report,omitempty"`
+}
+
+// WorkloadRecovery is the recovery of a workload from one hit.
+type WorkloadRecovery struct {
+	// Workload is the hit workload, as namespace/kind/name.
+	Workload string      `json:"workload"`
+	HitAt    metav1.Time `json:"hitAt"`
+	// RecoveredAt is when the workload was first seen back at its desired replicas.
+	RecoveredAt *metav1.Time `json:"recoveredAt,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Policy",type=string,JSONPath=`.spec.policy`
+//+kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+//+kubebuilder:printcolumn:name="Killed",type=integer,JSONPath=`.status.killed`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// ChaosExperiment is the Schema for the chaosexperiments API
+type ChaosExperiment struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ChaosExperimentSpec   `json:"spec,omitempty"`
+	Status ChaosExperimentStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// ChaosExperimentList contains a list of ChaosExperiment
+type ChaosExperimentList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ChaosExperiment `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ChaosExperiment{}, &ChaosExperimentList{})
+}
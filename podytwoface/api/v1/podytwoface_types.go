@@ -0,0 +1,352 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// NOTE: json tags are required.  Any new fields you add must have json tags for the fields to be serialized.
+
+// FaultType is the face a pod gets to see when it loses the coin flip.
+//+kubebuilder:validation:Enum=Delete;Evict;ContainerKill;LabelFlip;ReadinessSabotage;Stress;NetworkIsolation
+type FaultType string
+
+const (
+	// FaultDelete deletes the pod outright.
+	FaultDelete = FaultType("Delete")
+	// FaultEvict evicts the pod through the eviction API, honouring PodDisruptionBudgets.
+	FaultEvict = FaultType("Evict")
+	// FaultContainerKill signals the main process of a container from an ephemeral container.
+	FaultContainerKill = FaultType("ContainerKill")
+	// FaultLabelFlip changes label values so Services stop sending traffic to the pod.
+	FaultLabelFlip = FaultType("LabelFlip")
+	// FaultReadinessSabotage annotates the pod so a cooperating sidecar fails its readiness probe.
+	FaultReadinessSabotage = FaultType("ReadinessSabotage")
+	// FaultStress burns CPU and memory in the pod from an ephemeral container.
+	FaultStress = FaultType("Stress")
+	// FaultNetworkIsolation cuts the pod off the network with a deny-all NetworkPolicy for a while.
+	FaultNetworkIsolation = FaultType("NetworkIsolation")
+)
+
+// ReadinessSabotageAnnotation is set to "true" on pods hit by FaultReadinessSabotage.
+// Sidecars that want to take part read it through the downward API and fail readiness while it is set.
+const ReadinessSabotageAnnotation = "nullpodytwoface.thenullchannel.dev/sabotage-readiness"
+
+// FlippedLabelsAnnotation holds the original values of labels changed by FaultLabelFlip, as JSON.
+const FlippedLabelsAnnotation = "nullpodytwoface.thenullchannel.dev/flipped-labels"
+
+// ImmuneAnnotation set to "true" on a Pod, one of its owners or its Namespace keeps every policy away from the pod.
+// Nodes carrying it are never drained.
+const ImmuneAnnotation = "nullpodytwoface.thenullchannel.dev/immune"
+
+// VolunteerLabel set to "true" on a Namespace offers its pods to policies in OptIn mode.
+const VolunteerLabel = "nullpodytwoface.thenullchannel.dev/volunteer"
+
+// TargetingMode is how a policy treats namespaces that did not say anything.
+//+kubebuilder:validation:Enum=OptOut;OptIn
+type TargetingMode string
+
+const (
+	// TargetingOptOut targets every selected pod that is not immune.
+	TargetingOptOut = TargetingMode("OptOut")
+	// TargetingOptIn only targets selected pods in namespaces carrying the VolunteerLabel.
+	TargetingOptIn = TargetingMode("OptIn")
+)
+
+// TargetKind is what a policy flips coins for.
+//+kubebuilder:validation:Enum=Pod;Node
+type TargetKind string
+
+const (
+	TargetPod  = TargetKind("Pod")
+	TargetNode = TargetKind("Node")
+)
+
+// IsolatedLabel is stamped on pods hit by FaultNetworkIsolation, with the UID of the pod as value.
+// The NetworkPolicy isolating the pod selects it.
+const IsolatedLabel = "nullpodytwoface.thenullchannel.dev/isolated"
+
+// IsolationLabel is set to "true" on the NetworkPolicies created by FaultNetworkIsolation.
+const IsolationLabel = "nullpodytwoface.thenullchannel.dev/isolation"
+
+// ExpiresAtAnnotation holds when a NetworkPolicy created by FaultNetworkIsolation is removed, in RFC 3339.
+const ExpiresAtAnnotation = "nullpodytwoface.thenullchannel.dev/expires-at"
+
+// DecisionAnnotation holds the Decision a policy made for a pod, as JSON.
+const DecisionAnnotation = "nullpodytwoface.thenullchannel.dev/decision"
+
+// Verdict is the outcome of the coin flip for a pod.
+type Verdict string
+
+const (
+	VerdictSpared    = Verdict("Spared")
+	VerdictCondemned = Verdict("Condemned")
+)
+
+// Decision is what a policy decided for a pod. It is recorded on the pod so the
+// coin is flipped once per pod, not once per pod event.
+type Decision struct {
+	Verdict   Verdict     `json:"verdict"`
+	Policy    string      `json:"policy"`
+	DecidedAt metav1.Time `json:"decidedAt"`
+	// ExecutedAt is when the fault of a condemned pod was injected.
+	// Condemned pods without it are still waiting on the limits of the policy.
+	ExecutedAt *metav1.Time `json:"executedAt,omitempty"`
+}
+
+// PodyTwoFaceSpec defines the desired state of PodyTwoFace
+type PodyTwoFaceSpec struct {
+	// Target is what the policy hits. Pod policies inject Fault into the pods they select.
+	// Node policies cordon and drain a random node picked by NodeSelector instead.
+	//+kubebuilder:default=Pod
+	Target TargetKind `json:"target,omitempty"`
+
+	// Selector picks the pods this policy flips a coin for. An empty selector matches every pod.
+	Selector *metav1.LabelSelector `json:"selector,omitempty"`
+
+	// NamespaceSelector limits the policy to pods in matching namespaces. An empty selector matches every namespace.
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+
+	// NodeSelector picks the nodes a Node policy may drain. An empty selector matches every node.
+	NodeSelector *metav1.LabelSelector `json:"nodeSelector,omitempty"`
+
+	// NodeOutage is how long a Node policy keeps its node cordoned, drained or not. Defaults to ten minutes.
+	NodeOutage *metav1.Duration `json:"nodeOutage,omitempty"`
+
+	// Targeting decides whether namespaces have to volunteer. Immune pods are skipped either way.
+	//+kubebuilder:default=OptOut
+	Targeting TargetingMode `json:"targeting,omitempty"`
+
+	// Fault is what happens to pods that lose the coin flip. Defaults to deleting them.
+	Fault FaultSpec `json:"fault,omitempty"`
+
+	// Limits caps how much damage the policy is allowed to do.
+	Limits PodyTwoFaceLimits `json:"limits,omitempty"`
+
+	// SteadyState declares what the system has to look like for the chaos to go on.
+	// When a check fails the policy is aborted and stops hitting pods.
+	SteadyState *SteadyStateSpec `json:"steadyState,omitempty"`
+
+	// AllowCriticalPods lets the policy hit critical pods: pods in kube-system, mirror pods,
+	// DaemonSet pods and pods of single-replica Deployments. Otherwise the admission webhook
+	// refuses policies whose NamespaceSelector takes in kube-system, or that select critical
+	// pods, and pods that become critical later are skipped.
+	AllowCriticalPods bool `json:"allowCriticalPods,omitempty"`
+
+	// ReRollInterval lets pods that are still around flip the coin again this long after
+	// their last flip. By default every pod gets exactly one flip.
+	ReRollInterval *metav1.Duration `json:"reRollInterval,omitempty"`
+}
+
+// FaultSpec describes the fault injected into a pod.
+type FaultSpec struct {
+	//+kubebuilder:default=Delete
+	Type FaultType `json:"type,omitempty"`
+
+	// Container is the container to kill or stress. Defaults to the first container of the pod.
+	Container string `json:"container,omitempty"`
+
+	// Image runs the ephemeral container of ContainerKill and Stress faults.
+	// Ephemeral containers need the EphemeralContainers feature gate on the cluster.
+	Image string `json:"image,omitempty"`
+
+	// Signal is sent to the main process of the container by ContainerKill. Defaults to TERM,
+	// as the main process of a container ignores KILL from inside its own PID namespace.
+	Signal string `json:"signal,omitempty"`
+
+	// Labels are the label keys LabelFlip changes the value of. Pick labels your Services select
+	// on but your workload controllers do not, or they will replace the pod as well.
+	Labels []string `json:"labels,omitempty"`
+
+	// Stress sizes the Stress fault.
+	Stress *StressSpec `json:"stress,omitempty"`
+
+	// Isolation sizes the NetworkIsolation fault.
+	Isolation *IsolationSpec `json:"isolation,omitempty"`
+}
+
+// IsolationSpec sizes a NetworkIsolation fault.
+type IsolationSpec struct {
+	// TTL is how long the pod stays cut off. Defaults to five minutes.
+	TTL *metav1.Duration `json:"ttl,omitempty"`
+}
+
+// StressSpec sizes a Stress fault.
+type StressSpec struct {
+	// CPUWorkers is the number of workers spinning on CPU.
+	//+kubebuilder:validation:Minimum=0
+	CPUWorkers int32 `json:"cpuWorkers,omitempty"`
+
+	// Memory is the amount of memory to allocate and keep touching.
+	Memory *resource.Quantity `json:"memory,omitempty"`
+
+	// Duration is how long the stress lasts. Defaults to one minute.
+	Duration *metav1.Duration `json:"duration,omitempty"`
+}
+
+// SteadyStateSpec lists the steady-state checks of a policy.
+type SteadyStateSpec struct {
+	// Interval is the time between two rounds of checks. Defaults to 30s.
+	Interval *metav1.Duration `json:"interval,omitempty"`
+
+	Checks []SteadyStateCheck `json:"checks"`
+}
+
+// SteadyStateCheck is a single steady-state check. Exactly one of Deployment, HTTP or Query is set.
+type SteadyStateCheck struct {
+	// Name identifies the check in the Aborted condition.
+	Name string `json:"name"`
+
+	Deployment *DeploymentCheck `json:"deployment,omitempty"`
+	HTTP       *HTTPCheck       `json:"http,omitempty"`
+	Query      *QueryCheck      `json:"query,omitempty"`
+}
+
+// DeploymentCheck passes while a Deployment has at least MinAvailable available replicas.
+type DeploymentCheck struct {
+	Namespace    string `json:"namespace"`
+	Name         string `json:"name"`
+	MinAvailable int32  `json:"minAvailable"`
+}
+
+// HTTPCheck passes while a GET on URL answers with a 2xx status.
+type HTTPCheck struct {
+	URL string `json:"url"`
+
+	// Timeout of the request. Defaults to 5s.
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+}
+
+// QueryCheck passes while a Prometheus-style instant query returns a single value within bounds.
+type QueryCheck struct {
+	Query string `json:"query"`
+
+	Min *resource.Quantity `json:"min,omitempty"`
+	Max *resource.Quantity `json:"max,omitempty"`
+}
+
+// PodyTwoFaceLimits are the blast-radius caps of a policy. A zero value means no cap.
+type PodyTwoFaceLimits struct {
+	// MaxKillsPerMinute is the number of faults the policy may inject in any rolling minute.
+	//+kubebuilder:validation:Minimum=0
+	MaxKillsPerMinute int32 `json:"maxKillsPerMinute,omitempty"`
+
+	// MaxUnavailablePerNamespace stops killing in a namespace while this many of its pods are unavailable.
+	//+kubebuilder:validation:Minimum=0
+	MaxUnavailablePerNamespace int32 `json:"maxUnavailablePerNamespace,omitempty"`
+
+	// MaxUnavailablePerOwner stops killing pods of an owner while this many of its pods are unavailable.
+	//+kubebuilder:validation:Minimum=0
+	MaxUnavailablePerOwner int32 `json:"maxUnavailablePerOwner,omitempty"`
+
+	// Cooldown is the minimum time between two kills in the same workload.
+	Cooldown *metav1.Duration `json:"cooldown,omitempty"`
+}
+
+// ConditionAborted is true once a steady-state check failed. Aborted policies do
+// not hit pods until their spec is changed.
+const ConditionAborted = "Aborted"
+
+// PodyTwoFaceStatus defines the observed state of PodyTwoFace
+type PodyTwoFaceStatus struct {
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+
+	// RecentKills are the times of the faults injected in the last minute.
+	RecentKills []metav1.Time `json:"recentKills,omitempty"`
+
+	// Workloads are the workloads still cooling down after a kill.
+	Workloads []WorkloadKill `json:"workloads,omitempty"`
+
+	// Kills is the total number of faults injected by this policy.
+	Kills int64 `json:"kills,omitempty"`
+
+	// Node is the progress of the current, or last, node outage of a Node policy.
+	Node *NodeChaosStatus `json:"node,omitempty"`
+}
+
+type NodeChaosPhase string
+
+const (
+	NodeChaosDraining   = NodeChaosPhase("Draining")
+	NodeChaosDrained    = NodeChaosPhase("Drained")
+	NodeChaosUncordoned = NodeChaosPhase("Uncordoned")
+)
+
+// NodeChaosStatus is the progress of a node outage.
+type NodeChaosStatus struct {
+	// Decision is the last coin flip of the policy. Node policies flip once, or
+	// once every ReRollInterval, for the whole cluster.
+	Decision Decision `json:"decision"`
+
+	// Node is the node taken out. Empty when the flip spared the cluster or no node could be picked.
+	Node  string         `json:"node,omitempty"`
+	Phase NodeChaosPhase `json:"phase,omitempty"`
+
+	// UncordonAt is when the node is given back.
+	UncordonAt *metav1.Time `json:"uncordonAt,omitempty"`
+
+	// Evicted is the number of pods evicted from the node.
+	Evicted int32 `json:"evicted,omitempty"`
+	// Remaining is the number of pods still to be moved off the node.
+	Remaining int32 `json:"remaining,omitempty"`
+	// Blocked lists the pods, as namespace/name, whose eviction a
+	// PodDisruptionBudget has blocked during this outage.
+	Blocked []string `json:"blocked,omitempty"`
+
+	Message string `json:"message,omitempty"`
+}
+
+// WorkloadKill records the last time a pod of a workload was hit.
+type WorkloadKill struct {
+	// Workload is the top-level owner of the pod, as namespace/kind/name.
+	Workload string `json:"workload"`
+
+	LastKillTime metav1.Time `json:"lastKillTime"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:resource:scope=Cluster
+//+kubebuilder:printcolumn:name="Target",type=string,JSONPath=`.spec.target`
+//+kubebuilder:printcolumn:name="Fault",type=string,JSONPath=`.spec.fault.type`
+//+kubebuilder:printcolumn:name="Kills",type=integer,JSONPath=`.status.kills`
+//+kubebuilder:printcolumn:name="Aborted",type=string,JSONPath=`.status.conditions[?(@.type=="Aborted")].status`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// PodyTwoFace is the Schema for the podytwofaces API
+type PodyTwoFace struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   PodyTwoFaceSpec   `json:"spec,omitempty"`
+	Status PodyTwoFaceStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// PodyTwoFaceList contains a list of PodyTwoFace
+type PodyTwoFaceList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []PodyTwoFace `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&PodyTwoFace{}, &PodyTwoFaceList{})
+}
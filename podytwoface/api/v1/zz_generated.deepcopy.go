@@ -0,0 +1,571 @@
+// +build !ignore_autogenerated
+
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Code generated by controller-gen. DO NOT EDIT.
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	runtime "k8s.io/apimachinery/pkg/runtime"
+)
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ChaosExperiment) DeepCopyInto(out *ChaosExperiment) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	out.Spec = in.Spec
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ChaosExperiment.
+func (in *ChaosExperiment) DeepCopy() *ChaosExperiment {
+	if in == nil {
+		return nil
+	}
+	out := new(ChaosExperiment)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ChaosExperiment) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ChaosExperimentList) DeepCopyInto(out *ChaosExperimentList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]ChaosExperiment, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ChaosExperimentList.
+func (in *ChaosExperimentList) DeepCopy() *ChaosExperimentList {
+	if in == nil {
+		return nil
+	}
+	out := new(ChaosExperimentList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ChaosExperimentList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ChaosExperimentSpec) DeepCopyInto(out *ChaosExperimentSpec) {
+	*out = *in
+	out.Duration = in.Duration
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ChaosExperimentSpec.
+func (in *ChaosExperimentSpec) DeepCopy() *ChaosExperimentSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ChaosExperimentSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ChaosExperimentStatus) DeepCopyInto(out *ChaosExperimentStatus) {
+	*out = *in
+	if in.StartTime != nil {
+		in, out := &in.StartTime, &out.StartTime
+		*out = (*in).DeepCopy()
+	}
+	if in.EndTime != nil {
+		in, out := &in.EndTime, &out.EndTime
+		*out = (*in).DeepCopy()
+	}
+	if in.Recoveries != nil {
+		in, out := &in.Recoveries, &out.Recoveries
+		*out = make([]WorkloadRecovery, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ChaosExperimentStatus.
+func (in *ChaosExperimentStatus) DeepCopy() *ChaosExperimentStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ChaosExperimentStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Decision) DeepCopyInto(out *Decision) {
+	*out = *in
+	in.DecidedAt.DeepCopyInto(&out.DecidedAt)
+	if in.ExecutedAt != nil {
+		in, out := &in.ExecutedAt, &out.ExecutedAt
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Decision.
+func (in *Decision) DeepCopy() *Decision {
+	if in == nil {
+		return nil
+	}
+	out := new(Decision)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DeploymentCheck) DeepCopyInto(out *DeploymentCheck) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DeploymentCheck.
+func (in *DeploymentCheck) DeepCopy() *DeploymentCheck {
+	if in == nil {
+		return nil
+	}
+	out := new(DeploymentCheck)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FaultSpec) DeepCopyInto(out *FaultSpec) {
+	*out = *in
+	if in.Labels != nil {
+		in, out := &in.Labels, &out.Labels
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Stress != nil {
+		in, out := &in.Stress, &out.Stress
+		*out = new(StressSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Isolation != nil {
+		in, out := &in.Isolation, &out.Isolation
+		*out = new(IsolationSpec)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FaultSpec.
+func (in *FaultSpec) DeepCopy() *FaultSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(FaultSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HTTPCheck) DeepCopyInto(out *HTTPCheck) {
+	*out = *in
+	if in.Timeout != nil {
+		in, out := &in.Timeout, &out.Timeout
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HTTPCheck.
+func (in *HTTPCheck) DeepCopy() *HTTPCheck {
+	if in == nil {
+		return nil
+	}
+	out := new(HTTPCheck)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IsolationSpec) DeepCopyInto(out *IsolationSpec) {
+	*out = *in
+	if in.TTL != nil {
+		in, out := &in.TTL, &out.TTL
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IsolationSpec.
+func (in *IsolationSpec) DeepCopy() *IsolationSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(IsolationSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodeChaosStatus) DeepCopyInto(out *NodeChaosStatus) {
+	*out = *in
+	in.Decision.DeepCopyInto(&out.Decision)
+	if in.UncordonAt != nil {
+		in, out := &in.UncordonAt, &out.UncordonAt
+		*out = (*in).DeepCopy()
+	}
+	if in.Blocked != nil {
+		in, out := &in.Blocked, &out.Blocked
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeChaosStatus.
+func (in *NodeChaosStatus) DeepCopy() *NodeChaosStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(NodeChaosStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PodyTwoFace) DeepCopyInto(out *PodyTwoFace) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PodyTwoFace.
+func (in *PodyTwoFace) DeepCopy() *PodyTwoFace {
+	if in == nil {
+		return nil
+	}
+	out := new(PodyTwoFace)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *PodyTwoFace) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PodyTwoFaceLimits) DeepCopyInto(out *PodyTwoFaceLimits) {
+	*out = *in
+	if in.Cooldown != nil {
+		in, out := &in.Cooldown, &out.Cooldown
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PodyTwoFaceLimits.
+func (in *PodyTwoFaceLimits) DeepCopy() *PodyTwoFaceLimits {
+	if in == nil {
+		return nil
+	}
+	out := new(PodyTwoFaceLimits)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PodyTwoFaceList) DeepCopyInto(out *PodyTwoFaceList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]PodyTwoFace, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PodyTwoFaceList.
+func (in *PodyTwoFaceList) DeepCopy() *PodyTwoFaceList {
+	if in == nil {
+		return nil
+	}
+	out := new(PodyTwoFaceList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *PodyTwoFaceList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PodyTwoFaceSpec) DeepCopyInto(out *PodyTwoFaceSpec) {
+	*out = *in
+	if in.Selector != nil {
+		in, out := &in.Selector, &out.Selector
+		*out = new(metav1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.NamespaceSelector != nil {
+		in, out := &in.NamespaceSelector, &out.NamespaceSelector
+		*out = new(metav1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.NodeSelector != nil {
+		in, out := &in.NodeSelector, &out.NodeSelector
+		*out = new(metav1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.NodeOutage != nil {
+		in, out := &in.NodeOutage, &out.NodeOutage
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	in.Fault.DeepCopyInto(&out.Fault)
+	in.Limits.DeepCopyInto(&out.Limits)
+	if in.SteadyState != nil {
+		in, out := &in.SteadyState, &out.SteadyState
+		*out = new(SteadyStateSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ReRollInterval != nil {
+		in, out := &in.ReRollInterval, &out.ReRollInterval
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PodyTwoFaceSpec.
+func (in *PodyTwoFaceSpec) DeepCopy() *PodyTwoFaceSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(PodyTwoFaceSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PodyTwoFaceStatus) DeepCopyInto(out *PodyTwoFaceStatus) {
+	*out = *in
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]metav1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.RecentKills != nil {
+		in, out := &in.RecentKills, &out.RecentKills
+		*out = make([]metav1.Time, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Workloads != nil {
+		in, out := &in.Workloads, &out.Workloads
+		*out = make([]WorkloadKill, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Node != nil {
+		in, out := &in.Node, &out.Node
+		*out = new(NodeChaosStatus)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PodyTwoFaceStatus.
+func (in *PodyTwoFaceStatus) DeepCopy() *PodyTwoFaceStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(PodyTwoFaceStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *QueryCheck) DeepCopyInto(out *QueryCheck) {
+	*out = *in
+	if in.Min != nil {
+		in, out := &in.Min, &out.Min
+		x := (*in).DeepCopy()
+		*out = &x
+	}
+	if in.Max != nil {
+		in, out := &in.Max, &out.Max
+		x := (*in).DeepCopy()
+		*out = &x
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new QueryCheck.
+func (in *QueryCheck) DeepCopy() *QueryCheck {
+	if in == nil {
+		return nil
+	}
+	out := new(QueryCheck)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SteadyStateCheck) DeepCopyInto(out *SteadyStateCheck) {
+	*out = *in
+	if in.Deployment != nil {
+		in, out := &in.Deployment, &out.Deployment
+		*out = new(DeploymentCheck)
+		**out = **in
+	}
+	if in.HTTP != nil {
+		in, out := &in.HTTP, &out.HTTP
+		*out = new(HTTPCheck)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Query != nil {
+		in, out := &in.Query, &out.Query
+		*out = new(QueryCheck)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SteadyStateCheck.
+func (in *SteadyStateCheck) DeepCopy() *SteadyStateCheck {
+	if in == nil {
+		return nil
+	}
+	out := new(SteadyStateCheck)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SteadyStateSpec) DeepCopyInto(out *SteadyStateSpec) {
+	*out = *in
+	if in.Interval != nil {
+		in, out := &in.Interval, &out.Interval
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	if in.Checks != nil {
+		in, out := &in.Checks, &out.Checks
+		*out = make([]SteadyStateCheck, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SteadyStateSpec.
+func (in *SteadyStateSpec) DeepCopy() *SteadyStateSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(SteadyStateSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *StressSpec) DeepCopyInto(out *StressSpec) {
+	*out = *in
+	if in.Memory != nil {
+		in, out := &in.Memory, &out.Memory
+		x := (*in).DeepCopy()
+		*out = &x
+	}
+	if in.Duration != nil {
+		in, out := &in.Duration, &out.Duration
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new StressSpec.
+func (in *StressSpec) DeepCopy() *StressSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(StressSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WorkloadKill) DeepCopyInto(out *WorkloadKill) {
+	*out = *in
+	in.LastKillTime.DeepCopyInto(&out.LastKillTime)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WorkloadKill.
+func (in *WorkloadKill) DeepCopy() *WorkloadKill {
+	if in == nil {
+		return nil
+	}
+	out := new(WorkloadKill)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WorkloadRecovery) DeepCopyInto(out *WorkloadRecovery) {
+	*out = *in
+	in.HitAt.DeepCopyInto(&out.HitAt)
+	if in.RecoveredAt != nil {
+		in, out := &in.RecoveredAt, &out.RecoveredAt
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WorkloadRecovery.
+func (in *WorkloadRecovery) DeepCopy() *WorkloadRecovery {
+	if in == nil {
+		return nil
+	}
+	out := new(WorkloadRecovery)
+	in.DeepCopyInto(out)
+	return out
+}
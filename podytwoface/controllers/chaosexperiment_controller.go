@@ -0,0 +1,377 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/util/retry"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	nullpodytwofacev1 "github.com/null-channel/stupid-kube-operators/podytwoface/api/v1"
+)
+
+// experimentPollInterval is how often running experiments check on the
+// workloads that were hit. It is also the resolution of recovery times.
+const experimentPollInterval = 10 * time.Second
+
+// reportKey is the key of the report in the report ConfigMap.
+const reportKey = "report.json"
+
+// outcome is something that happened to a pod, as far as experiments care.
+type outcome string
+
+const (
+	outcomeSpared          = outcome("Spared")
+	outcomeCondemned       = outcome("Condemned")
+	outcomeKilled          = outcome("Killed")
+	outcomeEvictionBlocked = outcome("EvictionBlocked")
+)
+
+// ChaosExperimentReconciler reconciles a ChaosExperiment object
+type ChaosExperimentReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+}
+
+//+kubebuilder:rbac:groups=nullpodytwoface.thenullchannel.dev,resources=chaosexperiments,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=nullpodytwoface.thenullchannel.dev,resources=chaosexperiments/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=nullpodytwoface.thenullchannel.dev,resources=chaosexperiments/finalizers,verbs=update
+//+kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;list;watch;create;update;patch
+
+// Reconcile runs a ChaosExperiment: it starts the clock, checks on the
+// recovery of the workloads hit by the policy while the experiment runs, and
+// writes the final report to status and a ConfigMap when time is up.
+// The counting itself happens in PodyTwoFaceReconciler, see recordOutcome.
+//
+// For more details, check Reconcile and its Result here:
+// - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.8.3/pkg/reconcile
+func (r *ChaosExperimentReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	experiment := &nullpodytwofacev1.ChaosExperiment{}
+	if err := r.Client.Get(ctx, req.NamespacedName, experiment); err != nil {
+		if apierrors.IsNotFound(err) {
+			// Object not found, return.  Created objects are automatically garbage collected.
+			// For additional cleanup logic use finalizers.
+			return ctrl.Result{}, nil
+		}
+		// Error reading the object - requeue the request.
+		return ctrl.Result{}, err
+	}
+
+	if experiment.Status.Phase == nullpodytwofacev1.ExperimentPhaseFinished {
+		return ctrl.Result{}, nil
+	}
+
+	now := time.Now()
+
+	if experiment.Status.Phase != nullpodytwofacev1.ExperimentPhaseRunning {
+		policy := &nullpodytwofacev1.PodyTwoFace{}
+		if err := r.Get(ctx, client.ObjectKey{Name: experiment.Spec.Policy}, policy); err != nil {
+			if !apierrors.IsNotFound(err) {
+				return ctrl.Result{}, err
+			}
+			experiment.Status.Phase = nullpodytwofacev1.ExperimentPhasePending
+			experiment.Status.Message = fmt.Sprintf("waiting for policy %q", experiment.Spec.Policy)
+			return ctrl.Result{RequeueAfter: experimentPollInterval}, r.Status().Update(ctx, experiment)
+		}
+
+		start := metav1.NewTime(now)
+		end := metav1.NewTime(now.Add(experiment.Spec.Duration.Duration))
+		experiment.Status.Phase = nullpodytwofacev1.ExperimentPhaseRunning
+		experiment.Status.Message = ""
+		experiment.Status.StartTime = &start
+		experiment.Status.EndTime = &end
+		logger.Info("experiment started", "policy", experiment.Spec.Policy, "until", end)
+		return ctrl.Result{RequeueAfter: experimentPollInterval}, r.Status().Update(ctx, experiment)
+	}
+
+	changed, err := r.checkRecoveries(ctx, experiment, now)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	if now.Before(experiment.Status.EndTime.Time) {
+		if changed {
+			if err := r.Status().Update(ctx, experiment); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+		wait := experimentPollInterval
+		if left := experiment.Status.EndTime.Sub(now); left < wait {
+			wait = left
+		}
+		return ctrl.Result{RequeueAfter: wait}, nil
+	}
+
+	name, err := r.writeReport(ctx, experiment)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	experiment.Status.Phase = nullpodytwofacev1.ExperimentPhaseFinished
+	experiment.Status.Report = name
+	logger.Info("experiment finished", "policy", experiment.Spec.Policy, "report", name)
+	return ctrl.Result{}, r.Status().Update(ctx, experiment)
+}
+
+// checkRecoveries marks the workloads that are back at their desired replicas as recovered.
+// Hits younger than a poll interval are left alone, to give the workload controller
+// a chance to notice the hit before we ask it about it.
+func (r *ChaosExperimentReconciler) checkRecoveries(ctx context.Context, experiment *nullpodytwofacev1.ChaosExperiment, now time.Time) (bool, error) {
+	changed := false
+	for i := range experiment.Status.Recoveries {
+		recovery := &experiment.Status.Recoveries[i]
+		if recovery.RecoveredAt != nil || now.Sub(recovery.HitAt.Time) < experimentPollInterval {
+			continue
+		}
+
+		recovered, err := r.workloadRecovered(ctx, recovery.Workload)
+		if err != nil {
+			return changed, err
+		}
+
+		if recovered {
+			at := metav1.NewTime(now)
+			recovery.RecoveredAt = &at
+			changed = true
+		}
+	}
+	return changed, nil
+}
+
+// workloadRecovered reports whether workload (namespace/kind/name) has all its
+// desired replicas available again, or, for nodes, whether the node is
+// schedulable and ready again. Workloads that do not keep a replica count,
+// like bare pods and jobs, never recover as far as the report is concerned.
+func (r *ChaosExperimentReconciler) workloadRecovered(ctx context.Context, workload string) (bool, error) {
+	parts := strings.SplitN(workload, "/", 3)
+	if len(parts) != 3 {
+		return false, nil
+	}
+	key := client.ObjectKey{Namespace: parts[0], Name: parts[2]}
+
+	var obj client.Object
+	switch parts[1] {
+	case "Deployment":
+		obj = &appsv1.Deployment{}
+	case "StatefulSet":
+		obj = &appsv1.StatefulSet{}
+	case "ReplicaSet":
+		obj = &appsv1.ReplicaSet{}
+	case "DaemonSet":
+		obj = &appsv1.DaemonSet{}
+	case "Node":
+		obj = &v1.Node{}
+	default:
+		return false, nil
+	}
+
+	if err := r.Get(ctx, key, obj); err != nil {
+		if apierrors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	switch o := obj.(type) {
+	case *appsv1.Deployment:
+		return o.Status.AvailableReplicas >= desiredReplicas(o.Spec.Replicas), nil
+	case *appsv1.StatefulSet:
+		return o.Status.ReadyReplicas >= desiredReplicas(o.Spec.Replicas), nil
+	case *appsv1.ReplicaSet:
+		return o.Status.AvailableReplicas >= desiredReplicas(o.Spec.Replicas), nil
+	case *appsv1.DaemonSet:
+		return o.Status.NumberAvailable >= o.Status.DesiredNumberScheduled, nil
+	case *v1.Node:
+		return !o.Spec.Unschedulable && nodeReady(o), nil
+	}
+	return false, nil
+}
+
+func nodeReady(node *v1.Node) bool {
+	for _, c := range node.Status.Conditions {
+		if c.Type == v1.NodeReady {
+			return c.Status == v1.ConditionTrue
+		}
+	}
+	return false
+}
+
+func desiredReplicas(replicas *int32) int32 {
+	if replicas == nil {
+		return 1
+	}
+	return *replicas
+}
+
+// experimentReport is the final report of an experiment, as stored in its ConfigMap.
+type experimentReport struct {
+	Experiment      string      `json:"experiment"`
+	Policy          string      `json:"policy"`
+	StartTime       metav1.Time `json:"startTime"`
+	EndTime         metav1.Time `json:"endTime"`
+	Considered      int64       `json:"considered"`
+	Spared          int64       `json:"spared"`
+	Killed          int64       `json:"killed"`
+	EvictionBlocked int64       `json:"evictionBlocked"`
+
+	Recovered           int     `json:"recovered"`
+	Unrecovered         int     `json:"unrecovered"`
+	MeanRecoverySeconds float64 `json:"meanRecoverySeconds"`
+	MaxRecoverySeconds  float64 `json:"maxRecoverySeconds"`
+
+	Recoveries []nullpodytwofacev1.WorkloadRecovery `json:"recoveries,omitempty"`
+}
+
+// buildReport sums up the status of a finished experiment.
+func buildReport(experiment *nullpodytwofacev1.ChaosExperiment) experimentReport {
+	status := experiment.Status
+	report := experimentReport{
+		Experiment:      experiment.Name,
+		Policy:          experiment.Spec.Policy,
+		StartTime:       *status.StartTime,
+		EndTime:         *status.EndTime,
+		Considered:      status.Considered,
+		Spared:          status.Spared,
+		Killed:          status.Killed,
+		EvictionBlocked: status.EvictionBlocked,
+		Recoveries:      status.Recoveries,
+	}
+
+	total := 0.0
+	for _, recovery := range status.Recoveries {
+		if recovery.RecoveredAt == nil {
+			report.Unrecovered++
+			continue
+		}
+		seconds := recovery.RecoveredAt.Sub(recovery.HitAt.Time).Seconds()
+		total += seconds
+		report.Recovered++
+		if seconds > report.MaxRecoverySeconds {
+			report.MaxRecoverySeconds = seconds
+		}
+	}
+	if report.Recovered > 0 {
+		report.MeanRecoverySeconds = total / float64(report.Recovered)
+	}
+
+	return report
+}
+
+// writeReport stores the final report of experiment in a ConfigMap next to it,
+// owned by the experiment, and returns the name of the ConfigMap.
+func (r *ChaosExperimentReconciler) writeReport(ctx context.Context, experiment *nullpodytwofacev1.ChaosExperiment) (string, error) {
+	data, err := json.MarshalIndent(buildReport(experiment), "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	cm := &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      experiment.Name + "-report",
+			Namespace: experiment.Namespace,
+		},
+	}
+
+	_, err = controllerutil.CreateOrUpdate(ctx, r.Client, cm, func() error {
+		cm.Data = map[string]string{reportKey: string(data)}
+		return controllerutil.SetControllerReference(experiment, cm, r.Scheme)
+	})
+
+	return cm.Name, err
+}
+
+// recordOutcome counts what happened to a pod of workload in every running
+// experiment on policy. Experiments are reports, so failing to update one is
+// logged and otherwise ignored rather than holding up the chaos.
+func recordOutcome(ctx context.Context, c client.Client, policy string, o outcome, workload string, now time.Time) {
+	logger := log.FromContext(ctx)
+
+	experiments := &nullpodytwofacev1.ChaosExperimentList{}
+	if err := c.List(ctx, experiments); err != nil {
+		logger.Error(err, "unable to list experiments")
+		return
+	}
+
+	for _, e := range experiments.Items {
+		if e.Spec.Policy != policy || !experimentRunning(&e, now) {
+			continue
+		}
+
+		key := client.ObjectKeyFromObject(&e)
+		err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+			experiment := &nullpodytwofacev1.ChaosExperiment{}
+			if err := c.Get(ctx, key, experiment); err != nil {
+				return err
+			}
+			if !experimentRunning(experiment, now) {
+				return nil
+			}
+
+			status := &experiment.Status
+			switch o {
+			case outcomeSpared:
+				status.Considered++
+				status.Spared++
+			case outcomeCondemned:
+				status.Considered++
+			case outcomeKilled:
+				status.Killed++
+				status.Recoveries = append(status.Recoveries, nullpodytwofacev1.WorkloadRecovery{
+					Workload: workload,
+					HitAt:    metav1.NewTime(now),
+				})
+			case outcomeEvictionBlocked:
+				status.EvictionBlocked++
+			}
+
+			return c.Status().Update(ctx, experiment)
+		})
+		if err != nil {
+			logger.Error(err, "unable to record outcome in experiment", "experiment", key, "outcome", o)
+		}
+	}
+}
+
+// experimentRunning reports whether experiment is counting at now.
+func experimentRunning(experiment *nullpodytwofacev1.ChaosExperiment, now time.Time) bool {
+	status := experiment.Status
+	return status.Phase == nullpodytwofacev1.ExperimentPhaseRunning &&
+		status.EndTime != nil && now.Before(status.EndTime.Time)
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *ChaosExperimentReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&nullpodytwofacev1.ChaosExperiment{}).
+		Owns(&v1.ConfigMap{}).
+		Complete(r)
+}
@@ -0,0 +1,194 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	nullpodytwofacev1 "github.com/null-channel/stupid-kube-operators/podytwoface/api/v1"
+)
+
+// runningExperiment returns an experiment on policy that runs until end.
+func runningExperiment(name, policy string, end time.Time) *nullpodytwofacev1.ChaosExperiment {
+	start, until := metav1.NewTime(end.Add(-time.Hour)), metav1.NewTime(end)
+	return &nullpodytwofacev1.ChaosExperiment{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec:       nullpodytwofacev1.ChaosExperimentSpec{Policy: policy, Duration: metav1.Duration{Duration: time.Hour}},
+		Status: nullpodytwofacev1.ChaosExperimentStatus{
+			Phase:     nullpodytwofacev1.ExperimentPhaseRunning,
+			StartTime: &start,
+			EndTime:   &until,
+		},
+	}
+}
+
+func TestRecordOutcome(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+
+	counting := runningExperiment("counting", "web", now.Add(time.Hour))
+	otherPolicy := runningExperiment("other-policy", "db", now.Add(time.Hour))
+	over := runningExperiment("over", "web", now.Add(-time.Second))
+	finished := runningExperiment("finished", "web", now.Add(time.Hour))
+	finished.Status.Phase = nullpodytwofacev1.ExperimentPhaseFinished
+
+	c := fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(counting, otherPolicy, over, finished).Build()
+
+	for _, o := range []outcome{outcomeCondemned, outcomeSpared, outcomeCondemned, outcomeKilled, outcomeEvictionBlocked} {
+		recordOutcome(ctx, c, "web", o, "default/Deployment/web", now)
+	}
+
+	got := &nullpodytwofacev1.ChaosExperiment{}
+	if err := c.Get(ctx, client.ObjectKeyFromObject(counting), got); err != nil {
+		t.Fatal(err)
+	}
+	status := got.Status
+	if status.Considered != 3 || status.Spared != 1 || status.Killed != 1 || status.EvictionBlocked != 1 {
+		t.Errorf("counts = considered %d, spared %d, killed %d, eviction blocked %d, want 3, 1, 1, 1",
+			status.Considered, status.Spared, status.Killed, status.EvictionBlocked)
+	}
+	if len(status.Recoveries) != 1 || status.Recoveries[0].Workload != "default/Deployment/web" {
+		t.Errorf("recoveries = %+v, want the killed workload", status.Recoveries)
+	}
+
+	for _, e := range []*nullpodytwofacev1.ChaosExperiment{otherPolicy, over, finished} {
+		got := &nullpodytwofacev1.ChaosExperiment{}
+		if err := c.Get(ctx, client.ObjectKeyFromObject(e), got); err != nil {
+			t.Fatal(err)
+		}
+		if got.Status.Considered != 0 || got.Status.Killed != 0 || len(got.Status.Recoveries) != 0 {
+			t.Errorf("experiment %s counted outcomes: %+v", e.Name, got.Status)
+		}
+	}
+}
+
+func TestBuildReport(t *testing.T) {
+	hit := metav1.NewTime(time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC))
+	after := func(d time.Duration) *metav1.Time {
+		at := metav1.NewTime(hit.Add(d))
+		return &at
+	}
+
+	experiment := runningExperiment("report", "web", hit.Add(time.Hour))
+	experiment.Status.Considered, experiment.Status.Spared, experiment.Status.Killed = 4, 1, 3
+	experiment.Status.Recoveries = []nullpodytwofacev1.WorkloadRecovery{
+		{Workload: "default/Deployment/web", HitAt: hit, RecoveredAt: after(10 * time.Second)},
+		{Workload: "default/Deployment/web", HitAt: hit, RecoveredAt: after(30 * time.Second)},
+		{Workload: "default/Pod/lonely", HitAt: hit},
+	}
+
+	report := buildReport(experiment)
+	if report.Recovered != 2 || report.Unrecovered != 1 {
+		t.Errorf("recovered %d, unrecovered %d, want 2, 1", report.Recovered, report.Unrecovered)
+	}
+	if report.MeanRecoverySeconds != 20 || report.MaxRecoverySeconds != 30 {
+		t.Errorf("mean %v, max %v, want 20, 30", report.MeanRecoverySeconds, report.MaxRecoverySeconds)
+	}
+	if report.Considered != 4 || report.Spared != 1 || report.Killed != 3 {
+		t.Errorf("report counts = %+v, want those of the status", report)
+	}
+}
+
+func TestChaosExperimentReconcile(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+
+	experiment := &nullpodytwofacev1.ChaosExperiment{
+		ObjectMeta: metav1.ObjectMeta{Name: "run", Namespace: "default"},
+		Spec:       nullpodytwofacev1.ChaosExperimentSpec{Policy: "web", Duration: metav1.Duration{Duration: time.Hour}},
+	}
+	replicas := int32(2)
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		Spec:       appsv1.DeploymentSpec{Replicas: &replicas},
+		Status:     appsv1.DeploymentStatus{AvailableReplicas: 2},
+	}
+	c := fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(experiment, deployment).Build()
+	r := &ChaosExperimentReconciler{Client: c, Scheme: c.Scheme()}
+
+	reconcile := func() *nullpodytwofacev1.ChaosExperiment {
+		t.Helper()
+		if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(experiment)}); err != nil {
+			t.Fatal(err)
+		}
+		got := &nullpodytwofacev1.ChaosExperiment{}
+		if err := c.Get(ctx, client.ObjectKeyFromObject(experiment), got); err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+
+	if got := reconcile(); got.Status.Phase != nullpodytwofacev1.ExperimentPhasePending {
+		t.Fatalf("phase = %q without a policy, want Pending", got.Status.Phase)
+	}
+
+	if err := c.Create(ctx, &nullpodytwofacev1.PodyTwoFace{ObjectMeta: metav1.ObjectMeta{Name: "web"}}); err != nil {
+		t.Fatal(err)
+	}
+	got := reconcile()
+	if got.Status.Phase != nullpodytwofacev1.ExperimentPhaseRunning || got.Status.EndTime == nil {
+		t.Fatalf("status = %+v, want Running with an end time", got.Status)
+	}
+
+	// A hit long enough ago to be checked on, on a workload that is back.
+	got.Status.Recoveries = []nullpodytwofacev1.WorkloadRecovery{
+		{Workload: "default/Deployment/web", HitAt: metav1.NewTime(now.Add(-time.Minute))},
+	}
+	if err := c.Status().Update(ctx, got); err != nil {
+		t.Fatal(err)
+	}
+	got = reconcile()
+	if got.Status.Recoveries[0].RecoveredAt == nil {
+		t.Fatalf("recovery = %+v, want the deployment recovered", got.Status.Recoveries[0])
+	}
+
+	// Time is up.
+	ended := metav1.NewTime(now.Add(-time.Second))
+	got.Status.EndTime = &ended
+	if err := c.Status().Update(ctx, got); err != nil {
+		t.Fatal(err)
+	}
+	got = reconcile()
+	if got.Status.Phase != nullpodytwofacev1.ExperimentPhaseFinished || got.Status.Report == "" {
+		t.Fatalf("status = %+v, want Finished with a report", got.Status)
+	}
+
+	cm := &v1.ConfigMap{}
+	if err := c.Get(ctx, client.ObjectKey{Namespace: "default", Name: got.Status.Report}, cm); err != nil {
+		t.Fatal(err)
+	}
+	report := experimentReport{}
+	if err := json.Unmarshal([]byte(cm.Data[reportKey]), &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Policy != "web" || report.Recovered != 1 {
+		t.Errorf("report = %+v, want one recovery on policy web", report)
+	}
+	if ref := metav1.GetControllerOf(cm); ref == nil || ref.Name != experiment.Name {
+		t.Errorf("report owned by %+v, want the experiment", ref)
+	}
+}
@@ -0,0 +1,115 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"math/rand"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	nullpodytwofacev1 "github.com/null-channel/stupid-kube-operators/podytwoface/api/v1"
+)
+
+// decisionOf returns the decision policy recorded on pod, or nil if policy has
+// not decided anything about pod yet. Decisions of other policies do not count:
+// a pod that moves to another policy gets a flip from that policy.
+func decisionOf(pod *v1.Pod, policy *nullpodytwofacev1.PodyTwoFace) *nullpodytwofacev1.Decision {
+	data, ok := pod.Annotations[nullpodytwofacev1.DecisionAnnotation]
+	if !ok {
+		return nil
+	}
+
+	decision := &nullpodytwofacev1.Decision{}
+	if err := json.Unmarshal([]byte(data), decision); err != nil {
+		// Somebody has been editing annotations by hand. Flip again.
+		return nil
+	}
+
+	if decision.Policy != policy.Name {
+		return nil
+	}
+
+	return decision
+}
+
+// nextRoll returns when the pod behind decision may flip the coin again, and
+// false if it never may. Condemned pods still waiting for their fault are not
+// done with their current flip.
+func nextRoll(decision *nullpodytwofacev1.Decision, policy *nullpodytwofacev1.PodyTwoFace) (time.Time, bool) {
+	if decision.Verdict == nullpodytwofacev1.VerdictCondemned && decision.ExecutedAt == nil {
+		return time.Time{}, false
+	}
+
+	if policy.Spec.ReRollInterval == nil {
+		return time.Time{}, false
+	}
+
+	return decision.DecidedAt.Add(policy.Spec.ReRollInterval.Duration), true
+}
+
+// roll flips the coin for pod.
+func roll(policy *nullpodytwofacev1.PodyTwoFace, now time.Time) *nullpodytwofacev1.Decision {
+	decision := &nullpodytwofacev1.Decision{
+		Verdict:   nullpodytwofacev1.VerdictSpared,
+		Policy:    policy.Name,
+		DecidedAt: metav1.NewTime(now),
+	}
+
+	face := rand.Intn(3)
+
+	if face > 1 {
+		decision.Verdict = nullpodytwofacev1.VerdictCondemned
+	}
+
+	return decision
+}
+
+// recordDecision writes decision to the annotations of pod. The patch fails
+// with a conflict if pod changed since it was read, so that a reconcile
+// working from a stale copy, which does not see a decision made since, never
+// makes a second one.
+func (r *PodyTwoFaceReconciler) recordDecision(ctx context.Context, pod *v1.Pod, decision *nullpodytwofacev1.Decision) error {
+	return r.patchDecision(ctx, pod, decision, client.MergeFromWithOptimisticLock{})
+}
+
+// takeBackExecution records decision, whose fault could not be injected, as
+// not executed. The execution was recorded on pod by the same reconcile, so
+// the decision is ours to change whether pod changed since or not.
+func (r *PodyTwoFaceReconciler) takeBackExecution(ctx context.Context, pod *v1.Pod, decision *nullpodytwofacev1.Decision) error {
+	decision.ExecutedAt = nil
+	return r.patchDecision(ctx, pod, decision)
+}
+
+func (r *PodyTwoFaceReconciler) patchDecision(ctx context.Context, pod *v1.Pod, decision *nullpodytwofacev1.Decision, opts ...client.MergeFromOption) error {
+	data, err := json.Marshal(decision)
+	if err != nil {
+		return err
+	}
+
+	original := pod.DeepCopy()
+	if pod.Annotations == nil {
+		pod.Annotations = map[string]string{}
+	}
+	pod.Annotations[nullpodytwofacev1.DecisionAnnotation] = string(data)
+
+	return r.Patch(ctx, pod, client.MergeFromWithOptions(original, opts...))
+}
@@ -0,0 +1,327 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilrand "k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	nullpodytwofacev1 "github.com/null-channel/stupid-kube-operators/podytwoface/api/v1"
+)
+
+const (
+	defaultKillImage   = "busybox"
+	defaultStressImage = "alexeiled/stress-ng"
+	defaultSignal      = "TERM"
+	defaultStressTime  = time.Minute
+	defaultIsolation   = 5 * time.Minute
+	flippedLabelPrefix = "two-faced-"
+)
+
+// errEvictionBlocked is returned by the Evict fault when a PodDisruptionBudget refuses the eviction.
+var errEvictionBlocked = errors.New("eviction blocked by a PodDisruptionBudget")
+
+// Fault is one of the faces PodyTwoFace can show a pod that lost the coin flip.
+type Fault interface {
+	// Inject does the damage to pod, as described by spec.
+	Inject(ctx context.Context, pod *v1.Pod, spec nullpodytwofacev1.FaultSpec) error
+}
+
+// faultFor returns the Fault implementing t.
+func (r *PodyTwoFaceReconciler) faultFor(t nullpodytwofacev1.FaultType) (Fault, error) {
+	switch t {
+	case "", nullpodytwofacev1.FaultDelete:
+		return &deleteFault{Client: r.Client}, nil
+	case nullpodytwofacev1.FaultEvict:
+		return &evictFault{Clientset: r.Clientset}, nil
+	case nullpodytwofacev1.FaultContainerKill:
+		return &containerKillFault{Clientset: r.Clientset}, nil
+	case nullpodytwofacev1.FaultLabelFlip:
+		return &labelFlipFault{Client: r.Client}, nil
+	case nullpodytwofacev1.FaultReadinessSabotage:
+		return &readinessSabotageFault{Client: r.Client}, nil
+	case nullpodytwofacev1.FaultStress:
+		return &stressFault{Clientset: r.Clientset}, nil
+	case nullpodytwofacev1.FaultNetworkIsolation:
+		return &networkIsolationFault{Client: r.Client, Scheme: r.Scheme}, nil
+	}
+	return nil, fmt.Errorf("unknown fault type %q", t)
+}
+
+// deleteFault is the original face: the pod is simply deleted.
+type deleteFault struct {
+	client.Client
+}
+
+func (f *deleteFault) Inject(ctx context.Context, pod *v1.Pod, _ nullpodytwofacev1.FaultSpec) error {
+	if err := f.Delete(ctx, pod); err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// evictFault asks nicely through the eviction API, so PodDisruptionBudgets get a say.
+type evictFault struct {
+	Clientset kubernetes.Interface
+}
+
+func (f *evictFault) Inject(ctx context.Context, pod *v1.Pod, _ nullpodytwofacev1.FaultSpec) error {
+	eviction := &policyv1beta1.Eviction{
+		ObjectMeta: metav1.ObjectMeta{Namespace: pod.Namespace, Name: pod.Name},
+	}
+	err := f.Clientset.CoreV1().Pods(pod.Namespace).Evict(ctx, eviction)
+	switch {
+	case err == nil || apierrors.IsNotFound(err):
+		return nil
+	case apierrors.IsTooManyRequests(err):
+		return errEvictionBlocked
+	}
+	return err
+}
+
+// containerKillFault signals the main process of a container from an
+// ephemeral container sharing its process namespace.
+type containerKillFault struct {
+	Clientset kubernetes.Interface
+}
+
+func (f *containerKillFault) Inject(ctx context.Context, pod *v1.Pod, spec nullpodytwofacev1.FaultSpec) error {
+	signal := spec.Signal
+	if signal == "" {
+		signal = defaultSignal
+	}
+
+	return addEphemeralContainer(ctx, f.Clientset, pod, v1.EphemeralContainer{
+		EphemeralContainerCommon: v1.EphemeralContainerCommon{
+			Name:    "podytwoface-kill-" + utilrand.String(5),
+			Image:   imageOr(spec.Image, defaultKillImage),
+			Command: []string{"kill", "-" + signal, "1"},
+		},
+		TargetContainerName: targetContainer(pod, spec),
+	})
+}
+
+// labelFlipFault changes the value of labels so Services stop selecting the pod.
+// The original values are kept in an annotation for whoever wants them back.
+// Labels flipped before, by an earlier roll, are left as they are, so the
+// annotation always has the values from before the first flip.
+type labelFlipFault struct {
+	client.Client
+}
+
+func (f *labelFlipFault) Inject(ctx context.Context, pod *v1.Pod, spec nullpodytwofacev1.FaultSpec) error {
+	original := pod.DeepCopy()
+
+	flipped := map[string]string{}
+	if data, ok := pod.Annotations[nullpodytwofacev1.FlippedLabelsAnnotation]; ok {
+		if err := json.Unmarshal([]byte(data), &flipped); err != nil {
+			// Overwriting it would lose the original values for good.
+			return fmt.Errorf("reading annotation %s: %w", nullpodytwofacev1.FlippedLabelsAnnotation, err)
+		}
+	}
+
+	changed := false
+	for _, key := range spec.Labels {
+		value, ok := pod.Labels[key]
+		if !ok {
+			continue
+		}
+		if _, ok := flipped[key]; ok {
+			continue
+		}
+		flipped[key] = value
+		pod.Labels[key] = flippedValue(value)
+		changed = true
+	}
+
+	if !changed {
+		return nil
+	}
+
+	data, err := json.Marshal(flipped)
+	if err != nil {
+		return err
+	}
+	if pod.Annotations == nil {
+		pod.Annotations = map[string]string{}
+	}
+	pod.Annotations[nullpodytwofacev1.FlippedLabelsAnnotation] = string(data)
+
+	return f.Patch(ctx, pod, client.MergeFrom(original))
+}
+
+// flippedValue returns what a label with value is flipped to. Values too long
+// to take the prefix, or that do not make a valid label value with it, are
+// replaced by a hash of themselves.
+func flippedValue(value string) string {
+	if v := flippedLabelPrefix + value; len(validation.IsValidLabelValue(v)) == 0 {
+		return v
+	}
+	sum := sha256.Sum256([]byte(value))
+	return flippedLabelPrefix + hex.EncodeToString(sum[:])[:16]
+}
+
+// readinessSabotageFault flags the pod for a cooperating sidecar to fail its readiness probe.
+type readinessSabotageFault struct {
+	client.Client
+}
+
+func (f *readinessSabotageFault) Inject(ctx context.Context, pod *v1.Pod, _ nullpodytwofacev1.FaultSpec) error {
+	original := pod.DeepCopy()
+
+	if pod.Annotations == nil {
+		pod.Annotations = map[string]string{}
+	}
+	pod.Annotations[nullpodytwofacev1.ReadinessSabotageAnnotation] = "true"
+
+	return f.Patch(ctx, pod, client.MergeFrom(original))
+}
+
+// stressFault burns CPU and memory in the pod from an ephemeral container.
+type stressFault struct {
+	Clientset kubernetes.Interface
+}
+
+func (f *stressFault) Inject(ctx context.Context, pod *v1.Pod, spec nullpodytwofacev1.FaultSpec) error {
+	duration := defaultStressTime
+	args := []string{}
+
+	if s := spec.Stress; s != nil {
+		if s.Duration != nil {
+			duration = s.Duration.Duration
+		}
+		if s.CPUWorkers > 0 {
+			args = append(args, "--cpu", strconv.Itoa(int(s.CPUWorkers)))
+		}
+		if s.Memory != nil && !s.Memory.IsZero() {
+			args = append(args, "--vm", "1", "--vm-bytes", strconv.FormatInt(s.Memory.Value(), 10))
+		}
+	}
+
+	if len(args) == 0 {
+		// Nothing sized, so one CPU worker it is.
+		args = append(args, "--cpu", "1")
+	}
+	args = append(args, "--timeout", fmt.Sprintf("%ds", int(duration.Seconds())))
+
+	return addEphemeralContainer(ctx, f.Clientset, pod, v1.EphemeralContainer{
+		EphemeralContainerCommon: v1.EphemeralContainerCommon{
+			Name:  "podytwoface-stress-" + utilrand.String(5),
+			Image: imageOr(spec.Image, defaultStressImage),
+			Args:  args,
+		},
+		TargetContainerName: targetContainer(pod, spec),
+	})
+}
+
+// networkIsolationFault cuts the pod off with a deny-all NetworkPolicy selecting
+// a label stamped on the pod. The NetworkPolicy is owned by the pod and removed
+// by IsolationReconciler when it expires.
+type networkIsolationFault struct {
+	client.Client
+	Scheme *runtime.Scheme
+}
+
+func (f *networkIsolationFault) Inject(ctx context.Context, pod *v1.Pod, spec nullpodytwofacev1.FaultSpec) error {
+	ttl := defaultIsolation
+	if spec.Isolation != nil && spec.Isolation.TTL != nil {
+		ttl = spec.Isolation.TTL.Duration
+	}
+
+	original := pod.DeepCopy()
+	if pod.Labels == nil {
+		pod.Labels = map[string]string{}
+	}
+	pod.Labels[nullpodytwofacev1.IsolatedLabel] = string(pod.UID)
+	if err := f.Patch(ctx, pod, client.MergeFrom(original)); err != nil {
+		return err
+	}
+
+	np := &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: "podytwoface-isolate-",
+			Namespace:    pod.Namespace,
+			Labels:       map[string]string{nullpodytwofacev1.IsolationLabel: "true"},
+			Annotations: map[string]string{
+				nullpodytwofacev1.ExpiresAtAnnotation: time.Now().Add(ttl).UTC().Format(time.RFC3339),
+			},
+		},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{
+				MatchLabels: map[string]string{nullpodytwofacev1.IsolatedLabel: string(pod.UID)},
+			},
+			// No rules at all: nothing in, nothing out.
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress, networkingv1.PolicyTypeEgress},
+		},
+	}
+	if err := controllerutil.SetControllerReference(pod, np, f.Scheme); err != nil {
+		return err
+	}
+
+	return f.Create(ctx, np)
+}
+
+// addEphemeralContainer adds container to the ephemeral containers of pod.
+func addEphemeralContainer(ctx context.Context, clientset kubernetes.Interface, pod *v1.Pod, container v1.EphemeralContainer) error {
+	pods := clientset.CoreV1().Pods(pod.Namespace)
+
+	ephemeral, err := pods.GetEphemeralContainers(ctx, pod.Name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+
+	ephemeral.EphemeralContainers = append(ephemeral.EphemeralContainers, container)
+
+	_, err = pods.UpdateEphemeralContainers(ctx, pod.Name, ephemeral, metav1.UpdateOptions{})
+	return err
+}
+
+// targetContainer returns the container a fault is aimed at.
+func targetContainer(pod *v1.Pod, spec nullpodytwofacev1.FaultSpec) string {
+	if spec.Container != "" {
+		return spec.Container
+	}
+	if len(pod.Spec.Containers) > 0 {
+		return pod.Spec.Containers[0].Name
+	}
+	return ""
+}
+
+func imageOr(image, fallback string) string {
+	if image == "" {
+		return fallback
+	}
+	return image
+}
@@ -0,0 +1,150 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	nullpodytwofacev1 "github.com/null-channel/stupid-kube-operators/podytwoface/api/v1"
+)
+
+func TestFlippedValue(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+	}{
+		{name: "short", value: "web"},
+		{name: "empty", value: ""},
+		{name: "too long for the prefix", value: strings.Repeat("a", validation.LabelValueMaxLength)},
+		{name: "just fits", value: strings.Repeat("a", validation.LabelValueMaxLength-len(flippedLabelPrefix))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := flippedValue(tt.value)
+			if errs := validation.IsValidLabelValue(got); len(errs) > 0 {
+				t.Errorf("flippedValue(%q) = %q, not a label value: %v", tt.value, got, errs)
+			}
+			if got == tt.value {
+				t.Errorf("flippedValue(%q) did not flip it", tt.value)
+			}
+		})
+	}
+
+	if got := flippedValue("web"); got != flippedLabelPrefix+"web" {
+		t.Errorf("flippedValue(%q) = %q, want the prefixed value", "web", got)
+	}
+}
+
+func TestLabelFlipFault(t *testing.T) {
+	long := strings.Repeat("v", validation.LabelValueMaxLength)
+
+	tests := []struct {
+		name        string
+		labels      map[string]string
+		annotation  string
+		flip        []string
+		wantLabels  map[string]string
+		wantFlipped map[string]string
+		wantErr     bool
+	}{
+		{
+			name:        "flips the labels asked for",
+			labels:      map[string]string{"app": "web", "tier": "front"},
+			flip:        []string{"app", "missing"},
+			wantLabels:  map[string]string{"app": flippedLabelPrefix + "web", "tier": "front"},
+			wantFlipped: map[string]string{"app": "web"},
+		},
+		{
+			name:        "shortens values too long for the prefix",
+			labels:      map[string]string{"app": long},
+			flip:        []string{"app"},
+			wantLabels:  map[string]string{"app": flippedValue(long)},
+			wantFlipped: map[string]string{"app": long},
+		},
+		{
+			name:        "keeps the original values on a second roll",
+			labels:      map[string]string{"app": flippedLabelPrefix + "web", "tier": "front"},
+			annotation:  `{"app":"web"}`,
+			flip:        []string{"app", "tier"},
+			wantLabels:  map[string]string{"app": flippedLabelPrefix + "web", "tier": flippedLabelPrefix + "front"},
+			wantFlipped: map[string]string{"app": "web", "tier": "front"},
+		},
+		{
+			name:        "leaves pods flipped already alone",
+			labels:      map[string]string{"app": flippedLabelPrefix + "web"},
+			annotation:  `{"app":"web"}`,
+			flip:        []string{"app"},
+			wantLabels:  map[string]string{"app": flippedLabelPrefix + "web"},
+			wantFlipped: map[string]string{"app": "web"},
+		},
+		{
+			name:       "refuses to overwrite an annotation it can not read",
+			labels:     map[string]string{"app": "web"},
+			annotation: "not json",
+			flip:       []string{"app"},
+			wantLabels: map[string]string{"app": "web"},
+			wantErr:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web-1", Namespace: "default", Labels: tt.labels}}
+			if tt.annotation != "" {
+				pod.Annotations = map[string]string{nullpodytwofacev1.FlippedLabelsAnnotation: tt.annotation}
+			}
+			c := fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(pod).Build()
+
+			fault := &labelFlipFault{Client: c}
+			err := fault.Inject(ctx, pod.DeepCopy(), nullpodytwofacev1.FaultSpec{Type: nullpodytwofacev1.FaultLabelFlip, Labels: tt.flip})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Inject() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			got := &v1.Pod{}
+			if err := c.Get(ctx, client.ObjectKeyFromObject(pod), got); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got.Labels, tt.wantLabels) {
+				t.Errorf("labels = %v, want %v", got.Labels, tt.wantLabels)
+			}
+			if tt.wantErr {
+				return
+			}
+			flipped := map[string]string{}
+			if err := json.Unmarshal([]byte(got.Annotations[nullpodytwofacev1.FlippedLabelsAnnotation]), &flipped); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(flipped, tt.wantFlipped) {
+				t.Errorf("flipped labels = %v, want %v", flipped, tt.wantFlipped)
+			}
+		})
+	}
+}
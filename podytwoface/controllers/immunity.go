@@ -0,0 +1,58 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	nullpodytwofacev1 "github.com/null-channel/stupid-kube-operators/podytwoface/api/v1"
+)
+
+// immunity returns what protects pod from every policy, or "" when nothing does.
+// The pod, each of its owners and its namespace can claim immunity.
+func immunity(pod *v1.Pod, namespace *v1.Namespace, chain []owner) string {
+	if isImmune(pod) {
+		return "pod"
+	}
+
+	for _, o := range chain {
+		if o.obj != nil && isImmune(o.obj) {
+			return fmt.Sprintf("%s %s", o.ref.Kind, o.ref.Name)
+		}
+	}
+
+	if isImmune(namespace) {
+		return fmt.Sprintf("namespace %s", namespace.Name)
+	}
+
+	return ""
+}
+
+func isImmune(obj metav1.Object) bool {
+	return obj.GetAnnotations()[nullpodytwofacev1.ImmuneAnnotation] == "true"
+}
+
+// volunteered reports whether the pods of namespace may be targeted by policy.
+func volunteered(policy *nullpodytwofacev1.PodyTwoFace, namespace *v1.Namespace) bool {
+	if policy.Spec.Targeting != nullpodytwofacev1.TargetingOptIn {
+		return true
+	}
+	return namespace.Labels[nullpodytwofacev1.VolunteerLabel] == "true"
+}
@@ -0,0 +1,115 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+
+	nullpodytwofacev1 "github.com/null-channel/stupid-kube-operators/podytwoface/api/v1"
+)
+
+// IsolationReconciler ends the network isolations of the NetworkIsolation fault.
+type IsolationReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+}
+
+//+kubebuilder:rbac:groups=networking.k8s.io,resources=networkpolicies,verbs=get;list;watch;create;delete
+//+kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch;patch
+
+// Reconcile removes an isolating NetworkPolicy once it expired, and the label
+// it selects its pod by. NetworkPolicies with a missing or unreadable expiry
+// are removed right away, as nobody knows how long they were meant to last.
+//
+// For more details, check Reconcile and its Result here:
+// - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.8.3/pkg/reconcile
+func (r *IsolationReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	np := &networkingv1.NetworkPolicy{}
+	if err := r.Get(ctx, req.NamespacedName, np); err != nil {
+		if apierrors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+
+	if np.Labels[nullpodytwofacev1.IsolationLabel] != "true" || !np.DeletionTimestamp.IsZero() {
+		return ctrl.Result{}, nil
+	}
+
+	now := time.Now()
+	if expires, err := time.Parse(time.RFC3339, np.Annotations[nullpodytwofacev1.ExpiresAtAnnotation]); err == nil && now.Before(expires) {
+		return ctrl.Result{RequeueAfter: expires.Sub(now)}, nil
+	}
+
+	// Let go of the pod first, so a failed delete does not leave it labelled for good.
+	if ref := metav1.GetControllerOf(np); ref != nil && ref.Kind == "Pod" {
+		if err := r.release(ctx, client.ObjectKey{Namespace: np.Namespace, Name: ref.Name}); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	logger.Info("ending network isolation", "networkPolicy", np.Name)
+	if err := r.Delete(ctx, np); err != nil && !apierrors.IsNotFound(err) {
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{}, nil
+}
+
+// release removes the isolated label from the pod at key, if it is still around.
+func (r *IsolationReconciler) release(ctx context.Context, key client.ObjectKey) error {
+	pod := &v1.Pod{}
+	if err := r.Get(ctx, key, pod); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	if _, ok := pod.Labels[nullpodytwofacev1.IsolatedLabel]; !ok {
+		return nil
+	}
+
+	original := pod.DeepCopy()
+	delete(pod.Labels, nullpodytwofacev1.IsolatedLabel)
+	return r.Patch(ctx, pod, client.MergeFrom(original))
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *IsolationReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	isolation := predicate.NewPredicateFuncs(func(o client.Object) bool {
+		return o.GetLabels()[nullpodytwofacev1.IsolationLabel] == "true"
+	})
+
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&networkingv1.NetworkPolicy{}, builder.WithPredicates(isolation)).
+		Complete(r)
+}
@@ -0,0 +1,119 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	nullpodytwofacev1 "github.com/null-channel/stupid-kube-operators/podytwoface/api/v1"
+)
+
+var _ = Describe("NetworkIsolation fault", func() {
+	ctx := context.Background()
+
+	var pod *v1.Pod
+
+	BeforeEach(func() {
+		pod = &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{GenerateName: "victim-", Namespace: "default"},
+			Spec: v1.PodSpec{
+				Containers: []v1.Container{{Name: "app", Image: "nginx"}},
+			},
+		}
+		Expect(k8sClient.Create(ctx, pod)).To(Succeed())
+	})
+
+	AfterEach(func() {
+		Expect(client.IgnoreNotFound(k8sClient.Delete(ctx, pod))).To(Succeed())
+	})
+
+	isolate := func(ttl time.Duration) *networkingv1.NetworkPolicy {
+		fault := &networkIsolationFault{Client: k8sClient, Scheme: scheme.Scheme}
+		spec := nullpodytwofacev1.FaultSpec{
+			Type:      nullpodytwofacev1.FaultNetworkIsolation,
+			Isolation: &nullpodytwofacev1.IsolationSpec{TTL: &metav1.Duration{Duration: ttl}},
+		}
+		Expect(fault.Inject(ctx, pod, spec)).To(Succeed())
+
+		policies := &networkingv1.NetworkPolicyList{}
+		Expect(k8sClient.List(ctx, policies, client.InNamespace("default"),
+			client.MatchingLabels{nullpodytwofacev1.IsolationLabel: "true"})).To(Succeed())
+
+		for i := range policies.Items {
+			if ref := metav1.GetControllerOf(&policies.Items[i]); ref != nil && ref.UID == pod.UID {
+				return &policies.Items[i]
+			}
+		}
+		Fail("no NetworkPolicy isolating the pod")
+		return nil
+	}
+
+	reconcile := func(np *networkingv1.NetworkPolicy) ctrl.Result {
+		r := &IsolationReconciler{Client: k8sClient, Scheme: scheme.Scheme}
+		result, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(np)})
+		Expect(err).NotTo(HaveOccurred())
+		return result
+	}
+
+	It("isolates the pod with a deny-all NetworkPolicy it owns", func() {
+		np := isolate(time.Hour)
+
+		Expect(np.Spec.PodSelector.MatchLabels).To(Equal(map[string]string{
+			nullpodytwofacev1.IsolatedLabel: string(pod.UID),
+		}))
+		Expect(np.Spec.Ingress).To(BeEmpty())
+		Expect(np.Spec.Egress).To(BeEmpty())
+		Expect(np.Spec.PolicyTypes).To(ConsistOf(networkingv1.PolicyTypeIngress, networkingv1.PolicyTypeEgress))
+
+		current := &v1.Pod{}
+		Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(pod), current)).To(Succeed())
+		Expect(current.Labels).To(HaveKeyWithValue(nullpodytwofacev1.IsolatedLabel, string(pod.UID)))
+	})
+
+	It("keeps the isolation until it expires", func() {
+		np := isolate(time.Hour)
+
+		result := reconcile(np)
+		Expect(result.RequeueAfter).To(BeNumerically(">", 59*time.Minute))
+		Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(np), &networkingv1.NetworkPolicy{})).To(Succeed())
+	})
+
+	It("ends the isolation when it expires", func() {
+		np := isolate(time.Millisecond)
+		time.Sleep(time.Second)
+
+		reconcile(np)
+
+		err := k8sClient.Get(ctx, client.ObjectKeyFromObject(np), &networkingv1.NetworkPolicy{})
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+
+		current := &v1.Pod{}
+		Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(pod), current)).To(Succeed())
+		Expect(current.Labels).NotTo(HaveKey(nullpodytwofacev1.IsolatedLabel))
+	})
+})
@@ -0,0 +1,427 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+
+	nullpodytwofacev1 "github.com/null-channel/stupid-kube-operators/podytwoface/api/v1"
+)
+
+const (
+	defaultNodeOutage = 10 * time.Minute
+	// nodeDrainPoll is how often a node outage checks on its node. Evictions
+	// blocked by a PodDisruptionBudget are retried at this pace.
+	nodeDrainPoll = 10 * time.Second
+	// nodeChaosFinalizer makes sure no node stays cordoned after its policy is gone.
+	nodeChaosFinalizer = "nullpodytwoface.thenullchannel.dev/uncordon"
+	// podNodeNameField indexes pods by the node they run on.
+	podNodeNameField = "spec.nodeName"
+)
+
+// NodeChaosReconciler runs the node outages of PodyTwoFace policies targeting nodes.
+type NodeChaosReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+	// Clientset evicts the pods of drained nodes.
+	Clientset kubernetes.Interface
+}
+
+//+kubebuilder:rbac:groups=nullpodytwoface.thenullchannel.dev,resources=podytwofaces,verbs=get;list;watch;update;patch
+//+kubebuilder:rbac:groups=nullpodytwoface.thenullchannel.dev,resources=podytwofaces/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=nullpodytwoface.thenullchannel.dev,resources=podytwofaces/finalizers,verbs=update
+//+kubebuilder:rbac:groups=core,resources=nodes,verbs=get;list;watch;patch
+//+kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,resources=pods/eviction,verbs=create
+
+// Reconcile flips the coin of a Node policy for the whole cluster. When the
+// cluster loses, a random node matching the node selector is cordoned and its
+// pods are evicted, PodDisruptionBudgets permitting, until the outage is over
+// and the node is uncordoned. Flips and outages count against the same limits
+// as pod kills, with the node as the workload.
+//
+// For more details, check Reconcile and its Result here:
+// - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.8.3/pkg/reconcile
+func (r *NodeChaosReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	policy := &nullpodytwofacev1.PodyTwoFace{}
+	if err := r.Get(ctx, req.NamespacedName, policy); err != nil {
+		if apierrors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+
+	now := time.Now()
+	status := policy.Status.Node
+	outage := status != nil && status.Node != "" && status.Phase != nullpodytwofacev1.NodeChaosUncordoned
+
+	if !policy.DeletionTimestamp.IsZero() || policy.Spec.Target != nullpodytwofacev1.TargetNode || policyAborted(policy) {
+		if outage {
+			if err := r.endOutage(ctx, policy, "outage cut short"); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+		if controllerutil.ContainsFinalizer(policy, nodeChaosFinalizer) {
+			controllerutil.RemoveFinalizer(policy, nodeChaosFinalizer)
+			return ctrl.Result{}, r.Update(ctx, policy)
+		}
+		return ctrl.Result{}, nil
+	}
+
+	if !controllerutil.ContainsFinalizer(policy, nodeChaosFinalizer) {
+		controllerutil.AddFinalizer(policy, nodeChaosFinalizer)
+		if err := r.Update(ctx, policy); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	if outage {
+		return r.progress(ctx, policy, now)
+	}
+
+	var decision *nullpodytwofacev1.Decision
+	if status != nil {
+		decision = status.Decision.DeepCopy()
+		next, ok := nextRoll(decision, policy)
+		switch {
+		case decision.Verdict == nullpodytwofacev1.VerdictCondemned && decision.ExecutedAt == nil:
+			// Condemned earlier but held back by the limits.
+		case !ok:
+			return ctrl.Result{}, nil
+		case now.Before(next):
+			return ctrl.Result{RequeueAfter: next.Sub(now)}, nil
+		default:
+			decision = nil
+		}
+	}
+
+	if decision == nil {
+		decision = roll(policy, now)
+		policy.Status.Node = &nullpodytwofacev1.NodeChaosStatus{Decision: *decision}
+		if err := r.Status().Update(ctx, policy); err != nil {
+			return ctrl.Result{}, err
+		}
+
+		o := outcomeCondemned
+		if decision.Verdict == nullpodytwofacev1.VerdictSpared {
+			o = outcomeSpared
+		}
+		recordOutcome(ctx, r.Client, policy.Name, o, "", now)
+
+		if decision.Verdict == nullpodytwofacev1.VerdictSpared {
+			if next, ok := nextRoll(decision, policy); ok {
+				return ctrl.Result{RequeueAfter: next.Sub(now)}, nil
+			}
+			return ctrl.Result{}, nil
+		}
+	}
+
+	node, err := r.pickNode(ctx, policy)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if node == nil {
+		policy.Status.Node.Message = "no node to drain"
+		return ctrl.Result{RequeueAfter: unavailableRequeue}, r.Status().Update(ctx, policy)
+	}
+
+	workload := nodeWorkload(node.Name)
+	if wait, reason := checkLimits(policy, blastRadius{workload: workload}, now); wait > 0 {
+		logger.Info("holding back node outage, policy limit reached", "policy", policy.Name, "reason", reason, "retryAfter", wait)
+		return ctrl.Result{RequeueAfter: wait}, nil
+	}
+
+	outageFor := defaultNodeOutage
+	if policy.Spec.NodeOutage != nil {
+		outageFor = policy.Spec.NodeOutage.Duration
+	}
+
+	// As with pods, the outage is on record before the node is touched.
+	recordKill(policy, workload, now)
+	executed := metav1.NewTime(now)
+	uncordonAt := metav1.NewTime(now.Add(outageFor))
+	decision.ExecutedAt = &executed
+	policy.Status.Node = &nullpodytwofacev1.NodeChaosStatus{
+		Decision:   *decision,
+		Node:       node.Name,
+		Phase:      nullpodytwofacev1.NodeChaosDraining,
+		UncordonAt: &uncordonAt,
+	}
+	if err := r.Status().Update(ctx, policy); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	logger.Info("taking node out", "policy", policy.Name, "node", node.Name, "until", uncordonAt)
+	recordOutcome(ctx, r.Client, policy.Name, outcomeKilled, workload, now)
+
+	return r.progress(ctx, policy, now)
+}
+
+// progress moves the outage of policy along: it keeps the node cordoned,
+// evicts what is left on it, and gives it back when time is up.
+func (r *NodeChaosReconciler) progress(ctx context.Context, policy *nullpodytwofacev1.PodyTwoFace, now time.Time) (ctrl.Result, error) {
+	status := policy.Status.Node
+
+	if !now.Before(status.UncordonAt.Time) {
+		return r.finish(ctx, policy, "", now)
+	}
+
+	node := &v1.Node{}
+	if err := r.Get(ctx, client.ObjectKey{Name: status.Node}, node); err != nil {
+		if apierrors.IsNotFound(err) {
+			return r.finish(ctx, policy, "node is gone", now)
+		}
+		return ctrl.Result{}, err
+	}
+
+	if err := setUnschedulable(ctx, r.Client, node, true); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	if status.Phase == nullpodytwofacev1.NodeChaosDraining {
+		evicted, remaining, err := r.drain(ctx, policy, node, now)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+
+		status.Evicted += evicted
+		status.Remaining = remaining
+		status.Message = ""
+		if remaining == 0 {
+			status.Phase = nullpodytwofacev1.NodeChaosDrained
+		} else {
+			status.Message = fmt.Sprintf("%d pods left on the node", remaining)
+		}
+		if err := r.Status().Update(ctx, policy); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	wait := nodeDrainPoll
+	if left := status.UncordonAt.Sub(now); left < wait {
+		wait = left
+	}
+	return ctrl.Result{RequeueAfter: wait}, nil
+}
+
+// finish ends the outage of policy and waits for its next flip, if it gets one.
+func (r *NodeChaosReconciler) finish(ctx context.Context, policy *nullpodytwofacev1.PodyTwoFace, message string, now time.Time) (ctrl.Result, error) {
+	if err := r.endOutage(ctx, policy, message); err != nil {
+		return ctrl.Result{}, err
+	}
+	if next, ok := nextRoll(&policy.Status.Node.Decision, policy); ok {
+		return ctrl.Result{RequeueAfter: next.Sub(now)}, nil
+	}
+	return ctrl.Result{}, nil
+}
+
+// drain evicts the pods on node that a drain would, and returns how many it
+// evicted and how many are still on the node. Pods of DaemonSets and mirror
+// pods stay, as they would with kubectl drain, and so do immune pods.
+func (r *NodeChaosReconciler) drain(ctx context.Context, policy *nullpodytwofacev1.PodyTwoFace, node *v1.Node, now time.Time) (int32, int32, error) {
+	pods := &v1.PodList{}
+	if err := r.List(ctx, pods, client.MatchingFields{podNodeNameField: node.Name}); err != nil {
+		return 0, 0, err
+	}
+
+	evict := &evictFault{Clientset: r.Clientset}
+	namespaces := map[string]*v1.Namespace{}
+
+	var evicted, remaining int32
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if !drainable(pod) {
+			continue
+		}
+
+		namespace, ok := namespaces[pod.Namespace]
+		if !ok {
+			namespace = &v1.Namespace{}
+			if err := r.Get(ctx, client.ObjectKey{Name: pod.Namespace}, namespace); err != nil {
+				return evicted, remaining, err
+			}
+			namespaces[pod.Namespace] = namespace
+		}
+		chain, err := ownerChain(ctx, r.Client, pod)
+		if err != nil {
+			return evicted, remaining, err
+		}
+		if why := immunity(pod, namespace, chain); why != "" {
+			log.FromContext(ctx).V(1).Info("leaving immune pod on the node", "pod", client.ObjectKeyFromObject(pod), "immune", why)
+			continue
+		}
+
+		remaining++
+		if !pod.DeletionTimestamp.IsZero() {
+			// Evicted already, on its way out.
+			continue
+		}
+
+		err = evict.Inject(ctx, pod, policy.Spec.Fault)
+		switch {
+		case errors.Is(err, errEvictionBlocked):
+			// Blocked pods are retried on every poll, but count once per outage.
+			status := policy.Status.Node
+			key := client.ObjectKeyFromObject(pod).String()
+			if !contains(status.Blocked, key) {
+				status.Blocked = append(status.Blocked, key)
+				recordOutcome(ctx, r.Client, policy.Name, outcomeEvictionBlocked, workloadKey(pod, chain), now)
+			}
+		case err != nil:
+			return evicted, remaining, err
+		default:
+			evicted++
+		}
+	}
+
+	return evicted, remaining, nil
+}
+
+// drainable reports whether pod has to leave a drained node.
+func drainable(pod *v1.Pod) bool {
+	if pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed {
+		return false
+	}
+	if _, ok := pod.Annotations[v1.MirrorPodAnnotationKey]; ok {
+		return false
+	}
+	if ref := metav1.GetControllerOf(pod); ref != nil && ref.Kind == "DaemonSet" {
+		return false
+	}
+	return true
+}
+
+// endOutage uncordons the node of policy and closes its outage.
+func (r *NodeChaosReconciler) endOutage(ctx context.Context, policy *nullpodytwofacev1.PodyTwoFace, message string) error {
+	status := policy.Status.Node
+
+	node := &v1.Node{}
+	err := r.Get(ctx, client.ObjectKey{Name: status.Node}, node)
+	switch {
+	case apierrors.IsNotFound(err):
+	case err != nil:
+		return err
+	default:
+		if err := setUnschedulable(ctx, r.Client, node, false); err != nil {
+			return err
+		}
+	}
+
+	log.FromContext(ctx).Info("giving node back", "policy", policy.Name, "node", status.Node)
+
+	status.Phase = nullpodytwofacev1.NodeChaosUncordoned
+	status.Message = message
+	return r.Status().Update(ctx, policy)
+}
+
+// pickNode returns a random node policy may take out, or nil if there is none.
+func (r *NodeChaosReconciler) pickNode(ctx context.Context, policy *nullpodytwofacev1.PodyTwoFace) (*v1.Node, error) {
+	candidates, err := nodeCandidates(ctx, r.Client, policy)
+	if err != nil || len(candidates) == 0 {
+		return nil, err
+	}
+	return candidates[rand.Intn(len(candidates))], nil
+}
+
+// nodeCandidates returns the nodes policy may take out right now.
+func nodeCandidates(ctx context.Context, c client.Reader, policy *nullpodytwofacev1.PodyTwoFace) ([]*v1.Node, error) {
+	nodes := &v1.NodeList{}
+	if err := c.List(ctx, nodes); err != nil {
+		return nil, err
+	}
+
+	candidates := []*v1.Node{}
+	for i := range nodes.Items {
+		node := &nodes.Items[i]
+
+		match, err := selectorMatches(policy.Spec.NodeSelector, node.Labels)
+		if err != nil {
+			return nil, err
+		}
+
+		// Nodes somebody else cordoned are theirs to give back.
+		if match && !node.Spec.Unschedulable && !isImmune(node) {
+			candidates = append(candidates, node)
+		}
+	}
+	return candidates, nil
+}
+
+// setUnschedulable cordons or uncordons node.
+func setUnschedulable(ctx context.Context, c client.Client, node *v1.Node, unschedulable bool) error {
+	if node.Spec.Unschedulable == unschedulable {
+		return nil
+	}
+
+	original := node.DeepCopy()
+	node.Spec.Unschedulable = unschedulable
+	return c.Patch(ctx, node, client.MergeFrom(original))
+}
+
+// nodeWorkload names node as a workload, in the namespace/kind/name form of workloadKey.
+func nodeWorkload(node string) string {
+	return "/Node/" + node
+}
+
+// contains reports whether list has s.
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *NodeChaosReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	err := mgr.GetFieldIndexer().IndexField(context.Background(), &v1.Pod{}, podNodeNameField, func(o client.Object) []string {
+		pod := o.(*v1.Pod)
+		if pod.Spec.NodeName == "" {
+			return nil
+		}
+		return []string{pod.Spec.NodeName}
+	})
+	if err != nil {
+		return err
+	}
+
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("nodechaos").
+		// Outages poll their node, so status updates do not need to wake us up.
+		For(&nullpodytwofacev1.PodyTwoFace{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Complete(r)
+}
@@ -0,0 +1,121 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	nullpodytwofacev1 "github.com/null-channel/stupid-kube-operators/podytwoface/api/v1"
+)
+
+func TestNodeChaosDrain(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+
+	uncordonAt := metav1.NewTime(now.Add(time.Hour))
+	policy := &nullpodytwofacev1.PodyTwoFace{
+		ObjectMeta: metav1.ObjectMeta{Name: "outage", Finalizers: []string{nodeChaosFinalizer}},
+		Spec:       nullpodytwofacev1.PodyTwoFaceSpec{Target: nullpodytwofacev1.TargetNode},
+		Status: nullpodytwofacev1.PodyTwoFaceStatus{Node: &nullpodytwofacev1.NodeChaosStatus{
+			Decision:   nullpodytwofacev1.Decision{Verdict: nullpodytwofacev1.VerdictCondemned, Policy: "outage"},
+			Node:       "node-1",
+			Phase:      nullpodytwofacev1.NodeChaosDraining,
+			UncordonAt: &uncordonAt,
+		}},
+	}
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}, Spec: v1.NodeSpec{Unschedulable: true}}
+	immune := map[string]string{nullpodytwofacev1.ImmuneAnnotation: "true"}
+	onNode := func(namespace, name string, annotations map[string]string) *v1.Pod {
+		return &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Annotations: annotations},
+			Spec:       v1.PodSpec{NodeName: node.Name},
+		}
+	}
+	pod, replicaSet, deployment := deploymentPod(true)
+	pod.Spec.NodeName = node.Name
+
+	objs := []client.Object{
+		policy, node, replicaSet, deployment, pod,
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}},
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "payments", Annotations: immune}},
+		onNode("default", "web", nil),
+		onNode("default", "blocked", nil),
+		onNode("default", "immune", immune),
+		onNode("payments", "api", nil),
+		runningExperiment("counting", policy.Name, now.Add(time.Hour)),
+	}
+	c := fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(objs...).Build()
+
+	evicted := []string{}
+	clientset := k8sfake.NewSimpleClientset()
+	clientset.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() != "eviction" {
+			return false, nil, nil
+		}
+		eviction := action.(k8stesting.CreateAction).GetObject().(*policyv1beta1.Eviction)
+		evicted = append(evicted, eviction.Namespace+"/"+eviction.Name)
+		if eviction.Name == "blocked" {
+			return true, nil, apierrors.NewTooManyRequests("budget", 10)
+		}
+		return true, nil, nil
+	})
+
+	r := &NodeChaosReconciler{Client: c, Scheme: c.Scheme(), Clientset: clientset}
+	// Polls of the same outage retry the blocked pod.
+	for i := 0; i < 3; i++ {
+		if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(policy)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sort.Strings(evicted)
+	want := []string{"default/blocked", "default/blocked", "default/blocked", "default/web", "default/web", "default/web"}
+	if !reflect.DeepEqual(evicted, want) {
+		t.Errorf("evicted %v, want %v", evicted, want)
+	}
+
+	got := &nullpodytwofacev1.PodyTwoFace{}
+	if err := c.Get(ctx, client.ObjectKeyFromObject(policy), got); err != nil {
+		t.Fatal(err)
+	}
+	if status := got.Status.Node; status.Remaining != 2 || !reflect.DeepEqual(status.Blocked, []string{"default/blocked"}) {
+		t.Errorf("status = %+v, want 2 pods remaining and default/blocked blocked", status)
+	}
+
+	experiment := &nullpodytwofacev1.ChaosExperiment{}
+	if err := c.Get(ctx, client.ObjectKey{Namespace: "default", Name: "counting"}, experiment); err != nil {
+		t.Fatal(err)
+	}
+	if experiment.Status.EvictionBlocked != 1 {
+		t.Errorf("eviction blocked %d times, want once for the outage", experiment.Status.EvictionBlocked)
+	}
+}
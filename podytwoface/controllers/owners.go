@@ -0,0 +1,95 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// maxOwnerDepth stops owner walks on broken or cyclic owner references.
+const maxOwnerDepth = 5
+
+// walkableOwners are the owner kinds we can read. Reading anything else through
+// the cache would start an informer we have no RBAC for.
+var walkableOwners = map[schema.GroupKind]bool{
+	{Group: "apps", Kind: "ReplicaSet"}:  true,
+	{Group: "apps", Kind: "Deployment"}:  true,
+	{Group: "apps", Kind: "StatefulSet"}: true,
+	{Group: "apps", Kind: "DaemonSet"}:   true,
+	{Group: "batch", Kind: "Job"}:        true,
+	{Group: "batch", Kind: "CronJob"}:    true,
+}
+
+// owner is one link in the ownership chain of a pod.
+type owner struct {
+	ref metav1.OwnerReference
+	// obj is nil when the owner could not be read.
+	obj *metav1.PartialObjectMetadata
+}
+
+// ownerChain walks the controller references up from obj and returns its
+// owners, closest first. The walk stops at the first owner that is gone, or
+// of a kind we do not read, which is still included in the chain without its
+// object. Failing to read an owner is an error: the owner might be immune.
+func ownerChain(ctx context.Context, c client.Reader, obj metav1.Object) ([]owner, error) {
+	chain := []owner{}
+
+	for i := 0; i < maxOwnerDepth; i++ {
+		ref := metav1.GetControllerOf(obj)
+		if ref == nil {
+			break
+		}
+
+		gvk := schema.FromAPIVersionAndKind(ref.APIVersion, ref.Kind)
+		if !walkableOwners[gvk.GroupKind()] {
+			chain = append(chain, owner{ref: *ref})
+			break
+		}
+
+		o := &metav1.PartialObjectMetadata{}
+		o.SetGroupVersionKind(gvk)
+		if err := c.Get(ctx, client.ObjectKey{Namespace: obj.GetNamespace(), Name: ref.Name}, o); err != nil {
+			if !apierrors.IsNotFound(err) {
+				return nil, fmt.Errorf("reading owner %s %s: %w", ref.Kind, ref.Name, err)
+			}
+			// Gone already. This is as far up as we get.
+			chain = append(chain, owner{ref: *ref})
+			break
+		}
+
+		chain = append(chain, owner{ref: *ref, obj: o})
+		obj = o
+	}
+
+	return chain, nil
+}
+
+// workloadKey names the top-level owner of a pod, as namespace/kind/name.
+// A pod without owners is its own workload.
+func workloadKey(pod metav1.Object, chain []owner) string {
+	if len(chain) == 0 {
+		return fmt.Sprintf("%s/Pod/%s", pod.GetNamespace(), pod.GetName())
+	}
+	top := chain[len(chain)-1].ref
+	return fmt.Sprintf("%s/%s/%s", pod.GetNamespace(), top.Kind, top.Name)
+}
@@ -0,0 +1,174 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	nullpodytwofacev1 "github.com/null-channel/stupid-kube-operators/podytwoface/api/v1"
+)
+
+// failingClient fails reading objects of kind.
+type failingClient struct {
+	client.Client
+	kind string
+	err  error
+}
+
+func (c *failingClient) Get(ctx context.Context, key client.ObjectKey, obj client.Object) error {
+	if obj.GetObjectKind().GroupVersionKind().Kind == c.kind {
+		return c.err
+	}
+	return c.Client.Get(ctx, key, obj)
+}
+
+// controlledBy returns a controller reference to obj, of kind.
+func controlledBy(kind string, obj metav1.Object) []metav1.OwnerReference {
+	controller := true
+	return []metav1.OwnerReference{{
+		APIVersion: "apps/v1",
+		Kind:       kind,
+		Name:       obj.GetName(),
+		UID:        obj.GetUID(),
+		Controller: &controller,
+	}}
+}
+
+// deploymentPod returns a pod of a ReplicaSet of a Deployment, with the
+// Deployment immune when immune says so.
+func deploymentPod(immune bool) (*v1.Pod, *appsv1.ReplicaSet, *appsv1.Deployment) {
+	deployment := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", UID: "deployment"}}
+	if immune {
+		deployment.Annotations = map[string]string{nullpodytwofacev1.ImmuneAnnotation: "true"}
+	}
+	replicaSet := &appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{
+		Name: "web-5d4f", Namespace: "default", UID: "replicaset",
+		OwnerReferences: controlledBy("Deployment", deployment),
+	}}
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Name: "web-5d4f-x7k2p", Namespace: "default",
+		OwnerReferences: controlledBy("ReplicaSet", replicaSet),
+	}}
+	return pod, replicaSet, deployment
+}
+
+func TestOwnerChain(t *testing.T) {
+	pod, replicaSet, deployment := deploymentPod(false)
+
+	tests := []struct {
+		name      string
+		objs      []client.Object
+		failKind  string
+		wantKinds []string
+		wantRead  []bool
+		wantErr   bool
+	}{
+		{
+			name:      "walks up to the top",
+			objs:      []client.Object{replicaSet, deployment},
+			wantKinds: []string{"ReplicaSet", "Deployment"},
+			wantRead:  []bool{true, true},
+		},
+		{
+			name:      "stops at owners that are gone",
+			objs:      []client.Object{replicaSet},
+			wantKinds: []string{"ReplicaSet", "Deployment"},
+			wantRead:  []bool{true, false},
+		},
+		{
+			name:     "fails on owners it can not read",
+			objs:     []client.Object{replicaSet, deployment},
+			failKind: "Deployment",
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var c client.Client = fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(tt.objs...).Build()
+			if tt.failKind != "" {
+				c = &failingClient{Client: c, kind: tt.failKind, err: apierrors.NewForbidden(schema.GroupResource{Group: "apps", Resource: "deployments"}, "web", nil)}
+			}
+
+			chain, err := ownerChain(context.Background(), c, pod)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ownerChain() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(chain) != len(tt.wantKinds) {
+				t.Fatalf("ownerChain() = %d owners, want %d", len(chain), len(tt.wantKinds))
+			}
+			for i, o := range chain {
+				if o.ref.Kind != tt.wantKinds[i] || (o.obj != nil) != tt.wantRead[i] {
+					t.Errorf("owner %d = %s, read: %v, want %s, read: %v", i, o.ref.Kind, o.obj != nil, tt.wantKinds[i], tt.wantRead[i])
+				}
+			}
+		})
+	}
+}
+
+func TestReconcileSparesPodsOfImmuneOwners(t *testing.T) {
+	tests := []struct {
+		name     string
+		immune   bool
+		failKind string
+		wantErr  bool
+	}{
+		{name: "immune deployment", immune: true},
+		{name: "unreadable deployment", immune: true, failKind: "Deployment", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			policy := &nullpodytwofacev1.PodyTwoFace{
+				ObjectMeta: metav1.ObjectMeta{Name: "delete"},
+				Spec:       nullpodytwofacev1.PodyTwoFaceSpec{Target: nullpodytwofacev1.TargetPod},
+			}
+			pod, replicaSet, deployment := deploymentPod(tt.immune)
+			namespace := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}}
+			var c client.Client = fake.NewClientBuilder().WithScheme(newTestScheme(t)).
+				WithObjects(policy, pod, replicaSet, deployment, namespace).Build()
+			if tt.failKind != "" {
+				c = &failingClient{Client: c, kind: tt.failKind, err: apierrors.NewTimeoutError("cache not synced", 1)}
+			}
+
+			r := &PodyTwoFaceReconciler{Client: c, Scheme: c.Scheme()}
+			_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Reconcile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			got := &v1.Pod{}
+			if err := c.Get(ctx, client.ObjectKeyFromObject(pod), got); err != nil {
+				t.Fatalf("pod is gone: %v", err)
+			}
+			if decision := decisionOf(got, policy); decision != nil {
+				t.Errorf("decision = %+v, want the pod left alone", decision)
+			}
+		})
+	}
+}
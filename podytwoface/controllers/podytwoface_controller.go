@@ -18,41 +18,66 @@ package controllers
 
 import (
 	"context"
-	"math/rand"
+	"errors"
+	"sort"
+	"time"
 
 	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+
+	nullpodytwofacev1 "github.com/null-channel/stupid-kube-operators/podytwoface/api/v1"
 )
 
 // PodyTwoFaceReconciler reconciles a PodyTwoFace object
 type PodyTwoFaceReconciler struct {
 	client.Client
 	Scheme *runtime.Scheme
+	// Clientset reaches the pod subresources the controller-runtime client can not:
+	// evictions and ephemeral containers.
+	Clientset kubernetes.Interface
 }
 
 //+kubebuilder:rbac:groups=nullpodytwoface.thenullchannel.dev,resources=podytwofaces,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=nullpodytwoface.thenullchannel.dev,resources=podytwofaces/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=nullpodytwoface.thenullchannel.dev,resources=podytwofaces/finalizers,verbs=update
 //+kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch;create;patch;delete
+//+kubebuilder:rbac:groups=core,resources=pods/eviction,verbs=create
+//+kubebuilder:rbac:groups=core,resources=pods/ephemeralcontainers,verbs=get;update
+//+kubebuilder:rbac:groups=core,resources=namespaces,verbs=get;list;watch
+//+kubebuilder:rbac:groups=nullpodytwoface.thenullchannel.dev,resources=chaosexperiments,verbs=get;list;watch
+//+kubebuilder:rbac:groups=nullpodytwoface.thenullchannel.dev,resources=chaosexperiments/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=apps,resources=replicasets,verbs=get;list;watch
+//+kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch
+//+kubebuilder:rbac:groups=apps,resources=statefulsets,verbs=get;list;watch
+//+kubebuilder:rbac:groups=apps,resources=daemonsets,verbs=get;list;watch
+//+kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch
+//+kubebuilder:rbac:groups=batch,resources=cronjobs,verbs=get;list;watch
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
-// TODO(user): Modify the Reconcile function to compare the state specified by
-// the PodyTwoFace object against the actual cluster state, and then
-// perform operations to make the cluster state reflect the state specified by
-// the user.
+//
+// Every pod gets one coin flip from the first PodyTwoFace policy (by name)
+// that selects it, and the outcome is recorded on the pod. Pods that are
+// immune, themselves or through an owner or their namespace, are left alone, and
+// so are critical pods unless the policy allows them. Condemned pods get
+// the fault of the policy injected, unless that would break one of the
+// blast-radius limits of the policy, in which case the pod is requeued for
+// when the limit has cleared. Policies aborted by SteadyStateReconciler do
+// nothing at all.
 //
 // For more details, check Reconcile and its Result here:
 // - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.8.3/pkg/reconcile
 func (r *PodyTwoFaceReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
-	_ = log.FromContext(ctx)
+	logger := log.FromContext(ctx)
 
-	// your logic here
 	pod := &v1.Pod{}
 	err := r.Client.Get(ctx, req.NamespacedName, pod)
 
@@ -65,26 +90,243 @@ func (r *PodyTwoFaceReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 		return ctrl.Result{}, err
 	}
 
-	face := rand.Intn(3)
+	if !pod.DeletionTimestamp.IsZero() {
+		// Already on its way out.
+		return ctrl.Result{}, nil
+	}
+
+	namespace := &v1.Namespace{}
+	if err := r.Get(ctx, client.ObjectKey{Name: pod.Namespace}, namespace); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	policy, err := r.policyFor(ctx, pod, namespace)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	if policy == nil {
+		// Nobody asked for this pod to be flipped.
+		return ctrl.Result{}, nil
+	}
+
+	if policyAborted(policy) {
+		// The steady state was lost on this policy's watch. Hands off until somebody looks.
+		return ctrl.Result{}, nil
+	}
+
+	chain, err := ownerChain(ctx, r.Client, pod)
+	if err != nil {
+		// Without the owners there is no telling whether the pod is immune.
+		return ctrl.Result{}, err
+	}
+	if why := immunity(pod, namespace, chain); why != "" {
+		logger.V(1).Info("skipping immune pod", "policy", policy.Name, "immuneThrough", why)
+		return ctrl.Result{}, nil
+	}
+
+	if !policy.Spec.AllowCriticalPods {
+		// The webhook only saw the pods of the time the policy was applied.
+		why, err := critical(ctx, r.Client, target{pod: pod, chain: chain})
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if why != "" {
+			logger.V(1).Info("skipping critical pod", "policy", policy.Name, "reason", why)
+			return ctrl.Result{}, nil
+		}
+	}
+
+	now := time.Now()
+
+	decision := decisionOf(pod, policy)
+	if decision != nil {
+		next, ok := nextRoll(decision, policy)
+		switch {
+		case decision.Verdict == nullpodytwofacev1.VerdictCondemned && decision.ExecutedAt == nil:
+			// Condemned earlier but held back by the limits. No second chances.
+		case !ok:
+			// The coin has been flipped for this pod, and that is that.
+			return ctrl.Result{}, nil
+		case now.Before(next):
+			return ctrl.Result{RequeueAfter: next.Sub(now)}, nil
+		default:
+			decision = nil
+		}
+	}
+
+	if decision == nil {
+		decision = roll(policy, now)
+		if err := r.recordDecision(ctx, pod, decision); err != nil {
+			if apierrors.IsConflict(err) {
+				// Our copy of the pod is stale, and may miss a decision. Look again.
+				return ctrl.Result{Requeue: true}, nil
+			}
+			return ctrl.Result{}, err
+		}
+
+		o := outcomeCondemned
+		if decision.Verdict == nullpodytwofacev1.VerdictSpared {
+			o = outcomeSpared
+		}
+		recordOutcome(ctx, r.Client, policy.Name, o, "", now)
+	}
+
+	if decision.Verdict == nullpodytwofacev1.VerdictSpared {
+		if next, ok := nextRoll(decision, policy); ok {
+			return ctrl.Result{RequeueAfter: next.Sub(now)}, nil
+		}
+		return ctrl.Result{}, nil
+	}
+
+	fault, err := r.faultFor(policy.Spec.Fault.Type)
+	if err != nil {
+		// Only possible when the CRD validation was bypassed. Retrying will not help.
+		logger.Error(err, "ignoring policy", "policy", policy.Name)
+		return ctrl.Result{}, nil
+	}
+
+	radius, err := r.blastRadius(ctx, pod, chain)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	if wait, reason := checkLimits(policy, radius, now); wait > 0 {
+		logger.Info("holding back condemned pod, policy limit reached", "policy", policy.Name, "reason", reason, "retryAfter", wait)
+		return ctrl.Result{RequeueAfter: wait}, nil
+	}
+
+	// Count the fault before injecting it. If the injection fails we have been
+	// too careful, if the status update fails nothing happened.
+	recordKill(policy, radius.workload, now)
+	if err := r.Status().Update(ctx, policy); err != nil {
+		return ctrl.Result{}, err
+	}
 
-	if face > 1 {
-		r.Client.Delete(ctx, pod)
+	executed := metav1.NewTime(now)
+	decision.ExecutedAt = &executed
+	if err := r.recordDecision(ctx, pod, decision); err != nil {
+		if apierrors.IsConflict(err) {
+			return ctrl.Result{Requeue: true}, nil
+		}
+		return ctrl.Result{}, err
+	}
+
+	logger.Info("injecting fault", "policy", policy.Name, "fault", policy.Spec.Fault.Type, "workload", radius.workload)
+	if err := fault.Inject(ctx, pod, policy.Spec.Fault); err != nil {
+		// Nothing was done to the pod, which is still condemned. Take the
+		// execution back so the next try gets to inject the fault.
+		if recordErr := r.takeBackExecution(ctx, pod, decision); recordErr != nil {
+			return ctrl.Result{}, recordErr
+		}
+
+		if errors.Is(err, errEvictionBlocked) {
+			// The budget said no. Try again later.
+			logger.Info("eviction blocked", "policy", policy.Name, "workload", radius.workload)
+			recordOutcome(ctx, r.Client, policy.Name, outcomeEvictionBlocked, radius.workload, now)
+			return ctrl.Result{RequeueAfter: unavailableRequeue}, nil
+		}
+		return ctrl.Result{}, err
+	}
+
+	recordOutcome(ctx, r.Client, policy.Name, outcomeKilled, radius.workload, now)
+
+	if next, ok := nextRoll(decision, policy); ok {
+		return ctrl.Result{RequeueAfter: next.Sub(now)}, nil
 	}
 
 	return ctrl.Result{}, nil
 }
 
+// policyFor returns the policy that decides the fate of pod, or nil if no
+// policy selects it. When several policies select a pod the first one by name
+// wins, so a pod only ever gets one coin flip. OptIn policies only select pods
+// in namespaces that volunteered.
+func (r *PodyTwoFaceReconciler) policyFor(ctx context.Context, pod *v1.Pod, namespace *v1.Namespace) (*nullpodytwofacev1.PodyTwoFace, error) {
+	logger := log.FromContext(ctx)
+
+	policies := &nullpodytwofacev1.PodyTwoFaceList{}
+	if err := r.List(ctx, policies); err != nil {
+		return nil, err
+	}
+
+	if len(policies.Items) == 0 {
+		return nil, nil
+	}
+
+	sort.Slice(policies.Items, func(i, j int) bool {
+		return policies.Items[i].Name < policies.Items[j].Name
+	})
+
+	for i := range policies.Items {
+		policy := &policies.Items[i]
+		if policy.Spec.Target == nullpodytwofacev1.TargetNode {
+			continue
+		}
+
+		podMatch, err := selectorMatches(policy.Spec.Selector, pod.Labels)
+		if err != nil {
+			logger.Error(err, "ignoring policy with invalid selector", "policy", policy.Name)
+			continue
+		}
+
+		namespaceMatch, err := selectorMatches(policy.Spec.NamespaceSelector, namespace.Labels)
+		if err != nil {
+			logger.Error(err, "ignoring policy with invalid namespace selector", "policy", policy.Name)
+			continue
+		}
+
+		if podMatch && namespaceMatch && volunteered(policy, namespace) {
+			return policy, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// blastRadius sizes up the damage already done around pod.
+func (r *PodyTwoFaceReconciler) blastRadius(ctx context.Context, pod *v1.Pod, chain []owner) (blastRadius, error) {
+	radius := blastRadius{workload: workloadKey(pod, chain)}
+
+	neighbours := &v1.PodList{}
+	if err := r.List(ctx, neighbours, client.InNamespace(pod.Namespace)); err != nil {
+		return radius, err
+	}
+
+	controller := metav1.GetControllerOf(pod)
+	for i := range neighbours.Items {
+		neighbour := &neighbours.Items[i]
+		if !isUnavailable(neighbour) {
+			continue
+		}
+
+		radius.namespaceUnavailable++
+
+		if ref := metav1.GetControllerOf(neighbour); controller != nil && ref != nil && ref.UID == controller.UID {
+			radius.ownerUnavailable++
+		}
+	}
+
+	return radius, nil
+}
+
+// selectorMatches reports whether selector matches set. A nil selector matches everything.
+func selectorMatches(selector *metav1.LabelSelector, set map[string]string) (bool, error) {
+	if selector == nil {
+		return true, nil
+	}
+
+	s, err := metav1.LabelSelectorAsSelector(selector)
+	if err != nil {
+		return false, err
+	}
+
+	return s.Matches(labels.Set(set)), nil
+}
+
 // SetupWithManager sets up the controller with the Manager.
 func (r *PodyTwoFaceReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&v1.Pod{}).
-		/*
-			Watches(
-				&source.Kind{Type: &v1.Pod{}},
-				&handler.EnqueueRequestForObject{},
-			).
-		*/
-		// Uncomment the following line adding a pointer to an instance of the controlled resource as an argument
-		// For().
 		Complete(r)
 }
@@ -0,0 +1,262 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	k8stesting "k8s.io/client-go/testing"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	nullpodytwofacev1 "github.com/null-channel/stupid-kube-operators/podytwoface/api/v1"
+)
+
+// newTestScheme returns a scheme with the built-in types and ours.
+func newTestScheme(t *testing.T) *runtime.Scheme {
+	s := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(s); err != nil {
+		t.Fatal(err)
+	}
+	if err := nullpodytwofacev1.AddToScheme(s); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// condemnedPod returns a pod policy condemned without executing it yet.
+func condemnedPod(t *testing.T, policy string) *v1.Pod {
+	data, err := json.Marshal(&nullpodytwofacev1.Decision{
+		Verdict:   nullpodytwofacev1.VerdictCondemned,
+		Policy:    policy,
+		DecidedAt: metav1.Now(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &v1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Name:        "web-1",
+		Namespace:   "default",
+		Labels:      map[string]string{"app": "web"},
+		Annotations: map[string]string{nullpodytwofacev1.DecisionAnnotation: string(data)},
+	}}
+}
+
+func TestReconcileInjectErrors(t *testing.T) {
+	tests := []struct {
+		name         string
+		evictErr     error
+		wantErr      bool
+		wantRequeue  bool
+		wantExecuted bool
+	}{
+		{
+			name:         "injected",
+			wantExecuted: true,
+		},
+		{
+			name:        "blocked by a budget",
+			evictErr:    apierrors.NewTooManyRequests("budget", 10),
+			wantRequeue: true,
+		},
+		{
+			name:     "failed",
+			evictErr: apierrors.NewInternalError(context.DeadlineExceeded),
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			policy := &nullpodytwofacev1.PodyTwoFace{
+				ObjectMeta: metav1.ObjectMeta{Name: "evict"},
+				Spec: nullpodytwofacev1.PodyTwoFaceSpec{
+					Target: nullpodytwofacev1.TargetPod,
+					Fault:  nullpodytwofacev1.FaultSpec{Type: nullpodytwofacev1.FaultEvict},
+				},
+			}
+			pod := condemnedPod(t, policy.Name)
+			namespace := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}}
+			c := fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(policy, pod, namespace).Build()
+
+			clientset := k8sfake.NewSimpleClientset()
+			clientset.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				if action.GetSubresource() != "eviction" {
+					return false, nil, nil
+				}
+				return true, nil, tt.evictErr
+			})
+
+			r := &PodyTwoFaceReconciler{Client: c, Scheme: c.Scheme(), Clientset: clientset}
+			result, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Reconcile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if (result.RequeueAfter > 0) != tt.wantRequeue {
+				t.Errorf("Reconcile() = %+v, want a requeue: %v", result, tt.wantRequeue)
+			}
+
+			got := &v1.Pod{}
+			if err := c.Get(ctx, client.ObjectKeyFromObject(pod), got); err != nil {
+				t.Fatal(err)
+			}
+			decision := decisionOf(got, policy)
+			if decision == nil || decision.Verdict != nullpodytwofacev1.VerdictCondemned {
+				t.Fatalf("decision = %+v, want the pod still condemned", decision)
+			}
+			if (decision.ExecutedAt != nil) != tt.wantExecuted {
+				t.Errorf("decision executed at %v, want executed: %v", decision.ExecutedAt, tt.wantExecuted)
+			}
+			if _, ok := nextRoll(decision, policy); !tt.wantExecuted && ok {
+				t.Errorf("pod with a failed fault may roll again, want it to stay condemned")
+			}
+		})
+	}
+}
+
+func TestReconcileHonoursSteadyStateAborts(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      int
+		wantDecided bool
+	}{
+		{name: "steady", status: http.StatusOK, wantDecided: true},
+		{name: "steady state lost", status: http.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(tt.status)
+			}))
+			defer server.Close()
+
+			policy := &nullpodytwofacev1.PodyTwoFace{
+				ObjectMeta: metav1.ObjectMeta{Name: "evict"},
+				Spec: nullpodytwofacev1.PodyTwoFaceSpec{
+					Target: nullpodytwofacev1.TargetPod,
+					Fault:  nullpodytwofacev1.FaultSpec{Type: nullpodytwofacev1.FaultEvict},
+					SteadyState: &nullpodytwofacev1.SteadyStateSpec{Checks: []nullpodytwofacev1.SteadyStateCheck{
+						{Name: "web", HTTP: &nullpodytwofacev1.HTTPCheck{URL: server.URL}},
+					}},
+				},
+			}
+			pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web-1", Namespace: "default"}}
+			namespace := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}}
+			c := fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(policy, pod, namespace).Build()
+
+			steadyState := &SteadyStateReconciler{Client: c, Scheme: c.Scheme(), HTTPClient: server.Client()}
+			if _, err := steadyState.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(policy)}); err != nil {
+				t.Fatal(err)
+			}
+
+			clientset := k8sfake.NewSimpleClientset()
+			clientset.PrependReactor("create", "pods", func(k8stesting.Action) (bool, runtime.Object, error) {
+				return true, nil, nil
+			})
+			r := &PodyTwoFaceReconciler{Client: c, Scheme: c.Scheme(), Clientset: clientset}
+			if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)}); err != nil {
+				t.Fatal(err)
+			}
+
+			got := &v1.Pod{}
+			if err := c.Get(ctx, client.ObjectKeyFromObject(pod), got); err != nil {
+				t.Fatal(err)
+			}
+			if decided := decisionOf(got, policy) != nil; decided != tt.wantDecided {
+				t.Errorf("pod decided on: %v, want %v", decided, tt.wantDecided)
+			}
+			if !tt.wantDecided && len(clientset.Actions()) > 0 {
+				t.Errorf("aborted policy injected a fault: %v", clientset.Actions())
+			}
+		})
+	}
+}
+
+// staleClient reads pod as it was before, like a cache that is behind.
+type staleClient struct {
+	client.Client
+	pod *v1.Pod
+}
+
+func (c *staleClient) Get(ctx context.Context, key client.ObjectKey, obj client.Object) error {
+	if pod, ok := obj.(*v1.Pod); ok && key == client.ObjectKeyFromObject(c.pod) {
+		c.pod.DeepCopyInto(pod)
+		return nil
+	}
+	return c.Client.Get(ctx, key, obj)
+}
+
+func TestReconcileOnStalePod(t *testing.T) {
+	ctx := context.Background()
+	policy := &nullpodytwofacev1.PodyTwoFace{
+		ObjectMeta: metav1.ObjectMeta{Name: "evict"},
+		Spec: nullpodytwofacev1.PodyTwoFaceSpec{
+			Target: nullpodytwofacev1.TargetPod,
+			Fault:  nullpodytwofacev1.FaultSpec{Type: nullpodytwofacev1.FaultEvict},
+		},
+	}
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web-1", Namespace: "default"}}
+	namespace := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}}
+	c := fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(policy, pod, namespace).Build()
+
+	stale := &v1.Pod{}
+	if err := c.Get(ctx, client.ObjectKeyFromObject(pod), stale); err != nil {
+		t.Fatal(err)
+	}
+	// Another reconcile decided on the pod, which the cache does not show yet.
+	decided := condemnedPod(t, policy.Name)
+	decided.ResourceVersion = stale.ResourceVersion
+	if err := c.Update(ctx, decided); err != nil {
+		t.Fatal(err)
+	}
+	want := decided.Annotations[nullpodytwofacev1.DecisionAnnotation]
+
+	clientset := k8sfake.NewSimpleClientset()
+	r := &PodyTwoFaceReconciler{Client: &staleClient{Client: c, pod: stale}, Scheme: c.Scheme(), Clientset: clientset}
+	result, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Requeue {
+		t.Errorf("Reconcile() = %+v, want a requeue to look again", result)
+	}
+
+	got := &v1.Pod{}
+	if err := c.Get(ctx, client.ObjectKeyFromObject(pod), got); err != nil {
+		t.Fatal(err)
+	}
+	if annotation := got.Annotations[nullpodytwofacev1.DecisionAnnotation]; annotation != want {
+		t.Errorf("decision = %s, want the first one kept: %s", annotation, want)
+	}
+	if len(clientset.Actions()) > 0 {
+		t.Errorf("stale reconcile injected a fault: %v", clientset.Actions())
+	}
+}
@@ -0,0 +1,228 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	nullpodytwofacev1 "github.com/null-channel/stupid-kube-operators/podytwoface/api/v1"
+)
+
+const validatePodyTwoFacePath = "/validate-nullpodytwoface-thenullchannel-dev-v1-podytwoface"
+
+// PodyTwoFaceValidator keeps PodyTwoFace policies away from the pods a cluster
+// can not do without, and tells whoever applies a policy how many pods it is
+// about to put at risk.
+type PodyTwoFaceValidator struct {
+	Client  client.Client
+	decoder *admission.Decoder
+}
+
+//+kubebuilder:webhook:path=/validate-nullpodytwoface-thenullchannel-dev-v1-podytwoface,mutating=false,failurePolicy=fail,sideEffects=None,groups=nullpodytwoface.thenullchannel.dev,resources=podytwofaces,verbs=create;update,versions=v1,name=vpodytwoface.kb.io,admissionReviewVersions={v1,v1beta1}
+
+// target is a pod a policy could hit, with its owners.
+type target struct {
+	pod   *v1.Pod
+	chain []owner
+}
+
+// Handle refuses policies whose namespace selector takes in kube-system, and
+// policies that select mirror pods, DaemonSet pods or pods of single-replica
+// Deployments, unless the policy allows critical pods. Pods only start being
+// critical later are left alone by PodyTwoFaceReconciler, which checks again.
+// Allowed policies get a warning with the number of pods, or nodes for Node
+// policies, they match right now.
+func (v *PodyTwoFaceValidator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	policy := &nullpodytwofacev1.PodyTwoFace{}
+	if err := v.decoder.Decode(req, policy); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	for _, selector := range []*metav1.LabelSelector{policy.Spec.Selector, policy.Spec.NamespaceSelector} {
+		if _, err := selectorMatches(selector, nil); err != nil {
+			return admission.Denied(err.Error())
+		}
+	}
+
+	if policy.Spec.Target == nullpodytwofacev1.TargetNode {
+		if _, err := selectorMatches(policy.Spec.NodeSelector, nil); err != nil {
+			return admission.Denied(err.Error())
+		}
+		nodes, err := nodeCandidates(ctx, v.Client, policy)
+		if err != nil {
+			return admission.Errored(http.StatusInternalServerError, err)
+		}
+		resp := admission.Allowed("")
+		resp.Warnings = []string{fmt.Sprintf("policy currently matches %d nodes", len(nodes))}
+		return resp
+	}
+
+	targets, err := matchingPods(ctx, v.Client, policy)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+
+	if !policy.Spec.AllowCriticalPods {
+		// Whether kube-system has pods the policy selects right now does not
+		// matter: it may have some tomorrow.
+		selected, err := selectsNamespace(ctx, v.Client, policy, metav1.NamespaceSystem)
+		if err != nil {
+			return admission.Errored(http.StatusInternalServerError, err)
+		}
+		if selected {
+			return admission.Denied(fmt.Sprintf("policy selects namespace %s. Leave it out with spec.namespaceSelector, "+
+				"or set spec.allowCriticalPods to target it anyway", metav1.NamespaceSystem))
+		}
+
+		for _, t := range targets {
+			why, err := critical(ctx, v.Client, t)
+			if err != nil {
+				return admission.Errored(http.StatusInternalServerError, err)
+			}
+			if why != "" {
+				return admission.Denied(fmt.Sprintf("policy selects %s/%s, %s. Set spec.allowCriticalPods to target it anyway",
+					t.pod.Namespace, t.pod.Name, why))
+			}
+		}
+	}
+
+	resp := admission.Allowed("")
+	resp.Warnings = []string{fmt.Sprintf("policy currently matches %d pods", len(targets))}
+	return resp
+}
+
+// selectsNamespace reports whether the pods of the namespace called name are
+// fair game for policy, as far as the namespace goes.
+func selectsNamespace(ctx context.Context, c client.Reader, policy *nullpodytwofacev1.PodyTwoFace, name string) (bool, error) {
+	namespace := &v1.Namespace{}
+	if err := c.Get(ctx, client.ObjectKey{Name: name}, namespace); err != nil {
+		return false, client.IgnoreNotFound(err)
+	}
+
+	match, err := selectorMatches(policy.Spec.NamespaceSelector, namespace.Labels)
+	if err != nil {
+		return false, err
+	}
+	return match && volunteered(policy, namespace), nil
+}
+
+// critical returns why the pod of t is too important to be hit, or "" when it is fair game.
+func critical(ctx context.Context, c client.Reader, t target) (string, error) {
+	if t.pod.Namespace == metav1.NamespaceSystem {
+		return "a kube-system pod", nil
+	}
+
+	if _, ok := t.pod.Annotations[v1.MirrorPodAnnotationKey]; ok {
+		return "a static pod", nil
+	}
+
+	for _, o := range t.chain {
+		switch schema.FromAPIVersionAndKind(o.ref.APIVersion, o.ref.Kind).GroupKind() {
+		case schema.GroupKind{Group: "apps", Kind: "DaemonSet"}:
+			return "a DaemonSet pod", nil
+		case schema.GroupKind{Group: "apps", Kind: "Deployment"}:
+			deployment := &appsv1.Deployment{}
+			if err := c.Get(ctx, client.ObjectKey{Namespace: t.pod.Namespace, Name: o.ref.Name}, deployment); err != nil {
+				if apierrors.IsNotFound(err) {
+					continue
+				}
+				return "", err
+			}
+			if deployment.Spec.Replicas == nil || *deployment.Spec.Replicas == 1 {
+				return fmt.Sprintf("the only pod of Deployment %s", deployment.Name), nil
+			}
+		}
+	}
+
+	return "", nil
+}
+
+// matchingPods returns the pods policy could hit right now, immune pods left
+// out. A pod selected by several policies is only ever hit by the first one by
+// name, so this is an upper bound.
+func matchingPods(ctx context.Context, c client.Reader, policy *nullpodytwofacev1.PodyTwoFace) ([]target, error) {
+	namespaces := &v1.NamespaceList{}
+	if err := c.List(ctx, namespaces); err != nil {
+		return nil, err
+	}
+
+	targets := []target{}
+	for i := range namespaces.Items {
+		namespace := &namespaces.Items[i]
+
+		match, err := selectorMatches(policy.Spec.NamespaceSelector, namespace.Labels)
+		if err != nil {
+			return nil, err
+		}
+		if !match || !volunteered(policy, namespace) {
+			continue
+		}
+
+		pods := &v1.PodList{}
+		if err := c.List(ctx, pods, client.InNamespace(namespace.Name)); err != nil {
+			return nil, err
+		}
+
+		for j := range pods.Items {
+			pod := &pods.Items[j]
+
+			match, err := selectorMatches(policy.Spec.Selector, pod.Labels)
+			if err != nil {
+				return nil, err
+			}
+			if !match || !pod.DeletionTimestamp.IsZero() {
+				continue
+			}
+
+			chain, err := ownerChain(ctx, c, pod)
+			if err != nil {
+				return nil, err
+			}
+			if immunity(pod, namespace, chain) != "" {
+				continue
+			}
+
+			targets = append(targets, target{pod: pod, chain: chain})
+		}
+	}
+
+	return targets, nil
+}
+
+// InjectDecoder implements admission.DecoderInjector.
+func (v *PodyTwoFaceValidator) InjectDecoder(d *admission.Decoder) error {
+	v.decoder = d
+	return nil
+}
+
+// SetupWebhookWithManager registers the webhook with the webhook server of the Manager.
+func (v *PodyTwoFaceValidator) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	mgr.GetWebhookServer().Register(validatePodyTwoFacePath, &webhook.Admission{Handler: v})
+	return nil
+}
@@ -0,0 +1,242 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	nullpodytwofacev1 "github.com/null-channel/stupid-kube-operators/podytwoface/api/v1"
+)
+
+// criticalFixtures returns namespaces default and kube-system, and a
+// DaemonSet and Deployments of one and three replicas in default.
+func criticalFixtures() []client.Object {
+	one, three := int32(1), int32(3)
+	return []client.Object{
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default", Labels: map[string]string{"kubernetes.io/metadata.name": "default"}}},
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "kube-system", Labels: map[string]string{"kubernetes.io/metadata.name": "kube-system"}}},
+		&appsv1.DaemonSet{ObjectMeta: metav1.ObjectMeta{Name: "agent", Namespace: "default", UID: "agent"}},
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "single", Namespace: "default", UID: "single"}, Spec: appsv1.DeploymentSpec{Replicas: &one}},
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", UID: "web"}, Spec: appsv1.DeploymentSpec{Replicas: &three}},
+	}
+}
+
+// ownedPod returns a pod called name in namespace, controlled by the apps/v1
+// kind called owner when kind is set.
+func ownedPod(namespace, name, kind, owner string) *v1.Pod {
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: map[string]string{"app": "web"}}}
+	if kind != "" {
+		pod.OwnerReferences = controlledBy(kind, &metav1.ObjectMeta{Name: owner, UID: "owner"})
+	}
+	return pod
+}
+
+func TestCritical(t *testing.T) {
+	mirror := ownedPod("default", "static", "", "")
+	mirror.Annotations = map[string]string{v1.MirrorPodAnnotationKey: "hash"}
+
+	tests := []struct {
+		name string
+		pod  *v1.Pod
+		want string
+	}{
+		{name: "bare pod", pod: ownedPod("default", "bare", "", "")},
+		{name: "kube-system pod", pod: ownedPod("kube-system", "dns", "", ""), want: "a kube-system pod"},
+		{name: "mirror pod", pod: mirror, want: "a static pod"},
+		{name: "DaemonSet pod", pod: ownedPod("default", "agent-x", "DaemonSet", "agent"), want: "a DaemonSet pod"},
+		{name: "only pod of a Deployment", pod: ownedPod("default", "single-x", "Deployment", "single"), want: "the only pod of Deployment single"},
+		{name: "one of many pods of a Deployment", pod: ownedPod("default", "web-x", "Deployment", "web")},
+		{name: "pod of a Deployment that is gone", pod: ownedPod("default", "gone-x", "Deployment", "gone")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			c := fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(criticalFixtures()...).Build()
+
+			chain, err := ownerChain(ctx, c, tt.pod)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := critical(ctx, c, target{pod: tt.pod, chain: chain})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("critical() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPodyTwoFaceValidatorHandle(t *testing.T) {
+	notKubeSystem := &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+		{Key: "kubernetes.io/metadata.name", Operator: metav1.LabelSelectorOpNotIn, Values: []string{"kube-system"}},
+	}}
+	web := &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}}
+
+	tests := []struct {
+		name    string
+		spec    nullpodytwofacev1.PodyTwoFaceSpec
+		pods    []client.Object
+		allowed bool
+		message string
+		warning string
+	}{
+		{
+			name:    "invalid selector",
+			spec:    nullpodytwofacev1.PodyTwoFaceSpec{Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"bad key!": "x"}}},
+			message: "bad key!",
+		},
+		{
+			name:    "namespace selector taking in kube-system, without pods there",
+			spec:    nullpodytwofacev1.PodyTwoFaceSpec{Selector: web},
+			message: "policy selects namespace kube-system",
+		},
+		{
+			name: "namespace selector taking in kube-system, allowed",
+			spec: nullpodytwofacev1.PodyTwoFaceSpec{Selector: web, AllowCriticalPods: true},
+			pods: []client.Object{
+				ownedPod("kube-system", "dns", "", ""),
+				ownedPod("default", "agent-x", "DaemonSet", "agent"),
+			},
+			allowed: true,
+			warning: "policy currently matches 2 pods",
+		},
+		{
+			name:    "opt-in policy, kube-system did not volunteer",
+			spec:    nullpodytwofacev1.PodyTwoFaceSpec{Selector: web, Targeting: nullpodytwofacev1.TargetingOptIn},
+			allowed: true,
+			warning: "policy currently matches 0 pods",
+		},
+		{
+			name:    "DaemonSet pod",
+			spec:    nullpodytwofacev1.PodyTwoFaceSpec{Selector: web, NamespaceSelector: notKubeSystem},
+			pods:    []client.Object{ownedPod("default", "agent-x", "DaemonSet", "agent")},
+			message: "policy selects default/agent-x, a DaemonSet pod",
+		},
+		{
+			name:    "only pod of a Deployment",
+			spec:    nullpodytwofacev1.PodyTwoFaceSpec{Selector: web, NamespaceSelector: notKubeSystem},
+			pods:    []client.Object{ownedPod("default", "single-x", "Deployment", "single")},
+			message: "the only pod of Deployment single",
+		},
+		{
+			name: "fair game",
+			spec: nullpodytwofacev1.PodyTwoFaceSpec{Selector: web, NamespaceSelector: notKubeSystem},
+			pods: []client.Object{
+				ownedPod("default", "web-x", "Deployment", "web"),
+				ownedPod("default", "web-y", "Deployment", "web"),
+			},
+			allowed: true,
+			warning: "policy currently matches 2 pods",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestScheme(t)
+			c := fake.NewClientBuilder().WithScheme(s).WithObjects(append(criticalFixtures(), tt.pods...)...).Build()
+			decoder, err := admission.NewDecoder(s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			v := &PodyTwoFaceValidator{Client: c}
+			if err := v.InjectDecoder(decoder); err != nil {
+				t.Fatal(err)
+			}
+
+			policy := &nullpodytwofacev1.PodyTwoFace{
+				TypeMeta:   metav1.TypeMeta{APIVersion: nullpodytwofacev1.GroupVersion.String(), Kind: "PodyTwoFace"},
+				ObjectMeta: metav1.ObjectMeta{Name: "policy"},
+				Spec:       tt.spec,
+			}
+			raw, err := json.Marshal(policy)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			resp := v.Handle(context.Background(), admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+				Operation: admissionv1.Create,
+				Object:    runtime.RawExtension{Raw: raw},
+			}})
+			if resp.Allowed != tt.allowed {
+				t.Fatalf("Handle() allowed = %v, want %v: %+v", resp.Allowed, tt.allowed, resp.Result)
+			}
+			if tt.message != "" && (resp.Result == nil || !strings.Contains(string(resp.Result.Reason), tt.message)) {
+				t.Errorf("Handle() result = %+v, want a message with %q", resp.Result, tt.message)
+			}
+			if tt.warning != "" && (len(resp.Warnings) != 1 || resp.Warnings[0] != tt.warning) {
+				t.Errorf("Handle() warnings = %v, want %q", resp.Warnings, tt.warning)
+			}
+		})
+	}
+}
+
+func TestReconcileSparesCriticalPods(t *testing.T) {
+	tests := []struct {
+		name        string
+		allow       bool
+		wantDecided bool
+	}{
+		{name: "critical pods not allowed"},
+		{name: "critical pods allowed", allow: true, wantDecided: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			policy := &nullpodytwofacev1.PodyTwoFace{
+				ObjectMeta: metav1.ObjectMeta{Name: "label-flip"},
+				Spec: nullpodytwofacev1.PodyTwoFaceSpec{
+					Target:            nullpodytwofacev1.TargetPod,
+					Fault:             nullpodytwofacev1.FaultSpec{Type: nullpodytwofacev1.FaultLabelFlip},
+					AllowCriticalPods: tt.allow,
+				},
+			}
+			// Admitted while the Deployment had more replicas.
+			pod := ownedPod("default", "single-x", "Deployment", "single")
+			c := fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(append(criticalFixtures(), policy, pod)...).Build()
+
+			r := &PodyTwoFaceReconciler{Client: c, Scheme: c.Scheme()}
+			if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)}); err != nil {
+				t.Fatal(err)
+			}
+
+			got := &v1.Pod{}
+			if err := c.Get(ctx, client.ObjectKeyFromObject(pod), got); err != nil {
+				t.Fatal(err)
+			}
+			if decided := decisionOf(got, policy) != nil; decided != tt.wantDecided {
+				t.Errorf("pod decided on: %v, want %v", decided, tt.wantDecided)
+			}
+		})
+	}
+}
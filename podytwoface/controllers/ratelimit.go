@@ -0,0 +1,132 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	nullpodytwofacev1 "github.com/null-channel/stupid-kube-operators/podytwoface/api/v1"
+)
+
+// killWindow is the rolling window MaxKillsPerMinute is counted over.
+const killWindow = time.Minute
+
+// unavailableRequeue is how long a pod waits when too many of its neighbours
+// are down. There is no event telling us when they come back, so we poll.
+const unavailableRequeue = 30 * time.Second
+
+// blastRadius is what a kill would add to, as seen from the victim pod.
+type blastRadius struct {
+	// workload is the top-level owner of the victim, for the cooldown.
+	workload string
+	// namespaceUnavailable is the number of unavailable pods in the victim's namespace.
+	namespaceUnavailable int
+	// ownerUnavailable is the number of unavailable pods sharing the victim's controller.
+	ownerUnavailable int
+}
+
+// checkLimits returns how long a kill has to wait under the limits of policy,
+// and which limit is holding it back. A zero duration means kill away.
+func checkLimits(policy *nullpodytwofacev1.PodyTwoFace, b blastRadius, now time.Time) (time.Duration, string) {
+	limits := policy.Spec.Limits
+
+	if limits.MaxUnavailablePerNamespace > 0 && b.namespaceUnavailable >= int(limits.MaxUnavailablePerNamespace) {
+		return unavailableRequeue, fmt.Sprintf("%d pods unavailable in namespace", b.namespaceUnavailable)
+	}
+
+	if limits.MaxUnavailablePerOwner > 0 && b.ownerUnavailable >= int(limits.MaxUnavailablePerOwner) {
+		return unavailableRequeue, fmt.Sprintf("%d pods unavailable in owner", b.ownerUnavailable)
+	}
+
+	if limits.MaxKillsPerMinute > 0 {
+		recent := recentKills(policy.Status.RecentKills, now)
+		if len(recent) >= int(limits.MaxKillsPerMinute) {
+			// Wait for the oldest kill to fall out of the window.
+			return recent[0].Add(killWindow).Sub(now), fmt.Sprintf("%d kills in the last minute", len(recent))
+		}
+	}
+
+	if limits.Cooldown != nil {
+		for _, w := range policy.Status.Workloads {
+			if w.Workload != b.workload {
+				continue
+			}
+			if until := w.LastKillTime.Add(limits.Cooldown.Duration); until.After(now) {
+				return until.Sub(now), fmt.Sprintf("workload %s is cooling down", b.workload)
+			}
+		}
+	}
+
+	return 0, ""
+}
+
+// recordKill notes a kill in workload in the status of policy, and forgets
+// about kills that no longer count against any limit.
+func recordKill(policy *nullpodytwofacev1.PodyTwoFace, workload string, now time.Time) {
+	status := &policy.Status
+
+	status.RecentKills = append(recentKills(status.RecentKills, now), metav1.NewTime(now))
+	status.Kills++
+
+	cooldown := time.Duration(0)
+	if policy.Spec.Limits.Cooldown != nil {
+		cooldown = policy.Spec.Limits.Cooldown.Duration
+	}
+
+	workloads := []nullpodytwofacev1.WorkloadKill{}
+	for _, w := range status.Workloads {
+		if w.Workload != workload && w.LastKillTime.Add(cooldown).After(now) {
+			workloads = append(workloads, w)
+		}
+	}
+	if cooldown > 0 {
+		workloads = append(workloads, nullpodytwofacev1.WorkloadKill{Workload: workload, LastKillTime: metav1.NewTime(now)})
+	}
+	status.Workloads = workloads
+}
+
+// recentKills returns the kills that are still inside the kill window.
+func recentKills(kills []metav1.Time, now time.Time) []metav1.Time {
+	recent := []metav1.Time{}
+	for _, k := range kills {
+		if k.Add(killWindow).After(now) {
+			recent = append(recent, k)
+		}
+	}
+	return recent
+}
+
+// isUnavailable reports whether pod counts as down for the unavailability limits.
+func isUnavailable(pod *v1.Pod) bool {
+	if pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed {
+		// Finished pods are not supposed to be serving.
+		return false
+	}
+	if !pod.DeletionTimestamp.IsZero() {
+		return true
+	}
+	for _, c := range pod.Status.Conditions {
+		if c.Type == v1.PodReady {
+			return c.Status != v1.ConditionTrue
+		}
+	}
+	return true
+}
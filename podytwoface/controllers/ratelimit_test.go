@@ -0,0 +1,225 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	nullpodytwofacev1 "github.com/null-channel/stupid-kube-operators/podytwoface/api/v1"
+)
+
+func TestCheckLimits(t *testing.T) {
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	ago := func(d time.Duration) metav1.Time {
+		return metav1.NewTime(now.Add(-d))
+	}
+
+	tests := []struct {
+		name   string
+		limits nullpodytwofacev1.PodyTwoFaceLimits
+		status nullpodytwofacev1.PodyTwoFaceStatus
+		radius blastRadius
+		wait   time.Duration
+		reason string
+	}{
+		{
+			name:   "no limits",
+			radius: blastRadius{workload: "default/web", namespaceUnavailable: 10, ownerUnavailable: 10},
+			status: nullpodytwofacev1.PodyTwoFaceStatus{RecentKills: []metav1.Time{ago(time.Second), ago(2 * time.Second)}},
+		},
+		{
+			name:   "namespace below its cap",
+			limits: nullpodytwofacev1.PodyTwoFaceLimits{MaxUnavailablePerNamespace: 2},
+			radius: blastRadius{namespaceUnavailable: 1},
+		},
+		{
+			name:   "namespace at its cap",
+			limits: nullpodytwofacev1.PodyTwoFaceLimits{MaxUnavailablePerNamespace: 2},
+			radius: blastRadius{namespaceUnavailable: 2},
+			wait:   unavailableRequeue,
+			reason: "2 pods unavailable in namespace",
+		},
+		{
+			name:   "owner at its cap",
+			limits: nullpodytwofacev1.PodyTwoFaceLimits{MaxUnavailablePerOwner: 1},
+			radius: blastRadius{namespaceUnavailable: 3, ownerUnavailable: 1},
+			wait:   unavailableRequeue,
+			reason: "1 pods unavailable in owner",
+		},
+		{
+			name:   "kills that fell out of the window do not count",
+			limits: nullpodytwofacev1.PodyTwoFaceLimits{MaxKillsPerMinute: 2},
+			status: nullpodytwofacev1.PodyTwoFaceStatus{RecentKills: []metav1.Time{ago(2 * time.Minute), ago(killWindow), ago(10 * time.Second)}},
+		},
+		{
+			name:   "too many kills waits for the oldest to leave the window",
+			limits: nullpodytwofacev1.PodyTwoFaceLimits{MaxKillsPerMinute: 2},
+			status: nullpodytwofacev1.PodyTwoFaceStatus{RecentKills: []metav1.Time{ago(40 * time.Second), ago(10 * time.Second)}},
+			wait:   20 * time.Second,
+			reason: "2 kills in the last minute",
+		},
+		{
+			name:   "workload cooling down",
+			limits: nullpodytwofacev1.PodyTwoFaceLimits{Cooldown: &metav1.Duration{Duration: 5 * time.Minute}},
+			status: nullpodytwofacev1.PodyTwoFaceStatus{Workloads: []nullpodytwofacev1.WorkloadKill{
+				{Workload: "default/web", LastKillTime: ago(time.Minute)},
+			}},
+			radius: blastRadius{workload: "default/web"},
+			wait:   4 * time.Minute,
+			reason: "workload default/web is cooling down",
+		},
+		{
+			name:   "other workloads cooling down do not count",
+			limits: nullpodytwofacev1.PodyTwoFaceLimits{Cooldown: &metav1.Duration{Duration: 5 * time.Minute}},
+			status: nullpodytwofacev1.PodyTwoFaceStatus{Workloads: []nullpodytwofacev1.WorkloadKill{
+				{Workload: "default/db", LastKillTime: ago(time.Minute)},
+			}},
+			radius: blastRadius{workload: "default/web"},
+		},
+		{
+			name:   "cooldown over",
+			limits: nullpodytwofacev1.PodyTwoFaceLimits{Cooldown: &metav1.Duration{Duration: 5 * time.Minute}},
+			status: nullpodytwofacev1.PodyTwoFaceStatus{Workloads: []nullpodytwofacev1.WorkloadKill{
+				{Workload: "default/web", LastKillTime: ago(5 * time.Minute)},
+			}},
+			radius: blastRadius{workload: "default/web"},
+		},
+		{
+			name: "unavailability is checked first",
+			limits: nullpodytwofacev1.PodyTwoFaceLimits{
+				MaxUnavailablePerNamespace: 1,
+				MaxKillsPerMinute:          1,
+			},
+			status: nullpodytwofacev1.PodyTwoFaceStatus{RecentKills: []metav1.Time{ago(time.Second)}},
+			radius: blastRadius{namespaceUnavailable: 1},
+			wait:   unavailableRequeue,
+			reason: "1 pods unavailable in namespace",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := &nullpodytwofacev1.PodyTwoFace{
+				Spec:   nullpodytwofacev1.PodyTwoFaceSpec{Limits: tt.limits},
+				Status: tt.status,
+			}
+			wait, reason := checkLimits(policy, tt.radius, now)
+			if wait != tt.wait || reason != tt.reason {
+				t.Errorf("checkLimits() = %v, %q, want %v, %q", wait, reason, tt.wait, tt.reason)
+			}
+		})
+	}
+}
+
+func TestRecordKill(t *testing.T) {
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	ago := func(d time.Duration) metav1.Time {
+		return metav1.NewTime(now.Add(-d))
+	}
+
+	tests := []struct {
+		name     string
+		cooldown time.Duration
+		status   nullpodytwofacev1.PodyTwoFaceStatus
+		workload string
+		want     nullpodytwofacev1.PodyTwoFaceStatus
+	}{
+		{
+			name:     "first kill without a cooldown",
+			workload: "default/web",
+			want: nullpodytwofacev1.PodyTwoFaceStatus{
+				RecentKills: []metav1.Time{ago(0)},
+				Workloads:   []nullpodytwofacev1.WorkloadKill{},
+				Kills:       1,
+			},
+		},
+		{
+			name:     "kills outside the window are forgotten",
+			workload: "default/web",
+			status: nullpodytwofacev1.PodyTwoFaceStatus{
+				RecentKills: []metav1.Time{ago(2 * time.Minute), ago(30 * time.Second)},
+				Kills:       7,
+			},
+			want: nullpodytwofacev1.PodyTwoFaceStatus{
+				RecentKills: []metav1.Time{ago(30 * time.Second), ago(0)},
+				Workloads:   []nullpodytwofacev1.WorkloadKill{},
+				Kills:       8,
+			},
+		},
+		{
+			name:     "the workload starts cooling down again",
+			cooldown: 5 * time.Minute,
+			workload: "default/web",
+			status: nullpodytwofacev1.PodyTwoFaceStatus{
+				Workloads: []nullpodytwofacev1.WorkloadKill{
+					{Workload: "default/web", LastKillTime: ago(6 * time.Minute)},
+					{Workload: "default/db", LastKillTime: ago(time.Minute)},
+					{Workload: "default/cache", LastKillTime: ago(10 * time.Minute)},
+				},
+			},
+			want: nullpodytwofacev1.PodyTwoFaceStatus{
+				RecentKills: []metav1.Time{ago(0)},
+				Workloads: []nullpodytwofacev1.WorkloadKill{
+					{Workload: "default/db", LastKillTime: ago(time.Minute)},
+					{Workload: "default/web", LastKillTime: ago(0)},
+				},
+				Kills: 1,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := &nullpodytwofacev1.PodyTwoFace{Status: tt.status}
+			if tt.cooldown > 0 {
+				policy.Spec.Limits.Cooldown = &metav1.Duration{Duration: tt.cooldown}
+			}
+			recordKill(policy, tt.workload, now)
+			if !reflect.DeepEqual(policy.Status, tt.want) {
+				t.Errorf("recordKill() status = %+v, want %+v", policy.Status, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecordKillThenCheckLimits(t *testing.T) {
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	policy := &nullpodytwofacev1.PodyTwoFace{Spec: nullpodytwofacev1.PodyTwoFaceSpec{
+		Limits: nullpodytwofacev1.PodyTwoFaceLimits{MaxKillsPerMinute: 2},
+	}}
+
+	for i := 0; i < 2; i++ {
+		if wait, reason := checkLimits(policy, blastRadius{}, now); wait != 0 {
+			t.Fatalf("kill %d held back: %s", i+1, reason)
+		}
+		recordKill(policy, "default/web", now)
+		now = now.Add(10 * time.Second)
+	}
+
+	wait, reason := checkLimits(policy, blastRadius{}, now)
+	if wait != 40*time.Second || !strings.Contains(reason, "2 kills") {
+		t.Errorf("third kill: checkLimits() = %v, %q, want 40s", wait, reason)
+	}
+	if wait, _ := checkLimits(policy, blastRadius{}, now.Add(wait)); wait != 0 {
+		t.Errorf("after the window: checkLimits() = %v, want 0", wait)
+	}
+}
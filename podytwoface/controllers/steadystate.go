@@ -0,0 +1,202 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	nullpodytwofacev1 "github.com/null-channel/stupid-kube-operators/podytwoface/api/v1"
+)
+
+const (
+	defaultSteadyStateInterval = 30 * time.Second
+	defaultHTTPCheckTimeout    = 5 * time.Second
+)
+
+// MetricsClient runs the instant queries of steady-state Query checks.
+type MetricsClient interface {
+	// Query returns the single value query evaluates to.
+	Query(ctx context.Context, query string) (float64, error)
+}
+
+// PrometheusClient is a MetricsClient talking to the Prometheus HTTP API.
+type PrometheusClient struct {
+	// Address is the base URL of Prometheus, e.g. http://prometheus.monitoring:9090.
+	Address    string
+	HTTPClient *http.Client
+}
+
+func (p *PrometheusClient) Query(ctx context.Context, query string) (float64, error) {
+	u := strings.TrimSuffix(p.Address, "/") + "/api/v1/query?" + url.Values{"query": {query}}.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	httpClient := p.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Status string `json:"status"`
+		Error  string `json:"error"`
+		Data   struct {
+			ResultType string          `json:"resultType"`
+			Result     json.RawMessage `json:"result"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return 0, fmt.Errorf("decoding query response: %w", err)
+	}
+	if body.Status != "success" {
+		return 0, fmt.Errorf("query failed: %s", body.Error)
+	}
+
+	var sample []interface{}
+	switch body.Data.ResultType {
+	case "scalar":
+		if err := json.Unmarshal(body.Data.Result, &sample); err != nil {
+			return 0, err
+		}
+	case "vector":
+		var samples []struct {
+			Value []interface{} `json:"value"`
+		}
+		if err := json.Unmarshal(body.Data.Result, &samples); err != nil {
+			return 0, err
+		}
+		if len(samples) != 1 {
+			return 0, fmt.Errorf("query returned %d series, expected 1", len(samples))
+		}
+		sample = samples[0].Value
+	default:
+		return 0, fmt.Errorf("unsupported result type %q", body.Data.ResultType)
+	}
+
+	// Samples are [ <unix time>, "<value>" ].
+	if len(sample) != 2 {
+		return 0, errors.New("malformed sample")
+	}
+	value, ok := sample[1].(string)
+	if !ok {
+		return 0, errors.New("malformed sample value")
+	}
+	return strconv.ParseFloat(value, 64)
+}
+
+// steadyStateProber runs steady-state checks. Anything that keeps a check from
+// proving the steady state, an unreachable endpoint included, fails it.
+type steadyStateProber struct {
+	client.Client
+	HTTPClient *http.Client
+	Metrics    MetricsClient
+}
+
+// probe returns why check failed, or nil when it passed.
+func (p *steadyStateProber) probe(ctx context.Context, check nullpodytwofacev1.SteadyStateCheck) error {
+	switch {
+	case check.Deployment != nil:
+		return p.probeDeployment(ctx, check.Deployment)
+	case check.HTTP != nil:
+		return p.probeHTTP(ctx, check.HTTP)
+	case check.Query != nil:
+		return p.probeQuery(ctx, check.Query)
+	}
+	return errors.New("check has nothing to probe")
+}
+
+func (p *steadyStateProber) probeDeployment(ctx context.Context, check *nullpodytwofacev1.DeploymentCheck) error {
+	deployment := &appsv1.Deployment{}
+	if err := p.Get(ctx, client.ObjectKey{Namespace: check.Namespace, Name: check.Name}, deployment); err != nil {
+		return err
+	}
+
+	if deployment.Status.AvailableReplicas < check.MinAvailable {
+		return fmt.Errorf("deployment %s/%s has %d available replicas, wants at least %d",
+			check.Namespace, check.Name, deployment.Status.AvailableReplicas, check.MinAvailable)
+	}
+	return nil
+}
+
+func (p *steadyStateProber) probeHTTP(ctx context.Context, check *nullpodytwofacev1.HTTPCheck) error {
+	timeout := defaultHTTPCheckTimeout
+	if check.Timeout != nil {
+		timeout = check.Timeout.Duration
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, check.URL, nil)
+	if err != nil {
+		return err
+	}
+
+	httpClient := p.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("GET %s returned %s", check.URL, resp.Status)
+	}
+	return nil
+}
+
+func (p *steadyStateProber) probeQuery(ctx context.Context, check *nullpodytwofacev1.QueryCheck) error {
+	if p.Metrics == nil {
+		return errors.New("no metrics client configured")
+	}
+
+	value, err := p.Metrics.Query(ctx, check.Query)
+	if err != nil {
+		return err
+	}
+
+	if check.Min != nil && value < check.Min.AsApproximateFloat64() {
+		return fmt.Errorf("query returned %g, below the minimum of %s", value, check.Min.String())
+	}
+	if check.Max != nil && value > check.Max.AsApproximateFloat64() {
+		return fmt.Errorf("query returned %g, above the maximum of %s", value, check.Max.String())
+	}
+	return nil
+}
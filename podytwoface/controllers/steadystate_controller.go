@@ -0,0 +1,149 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+
+	nullpodytwofacev1 "github.com/null-channel/stupid-kube-operators/podytwoface/api/v1"
+)
+
+const (
+	reasonSteadyState     = "SteadyState"
+	reasonSteadyStateLost = "SteadyStateLost"
+)
+
+// SteadyStateReconciler watches over the steady state of PodyTwoFace policies
+// and aborts the ones that broke it.
+type SteadyStateReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+	// HTTPClient runs the HTTP checks. Defaults to http.DefaultClient.
+	HTTPClient *http.Client
+	// Metrics runs the Query checks. Policies with Query checks abort when it is nil.
+	Metrics MetricsClient
+}
+
+//+kubebuilder:rbac:groups=nullpodytwoface.thenullchannel.dev,resources=podytwofaces,verbs=get;list;watch
+//+kubebuilder:rbac:groups=nullpodytwoface.thenullchannel.dev,resources=podytwofaces/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch
+
+// Reconcile probes the steady-state checks of a policy every interval. The
+// first failing check sets the Aborted condition, which stops
+// PodyTwoFaceReconciler from hitting more pods for the policy. An abort sticks
+// until the spec of the policy changes, so a system that recovers on its own
+// does not get knocked over again without somebody looking at it.
+//
+// For more details, check Reconcile and its Result here:
+// - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.8.3/pkg/reconcile
+func (r *SteadyStateReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	policy := &nullpodytwofacev1.PodyTwoFace{}
+	if err := r.Get(ctx, req.NamespacedName, policy); err != nil {
+		if apierrors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+
+	aborted := meta.FindStatusCondition(policy.Status.Conditions, nullpodytwofacev1.ConditionAborted)
+	if aborted != nil && aborted.Status == metav1.ConditionTrue && aborted.ObservedGeneration == policy.Generation {
+		return ctrl.Result{}, nil
+	}
+
+	steadyState := policy.Spec.SteadyState
+	if steadyState == nil || len(steadyState.Checks) == 0 {
+		if aborted == nil {
+			return ctrl.Result{}, nil
+		}
+		meta.RemoveStatusCondition(&policy.Status.Conditions, nullpodytwofacev1.ConditionAborted)
+		return ctrl.Result{}, r.Status().Update(ctx, policy)
+	}
+
+	prober := &steadyStateProber{Client: r.Client, HTTPClient: r.HTTPClient, Metrics: r.Metrics}
+
+	condition := metav1.Condition{
+		Type:               nullpodytwofacev1.ConditionAborted,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: policy.Generation,
+		Reason:             reasonSteadyState,
+		Message:            "all steady-state checks pass",
+	}
+	for _, check := range steadyState.Checks {
+		if err := prober.probe(ctx, check); err != nil {
+			logger.Info("steady state lost, aborting policy", "policy", policy.Name, "check", check.Name, "reason", err.Error())
+			condition.Status = metav1.ConditionTrue
+			condition.Reason = reasonSteadyStateLost
+			condition.Message = fmt.Sprintf("check %q failed: %v", check.Name, err)
+			break
+		}
+	}
+
+	if conditionChanged(aborted, condition) {
+		meta.SetStatusCondition(&policy.Status.Conditions, condition)
+		if err := r.Status().Update(ctx, policy); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	if condition.Status == metav1.ConditionTrue {
+		return ctrl.Result{}, nil
+	}
+
+	interval := defaultSteadyStateInterval
+	if steadyState.Interval != nil {
+		interval = steadyState.Interval.Duration
+	}
+	return ctrl.Result{RequeueAfter: interval}, nil
+}
+
+// conditionChanged reports whether setting want over current changes anything but timestamps.
+func conditionChanged(current *metav1.Condition, want metav1.Condition) bool {
+	return current == nil ||
+		current.Status != want.Status ||
+		current.Reason != want.Reason ||
+		current.Message != want.Message ||
+		current.ObservedGeneration != want.ObservedGeneration
+}
+
+// policyAborted reports whether the steady-state checks of policy stopped it.
+func policyAborted(policy *nullpodytwofacev1.PodyTwoFace) bool {
+	return meta.IsStatusConditionTrue(policy.Status.Conditions, nullpodytwofacev1.ConditionAborted)
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *SteadyStateReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("steadystate").
+		// Status updates, ours and the kill counts, do not call for a new probe.
+		For(&nullpodytwofacev1.PodyTwoFace{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Complete(r)
+}
@@ -0,0 +1,156 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	nullpodytwofacev1 "github.com/null-channel/stupid-kube-operators/podytwoface/api/v1"
+)
+
+type fakeMetrics struct {
+	value float64
+	err   error
+}
+
+func (f *fakeMetrics) Query(context.Context, string) (float64, error) {
+	return f.value, f.err
+}
+
+var _ = Describe("Steady-state checks", func() {
+	ctx := context.Background()
+
+	Context("HTTP", func() {
+		var status int
+		var server *httptest.Server
+
+		BeforeEach(func() {
+			status = http.StatusOK
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(status)
+			}))
+		})
+
+		AfterEach(func() {
+			server.Close()
+		})
+
+		check := func() nullpodytwofacev1.SteadyStateCheck {
+			return nullpodytwofacev1.SteadyStateCheck{Name: "web", HTTP: &nullpodytwofacev1.HTTPCheck{URL: server.URL}}
+		}
+
+		It("passes on 2xx", func() {
+			prober := &steadyStateProber{HTTPClient: server.Client()}
+			Expect(prober.probe(ctx, check())).To(Succeed())
+		})
+
+		It("fails on anything else", func() {
+			status = http.StatusServiceUnavailable
+			prober := &steadyStateProber{HTTPClient: server.Client()}
+			Expect(prober.probe(ctx, check())).NotTo(Succeed())
+		})
+
+		It("fails when the endpoint is gone", func() {
+			c := check()
+			server.Close()
+			prober := &steadyStateProber{}
+			Expect(prober.probe(ctx, c)).NotTo(Succeed())
+		})
+	})
+
+	Context("Query", func() {
+		min := resource.MustParse("0.5")
+		max := resource.MustParse("2")
+		check := nullpodytwofacev1.SteadyStateCheck{
+			Name:  "success-rate",
+			Query: &nullpodytwofacev1.QueryCheck{Query: "up", Min: &min, Max: &max},
+		}
+
+		It("passes within bounds", func() {
+			prober := &steadyStateProber{Metrics: &fakeMetrics{value: 1}}
+			Expect(prober.probe(ctx, check)).To(Succeed())
+		})
+
+		It("fails out of bounds", func() {
+			prober := &steadyStateProber{Metrics: &fakeMetrics{value: 0.1}}
+			Expect(prober.probe(ctx, check)).NotTo(Succeed())
+
+			prober = &steadyStateProber{Metrics: &fakeMetrics{value: 3}}
+			Expect(prober.probe(ctx, check)).NotTo(Succeed())
+		})
+
+		It("fails when the query does", func() {
+			prober := &steadyStateProber{Metrics: &fakeMetrics{err: errors.New("boom")}}
+			Expect(prober.probe(ctx, check)).NotTo(Succeed())
+
+			prober = &steadyStateProber{}
+			Expect(prober.probe(ctx, check)).NotTo(Succeed())
+		})
+	})
+
+	Context("PrometheusClient", func() {
+		serve := func(body string) *httptest.Server {
+			return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				Expect(r.URL.Path).To(Equal("/api/v1/query"))
+				Expect(r.URL.Query().Get("query")).To(Equal("up"))
+				fmt.Fprint(w, body)
+			}))
+		}
+
+		It("reads vectors with one series", func() {
+			server := serve(`{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[1620000000,"0.75"]}]}}`)
+			defer server.Close()
+
+			value, err := (&PrometheusClient{Address: server.URL}).Query(ctx, "up")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(value).To(Equal(0.75))
+		})
+
+		It("reads scalars", func() {
+			server := serve(`{"status":"success","data":{"resultType":"scalar","result":[1620000000,"3"]}}`)
+			defer server.Close()
+
+			value, err := (&PrometheusClient{Address: server.URL}).Query(ctx, "up")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(value).To(Equal(3.0))
+		})
+
+		It("refuses vectors with several series", func() {
+			server := serve(`{"status":"success","data":{"resultType":"vector","result":[{"value":[1,"1"]},{"value":[1,"2"]}]}}`)
+			defer server.Close()
+
+			_, err := (&PrometheusClient{Address: server.URL}).Query(ctx, "up")
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("reports query errors", func() {
+			server := serve(`{"status":"error","error":"parse error"}`)
+			defer server.Close()
+
+			_, err := (&PrometheusClient{Address: server.URL}).Query(ctx, "up")
+			Expect(err).To(MatchError(ContainSubstring("parse error")))
+		})
+	})
+})